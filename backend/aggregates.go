@@ -0,0 +1,444 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Dimensions of metrics_counts. Each row counts responses with one value of a
+// dimension; feature dimensions hold score sums and per-score histograms.
+const (
+	aggregateTotal          = "total"
+	aggregateBetaInterest   = "betaInterest"
+	aggregateFeatureSum     = "featureSum"
+	aggregateFeatureScore   = "featureScore."
+	aggregateUsageFrequency = "usageFrequency"
+	aggregateTeamSize       = "teamSize"
+	aggregatePricingModel   = "pricingModel"
+	aggregateLanguage       = "language"
+	aggregateDistribution   = "distribution."
+)
+
+// createAggregateTables creates the precomputed metrics tables, filling them
+// from survey_responses the first time
+func createAggregateTables() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS metrics_counts (
+		dimension TEXT NOT NULL,
+		value TEXT NOT NULL,
+		count INTEGER NOT NULL,
+		PRIMARY KEY (dimension, value)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	sums := make([]string, len(featureNames))
+	for i, name := range featureNames {
+		sums[i] = featureColumns[name] + " INTEGER NOT NULL DEFAULT 0"
+	}
+	// Submissions per 15 minute UTC slot, which roll up into days in any
+	// time zone the same way /metrics/timeseries does. Each feature keeps its
+	// score sum and the number of responses that answered it.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS metrics_time_slots (
+		slot INTEGER PRIMARY KEY,
+		responses INTEGER NOT NULL DEFAULT 0,
+		beta_interest INTEGER NOT NULL DEFAULT 0,
+		` + strings.Join(sums, ",\n\t\t") + `
+	)`)
+	if err != nil {
+		return err
+	}
+	// Slots stored before answered counts were kept have none, so adding the
+	// columns means filling the table again
+	stale := false
+	for _, name := range featureNames {
+		var count int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('metrics_time_slots') WHERE name = ?`,
+			answeredColumn(name)).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			stale = true
+			if err := ensureColumn("metrics_time_slots", answeredColumn(name), "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+		}
+	}
+
+	var built int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM metrics_counts WHERE dimension = ?`, aggregateTotal).Scan(&built); err != nil {
+		return err
+	}
+	if built == 0 || stale {
+		return rebuildAggregates(nil)
+	}
+	return nil
+}
+
+// answeredColumn is the metrics_time_slots column counting responses that
+// gave a feature a score; skipped questions (0) are left out of its average
+func answeredColumn(name string) string {
+	return featureColumns[name] + "_answered"
+}
+
+// aggregateCounts flattens a metrics delta plus the feature histograms of
+// the changed responses into metrics_counts rows
+func aggregateCounts(delta MetricsDelta, previous, current *SurveyResponse) map[[2]string]int {
+	counts := make(map[[2]string]int)
+	add := func(dimension, value string, n int) {
+		if n != 0 {
+			counts[[2]string{dimension, value}] += n
+		}
+	}
+	add(aggregateTotal, "", delta.TotalResponses)
+	add(aggregateBetaInterest, "", delta.BetaInterestCount)
+	for name, sum := range delta.FeatureScoreSums {
+		add(aggregateFeatureSum, name, sum)
+	}
+	for dimension, values := range map[string]map[string]int{
+		aggregateUsageFrequency: delta.UsageFrequencyStats,
+		aggregateTeamSize:       delta.TeamSizeDistribution,
+		aggregatePricingModel:   delta.PricingPreferences,
+		aggregateLanguage:       delta.LanguageDistribution,
+	} {
+		for value, n := range values {
+			add(dimension, value, n)
+		}
+	}
+	for key, values := range delta.Distributions {
+		for value, n := range values {
+			add(aggregateDistribution+key, value, n)
+		}
+	}
+	for _, change := range []struct {
+		survey *SurveyResponse
+		sign   int
+	}{{previous, -1}, {current, 1}} {
+		if change.survey == nil {
+			continue
+		}
+		for name, score := range featureScores(change.survey) {
+			if score >= 1 && score <= maxFeatureScore {
+				add(aggregateFeatureScore+name, strconv.Itoa(score), change.sign)
+			}
+		}
+	}
+	return counts
+}
+
+func featureScores(survey *SurveyResponse) map[string]int {
+	return map[string]int{
+		"offline":         survey.Features.Offline,
+		"collaboration":   survey.Features.Collaboration,
+		"assetManagement": survey.Features.AssetManagement,
+		"pdfHandling":     survey.Features.PdfHandling,
+		"versionControl":  survey.Features.VersionControl,
+		"workflows":       survey.Features.Workflows,
+	}
+}
+
+// applyMetricsDelta updates the aggregate tables for a changed response in
+// the caller's transaction, so they always agree with survey_responses.
+// previous is nil for new responses and current nil for deleted ones.
+func applyMetricsDelta(tx *sql.Tx, previous, current *SurveyResponse) error {
+	for key, n := range aggregateCounts(responseDelta(previous, current), previous, current) {
+		if _, err := tx.Exec(`INSERT INTO metrics_counts (dimension, value, count) VALUES (?, ?, ?)
+			ON CONFLICT (dimension, value) DO UPDATE SET count = count + excluded.count`,
+			key[0], key[1], n); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM metrics_counts WHERE dimension = ? AND value = ? AND count = 0`,
+			key[0], key[1]); err != nil {
+			return err
+		}
+	}
+	if err := addToTimeSlot(tx, previous, -1); err != nil {
+		return err
+	}
+	return addToTimeSlot(tx, current, 1)
+}
+
+func addToTimeSlot(tx *sql.Tx, survey *SurveyResponse, sign int) error {
+	if survey == nil {
+		return nil
+	}
+	slot := survey.CreatedAt.Unix() / timeseriesSlotSeconds
+	beta := 0
+	if survey.BetaInterest {
+		beta = sign
+	}
+	columns := []string{"slot", "responses", "beta_interest"}
+	args := []interface{}{slot, sign, beta}
+	scores := featureScores(survey)
+	for _, name := range featureNames {
+		answered := 0
+		if scores[name] != 0 {
+			answered = sign
+		}
+		columns = append(columns, featureColumns[name], answeredColumn(name))
+		args = append(args, sign*scores[name], answered)
+	}
+	var updates []string
+	for _, column := range columns[1:] {
+		updates = append(updates, column+" = "+column+" + excluded."+column)
+	}
+	_, err := tx.Exec(`INSERT INTO metrics_time_slots (`+strings.Join(columns, ", ")+`)
+		VALUES (?`+strings.Repeat(", ?", len(columns)-1)+`)
+		ON CONFLICT (slot) DO UPDATE SET `+strings.Join(updates, ", "), args...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM metrics_time_slots WHERE slot = ? AND responses = 0`, slot)
+	return err
+}
+
+// rebuildAggregates recomputes the aggregate tables from survey_responses
+func rebuildAggregates(args []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM metrics_counts`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM metrics_time_slots`); err != nil {
+		return err
+	}
+	// Read everything first; the connection can't write while rows are open
+	rows, err := tx.Query(`SELECT ` + surveyResponseColumns + ` FROM survey_responses`)
+	if err != nil {
+		return err
+	}
+	var responses []SurveyResponse
+	for rows.Next() {
+		response, err := scanSurveyResponse(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		responses = append(responses, response)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	counts := make(map[[2]string]int)
+	for i := range responses {
+		delta := newMetricsDelta()
+		delta.addResponse(&responses[i], 1)
+		for key, n := range aggregateCounts(delta, nil, &responses[i]) {
+			counts[key] += n
+		}
+		if err := addToTimeSlot(tx, &responses[i], 1); err != nil {
+			return err
+		}
+	}
+	// Keep the total row even for an empty table so startup doesn't rebuild
+	counts[[2]string{aggregateTotal, ""}] += 0
+	for key, n := range counts {
+		if _, err := tx.Exec(`INSERT INTO metrics_counts (dimension, value, count) VALUES (?, ?, ?)`,
+			key[0], key[1], n); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Rebuilt metrics aggregates from %d responses", len(responses))
+	return nil
+}
+
+// aggregateMetrics reads the unfiltered /metrics numbers from metrics_counts,
+// along with the feature score histograms
+func aggregateMetrics() (Metrics, map[string][]int, error) {
+	metrics := Metrics{
+		AverageFeatureScores: make(map[string]float64),
+		UsageFrequencyStats:  make(map[string]int),
+		TeamSizeDistribution: make(map[string]int),
+		PricingPreferences:   make(map[string]int),
+		LanguageDistribution: make(map[string]int),
+		Distributions:        make(map[string]map[string]int),
+	}
+	histograms := make(map[string][]int)
+	for _, field := range distributionFields {
+		metrics.Distributions[field.key] = make(map[string]int)
+	}
+	for _, name := range featureNames {
+		histograms[name] = make([]int, maxFeatureScore)
+	}
+
+	rows, err := db.Query(`SELECT dimension, value, count FROM metrics_counts WHERE count != 0`)
+	if err != nil {
+		return metrics, nil, err
+	}
+	defer rows.Close()
+
+	sums := make(map[string]int)
+	for rows.Next() {
+		var dimension, value string
+		var count int
+		if err := rows.Scan(&dimension, &value, &count); err != nil {
+			return metrics, nil, err
+		}
+		switch {
+		case dimension == aggregateTotal:
+			metrics.TotalResponses = count
+		case dimension == aggregateBetaInterest:
+			metrics.BetaInterestCount = count
+		case dimension == aggregateFeatureSum:
+			sums[value] = count
+		case dimension == aggregateUsageFrequency:
+			metrics.UsageFrequencyStats[value] = count
+		case dimension == aggregateTeamSize:
+			metrics.TeamSizeDistribution[value] = count
+		case dimension == aggregatePricingModel:
+			metrics.PricingPreferences[value] = count
+		case dimension == aggregateLanguage:
+			metrics.LanguageDistribution[value] = count
+		case strings.HasPrefix(dimension, aggregateFeatureScore):
+			score, err := strconv.Atoi(value)
+			histogram, ok := histograms[strings.TrimPrefix(dimension, aggregateFeatureScore)]
+			if err == nil && ok && score >= 1 && score <= maxFeatureScore {
+				histogram[score-1] = count
+			}
+		case strings.HasPrefix(dimension, aggregateDistribution):
+			if counts, ok := metrics.Distributions[strings.TrimPrefix(dimension, aggregateDistribution)]; ok {
+				counts[value] = count
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return metrics, nil, err
+	}
+
+	// Skipped questions add nothing to the sums, so the answered count comes
+	// from the histogram
+	for _, name := range featureNames {
+		answered := 0
+		for _, count := range histograms[name] {
+			answered += count
+		}
+		metrics.AverageFeatureScores[name] = 0
+		if answered > 0 {
+			metrics.AverageFeatureScores[name] = float64(sums[name]) / float64(answered)
+		}
+	}
+	return metrics, histograms, nil
+}
+
+// checkAggregates compares the aggregate tables with live queries over
+// survey_responses and fails if they disagree
+func checkAggregates(args []string) error {
+	live, liveHistograms, err := liveMetrics(responseFilter{})
+	if err != nil {
+		return err
+	}
+	stored, storedHistograms, err := aggregateMetrics()
+	if err != nil {
+		return err
+	}
+	for key, value := range live.AverageFeatureScores {
+		live.AverageFeatureScores[key] = roundTo(value, 2)
+	}
+	for key, value := range stored.AverageFeatureScores {
+		stored.AverageFeatureScores[key] = roundTo(value, 2)
+	}
+
+	var mismatches []string
+	compare := func(name string, want, got interface{}) {
+		if !reflect.DeepEqual(want, got) {
+			mismatches = append(mismatches, fmt.Sprintf("%s: live %v, aggregate %v", name, want, got))
+		}
+	}
+	compare("totalResponses", live.TotalResponses, stored.TotalResponses)
+	compare("betaInterestCount", live.BetaInterestCount, stored.BetaInterestCount)
+	compare("averageFeatureScores", live.AverageFeatureScores, stored.AverageFeatureScores)
+	compare("usageFrequencyStats", live.UsageFrequencyStats, stored.UsageFrequencyStats)
+	compare("teamSizeDistribution", live.TeamSizeDistribution, stored.TeamSizeDistribution)
+	compare("pricingPreferences", live.PricingPreferences, stored.PricingPreferences)
+	compare("languageDistribution", live.LanguageDistribution, stored.LanguageDistribution)
+	compare("distributions", live.Distributions, stored.Distributions)
+	compare("featureHistograms", liveHistograms, storedHistograms)
+
+	slotMismatches, err := checkTimeSlots()
+	if err != nil {
+		return err
+	}
+	mismatches = append(mismatches, slotMismatches...)
+
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		for _, mismatch := range mismatches {
+			log.Println(mismatch)
+		}
+		return fmt.Errorf("%d aggregates differ from live queries; run rebuild-aggregates", len(mismatches))
+	}
+	log.Printf("Metrics aggregates match %d responses", live.TotalResponses)
+	return nil
+}
+
+// checkTimeSlots compares metrics_time_slots with a live GROUP BY
+func checkTimeSlots() ([]string, error) {
+	var stored, live []string
+	for _, name := range featureNames {
+		stored = append(stored, featureColumns[name], answeredColumn(name))
+		live = append(live, "SUM("+featureColumns[name]+")", "COUNT(NULLIF("+featureColumns[name]+", 0))")
+	}
+	readSlots := func(query string) (map[int64][]int64, error) {
+		rows, err := db.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		slots := make(map[int64][]int64)
+		for rows.Next() {
+			var slot int64
+			values := make([]int64, len(stored)+2)
+			dest := []interface{}{&slot}
+			for i := range values {
+				dest = append(dest, &values[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return nil, err
+			}
+			slots[slot] = values
+		}
+		return slots, rows.Err()
+	}
+
+	liveSlots, err := readSlots(fmt.Sprintf(`
+		SELECT CAST(strftime('%%s', created_at) AS INTEGER) / %d AS slot, COUNT(*),
+			COALESCE(SUM(CASE WHEN beta_interest = 1 THEN 1 ELSE 0 END), 0), %s
+		FROM survey_responses
+		GROUP BY slot`, timeseriesSlotSeconds, strings.Join(live, ", ")))
+	if err != nil {
+		return nil, err
+	}
+	storedSlots, err := readSlots(`SELECT slot, responses, beta_interest, ` + strings.Join(stored, ", ") +
+		` FROM metrics_time_slots WHERE responses != 0`)
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatches []string
+	for slot, want := range liveSlots {
+		if got := storedSlots[slot]; !reflect.DeepEqual(want, got) {
+			mismatches = append(mismatches, fmt.Sprintf("time slot %d: live %v, aggregate %v", slot, want, got))
+		}
+	}
+	for slot, got := range storedSlots {
+		if _, ok := liveSlots[slot]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("time slot %d: live [], aggregate %v", slot, got))
+		}
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAggregateCounts(t *testing.T) {
+	previous := &SurveyResponse{Role: "developer", TeamSize: "1-5", Features: Features{Offline: 4}}
+	current := &SurveyResponse{Role: "developer", TeamSize: "6-20", BetaInterest: true, Features: Features{Offline: 5}}
+	delta := newMetricsDelta()
+	delta.addResponse(previous, -1)
+	delta.addResponse(current, 1)
+
+	counts := aggregateCounts(delta, previous, current)
+	want := map[[2]string]int{
+		{aggregateBetaInterest, ""}:                   1,
+		{aggregateFeatureSum, "offline"}:              1,
+		{aggregateFeatureScore + "offline", "4"}:      -1,
+		{aggregateFeatureScore + "offline", "5"}:      1,
+		{aggregateTeamSize, "1-5"}:                    -1,
+		{aggregateTeamSize, "6-20"}:                   1,
+		{aggregateDistribution + "teamSizes", "1-5"}:  -1,
+		{aggregateDistribution + "teamSizes", "6-20"}: 1,
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("aggregateCounts() = %v, want %v", counts, want)
+	}
+}
+
+func TestAggregatesFollowWrites(t *testing.T) {
+	openTestDB(t)
+	for _, body := range []string{
+		`{"role":"developer","cmsUsage":"wordpress","teamSize":"1-5","usageFrequency":"daily","features":{"offline":4,"workflows":2}}`,
+		`{"role":"designer","cmsUsage":"none","teamSize":"6-20","betaInterest":true,"email":"ada@example.com","features":{"offline":5}}`,
+		`{"role":"developer","platforms":"web, desktop","pricingModel":"subscription"}`,
+	} {
+		submitTestSurvey(t, body)
+	}
+	edited := submitTestSurvey(t, `{"role":"marketer","cmsUsage":"drupal","features":{"pdfHandling":3}}`)
+	deleted := submitTestSurvey(t, `{"role":"developer","cmsUsage":"wordpress","features":{"collaboration":1}}`)
+
+	recorder := callHandler(updateResult, "PUT", "/results/"+edited.ID,
+		strings.NewReader(`{"role":"marketer","cmsUsage":"drupal","teamSize":"20+","features":{"pdfHandling":5,"offline":1}}`),
+		gin.Param{Key: "id", Value: edited.ID})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("updateResult returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := callHandler(deleteResult, "DELETE", "/results/"+deleted.ID, nil, gin.Param{Key: "id", Value: deleted.ID}); recorder.Code != http.StatusOK {
+		t.Fatalf("deleteResult returned %d", recorder.Code)
+	}
+
+	if err := checkAggregates(nil); err != nil {
+		t.Fatalf("aggregates drifted from survey_responses: %v", err)
+	}
+
+	// Unfiltered /metrics reads the aggregates and must agree with a live query
+	var unfiltered Metrics
+	if err := json.Unmarshal(callHandler(getMetrics, "GET", "/metrics", nil).Body.Bytes(), &unfiltered); err != nil {
+		t.Fatal(err)
+	}
+	computed, err := computeMetrics(responseFilter{clauses: []string{"1 = 1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Compare what clients see, not the unexported ranking fields
+	var live Metrics
+	encoded, err := json.Marshal(computed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(encoded, &live); err != nil {
+		t.Fatal(err)
+	}
+	if unfiltered.TotalResponses != 4 || !reflect.DeepEqual(unfiltered.AverageFeatureScores, live.AverageFeatureScores) ||
+		!reflect.DeepEqual(unfiltered.Distributions, live.Distributions) || !reflect.DeepEqual(unfiltered.FeatureStats, live.FeatureStats) {
+		t.Errorf("aggregate metrics %+v differ from live %+v", unfiltered, live)
+	}
+}
+
+func TestRebuildAggregates(t *testing.T) {
+	openTestDB(t)
+	submitTestSurvey(t, `{"role":"developer","teamSize":"1-5","features":{"offline":4}}`)
+	submitTestSurvey(t, `{"role":"designer","features":{"offline":2}}`)
+
+	for _, statement := range []string{
+		`UPDATE metrics_counts SET count = 7 WHERE dimension = 'total'`,
+		`UPDATE metrics_time_slots SET offline = offline + 1`,
+		// Writes outside the handlers are not reflected until a rebuild
+		`UPDATE survey_responses SET team_size = '6-20'`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkAggregates(nil); err == nil {
+			t.Errorf("checkAggregates passed after %s", statement)
+		}
+		if err := rebuildAggregates(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkAggregates(nil); err != nil {
+			t.Errorf("checkAggregates after a rebuild: %v", err)
+		}
+	}
+
+	// Startup fills empty aggregate tables from existing responses
+	if _, err := db.Exec(`DELETE FROM metrics_counts`); err != nil {
+		t.Fatal(err)
+	}
+	if err := createAggregateTables(); err != nil {
+		t.Fatal(err)
+	}
+	metrics, _, err := aggregateMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.TotalResponses != 2 || metrics.TeamSizeDistribution["6-20"] != 2 {
+		t.Errorf("aggregates after startup = %+v", metrics)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const maxAttachmentsPerResponse = 5
+
+// allowedAttachmentTypes are checked against the sniffed content, never the
+// type the client claims
+var allowedAttachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+var storageKeyRegex = regexp.MustCompile(`^[a-f0-9-]{36}$`)
+
+// AttachmentStorage stores attachment bodies by an opaque key
+type AttachmentStorage interface {
+	Save(key string, r io.Reader) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// localStorage keeps attachments as files in a single directory
+type localStorage struct {
+	dir string
+}
+
+func newLocalStorage(dir string) (*localStorage, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &localStorage{dir: dir}, nil
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	if !storageKeyRegex.MatchString(key) {
+		return "", fmt.Errorf("invalid storage key")
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+func (s *localStorage) Save(key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func (s *localStorage) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *localStorage) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+var attachmentStore AttachmentStorage
+
+type Attachment struct {
+	ID          string    `json:"id"`
+	ResponseID  string    `json:"responseId"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
+	storageKey  string
+}
+
+// pendingAttachment is an uploaded file that has been checked but not yet stored
+type pendingAttachment struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+func createAttachmentsTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS survey_attachments (
+		id TEXT PRIMARY KEY,
+		response_id TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		storage_key TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_survey_attachments_response
+		ON survey_attachments (response_id)`)
+	return err
+}
+
+// maxAttachmentBytes is the per-file upload limit
+func maxAttachmentBytes() int64 {
+	size, err := strconv.ParseInt(getEnvWithFallback("ATTACHMENT_MAX_BYTES", "5242880"), 10, 64)
+	if err != nil || size <= 0 {
+		return 5 << 20
+	}
+	return size
+}
+
+// sanitizeFilename keeps only the base name and strips characters that could
+// break a Content-Disposition header
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || r == '"' || r == '/' || r == '\\' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." {
+		return "attachment"
+	}
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	return name
+}
+
+func readAttachment(header *multipart.FileHeader) (pendingAttachment, error) {
+	limit := maxAttachmentBytes()
+	if header.Size > limit {
+		return pendingAttachment{}, fmt.Errorf("%s is larger than %d bytes", header.Filename, limit)
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		return pendingAttachment{}, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return pendingAttachment{}, err
+	}
+	if int64(len(data)) > limit {
+		return pendingAttachment{}, fmt.Errorf("%s is larger than %d bytes", header.Filename, limit)
+	}
+
+	contentType := strings.SplitN(http.DetectContentType(data), ";", 2)[0]
+	if !allowedAttachmentTypes[contentType] {
+		return pendingAttachment{}, fmt.Errorf("%s has unsupported type %s", header.Filename, contentType)
+	}
+
+	return pendingAttachment{
+		filename:    sanitizeFilename(header.Filename),
+		contentType: contentType,
+		data:        data,
+	}, nil
+}
+
+// bindSubmission reads a survey submission from either a JSON body or a
+// multipart form with the JSON in a "survey" field and files in "attachments"
+func bindSubmission(c *gin.Context, submission *surveySubmission) ([]pendingAttachment, error) {
+	if !strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		return nil, c.ShouldBindJSON(submission)
+	}
+
+	maxBody := maxAttachmentBytes()*maxAttachmentsPerResponse + 1<<20
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBody)
+	if err := c.Request.ParseMultipartForm(maxBody); err != nil {
+		return nil, fmt.Errorf("invalid multipart form: %v", err)
+	}
+	if err := json.Unmarshal([]byte(c.Request.FormValue("survey")), submission); err != nil {
+		return nil, fmt.Errorf("invalid survey field: %v", err)
+	}
+
+	headers := c.Request.MultipartForm.File["attachments"]
+	if len(headers) > maxAttachmentsPerResponse {
+		return nil, fmt.Errorf("at most %d attachments are allowed", maxAttachmentsPerResponse)
+	}
+	attachments := make([]pendingAttachment, 0, len(headers))
+	for _, header := range headers {
+		attachment, err := readAttachment(header)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// storeAttachments writes the files to storage and records them against the
+// response. The returned keys must be deleted if the transaction is not committed.
+func storeAttachments(tx *sql.Tx, responseID string, pending []pendingAttachment) ([]Attachment, []string, error) {
+	var stored []Attachment
+	var keys []string
+	for _, p := range pending {
+		attachment := Attachment{
+			ID:          uuid.New().String(),
+			ResponseID:  responseID,
+			Filename:    p.filename,
+			ContentType: p.contentType,
+			Size:        int64(len(p.data)),
+			CreatedAt:   time.Now(),
+			storageKey:  uuid.New().String(),
+		}
+		if err := attachmentStore.Save(attachment.storageKey, bytes.NewReader(p.data)); err != nil {
+			return nil, keys, err
+		}
+		keys = append(keys, attachment.storageKey)
+
+		_, err := tx.Exec(`INSERT INTO survey_attachments (id, response_id, filename, content_type, size, storage_key, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			attachment.ID, attachment.ResponseID, attachment.Filename, attachment.ContentType,
+			attachment.Size, attachment.storageKey, attachment.CreatedAt)
+		if err != nil {
+			return nil, keys, err
+		}
+		stored = append(stored, attachment)
+	}
+	return stored, keys, nil
+}
+
+func deleteStoredFiles(keys []string) {
+	for _, key := range keys {
+		if err := attachmentStore.Delete(key); err != nil {
+			log.Printf("Failed to delete attachment %s: %v", key, err)
+		}
+	}
+}
+
+// detachAttachments removes the attachment rows of a response and returns
+// their storage keys so the files can be deleted once the transaction commits
+func detachAttachments(tx *sql.Tx, responseID string) ([]string, error) {
+	rows, err := tx.Query(`SELECT storage_key FROM survey_attachments WHERE response_id = ?`, responseID)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM survey_attachments WHERE response_id = ?`, responseID)
+	return keys, err
+}
+
+func getResultAttachments(c *gin.Context) {
+	attachments, err := responseAttachments(db, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, attachments)
+}
+
+func responseAttachments(q queryer, responseID string) ([]Attachment, error) {
+	rows, err := q.Query(`SELECT id, response_id, filename, content_type, size, created_at
+		FROM survey_attachments WHERE response_id = ? ORDER BY created_at`, responseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.ID, &a.ResponseID, &a.Filename, &a.ContentType, &a.Size, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+func downloadAttachment(c *gin.Context) {
+	var a Attachment
+	err := db.QueryRow(`SELECT id, filename, content_type, size, storage_key
+		FROM survey_attachments WHERE id = ?`, c.Param("id")).
+		Scan(&a.ID, &a.Filename, &a.ContentType, &a.Size, &a.storageKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	body, err := attachmentStore.Open(a.storageKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer body.Close()
+
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'")
+	c.DataFromReader(http.StatusOK, a.Size, a.ContentType, body, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, a.Filename),
+	})
+}
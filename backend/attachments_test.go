@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := map[string]string{
+		"screenshot.png":         "screenshot.png",
+		"../../etc/passwd":       "passwd",
+		`C:\Users\me\report.pdf`: "report.pdf",
+		"quo\"te\r\n.png":        "quote.png",
+		"":                       "attachment",
+		"/":                      "attachment",
+	}
+	for input, want := range tests {
+		if got := sanitizeFilename(input); got != want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestLocalStorageRejectsInvalidKeys(t *testing.T) {
+	store, err := newLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("../escape", bytes.NewReader(nil)); err == nil {
+		t.Error("Save accepted a key outside the storage directory")
+	}
+	if _, err := store.Open("not-a-uuid"); err == nil {
+		t.Error("Open accepted an invalid key")
+	}
+}
+
+// multipartSubmission builds a survey form with the given files as attachments
+func multipartSubmission(t *testing.T, survey string, files map[string][]byte) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("survey", survey); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		part, err := writer.CreateFormFile("attachments", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, writer.FormDataContentType()
+}
+
+func postMultipart(body *bytes.Buffer, contentType string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/survey", body)
+	c.Request.Header.Set("Content-Type", contentType)
+	submitSurvey(c)
+	return recorder
+}
+
+func useTestStorage(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := newLocalStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := attachmentStore
+	attachmentStore = store
+	t.Cleanup(func() { attachmentStore = previous })
+	return dir
+}
+
+func TestSubmitWithAttachments(t *testing.T) {
+	openTestDB(t)
+	dir := useTestStorage(t)
+
+	body, contentType := multipartSubmission(t, `{"role":"developer","cmsUsage":"wordpress"}`,
+		map[string][]byte{"../shot.png": testPNG})
+	recorder := postMultipart(body, contentType)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("submitSurvey returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var result surveySubmissionResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Attachments) != 1 {
+		t.Fatalf("got %d attachments, want 1", len(result.Attachments))
+	}
+	attachment := result.Attachments[0]
+	if attachment.Filename != "shot.png" || attachment.ContentType != "image/png" || attachment.Size != int64(len(testPNG)) {
+		t.Errorf("unexpected attachment %+v", attachment)
+	}
+
+	download := callHandler(downloadAttachment, "GET", "/attachments/"+attachment.ID, nil,
+		gin.Param{Key: "id", Value: attachment.ID})
+	if download.Code != http.StatusOK || !bytes.Equal(download.Body.Bytes(), testPNG) {
+		t.Errorf("download returned %d with %d bytes", download.Code, download.Body.Len())
+	}
+	if got := download.Header().Get("Content-Disposition"); got != `attachment; filename="shot.png"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+
+	// Deleting the response removes the stored file as well
+	deleted := callHandler(deleteResult, "DELETE", "/results/"+result.ID, nil, gin.Param{Key: "id", Value: result.ID})
+	if deleted.Code != http.StatusOK {
+		t.Fatalf("deleteResult returned %d", deleted.Code)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("%d files left in storage after delete", len(files))
+	}
+}
+
+func TestSubmitRejectsUnsupportedAttachments(t *testing.T) {
+	openTestDB(t)
+	dir := useTestStorage(t)
+	t.Setenv("ATTACHMENT_MAX_BYTES", "64")
+
+	tests := map[string][]byte{
+		"script.png": []byte("<html><script>alert(1)</script></html>"),
+		"large.png":  append(append([]byte{}, testPNG...), make([]byte, 64)...),
+	}
+	for name, data := range tests {
+		body, contentType := multipartSubmission(t, `{"role":"developer","cmsUsage":"wordpress"}`,
+			map[string][]byte{name: data})
+		if recorder := postMultipart(body, contentType); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, want 400", name, recorder.Code)
+		}
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM survey_responses`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if count != 0 || len(files) != 0 {
+		t.Errorf("stored %d responses and %d files for rejected uploads", count, len(files))
+	}
+}
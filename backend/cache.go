@@ -0,0 +1,220 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cachedTables are the tables /results and /metrics read. Any write to them
+// bumps the data version, which invalidates every cached query; triggers do
+// this so writes from maintenance commands count too.
+var cachedTables = []string{"survey_responses", "survey_response_sentiment", "survey_codes", "survey_code_applications",
+	"metrics_counts", "metrics_time_slots"}
+
+func createCacheVersionTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS cache_version (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		version INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`INSERT OR IGNORE INTO cache_version (id, version) VALUES (1, 0)`); err != nil {
+		return err
+	}
+	for _, table := range cachedTables {
+		for _, operation := range []string{"INSERT", "UPDATE", "DELETE"} {
+			_, err := db.Exec(fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS cache_version_%s_%s AFTER %s ON %s
+				BEGIN UPDATE cache_version SET version = version + 1 WHERE id = 1; END`,
+				table, operation, operation, table))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func dataVersion() (int64, error) {
+	var version int64
+	err := db.QueryRow(`SELECT version FROM cache_version WHERE id = 1`).Scan(&version)
+	return version, err
+}
+
+// cacheMaxEntries bounds the number of distinct queries kept
+func cacheMaxEntries() int {
+	entries, err := strconv.Atoi(getEnvWithFallback("CACHE_MAX_ENTRIES", "256"))
+	if err != nil || entries <= 0 {
+		return 256
+	}
+	return entries
+}
+
+type cacheEntry struct {
+	key      string
+	version  int64
+	body     []byte
+	etag     string
+	storedAt time.Time
+}
+
+// queryCache keeps the JSON of recent queries, least recently used first out
+type queryCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+
+	hits, misses, stale, evictions int64
+}
+
+var responseCache = &queryCache{entries: make(map[string]*list.Element), order: list.New()}
+
+// CacheStats reports how well the query cache is doing
+type CacheStats struct {
+	Entries    int     `json:"entries"`
+	MaxEntries int     `json:"maxEntries"`
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	HitRate    float64 `json:"hitRate"`
+	// Stale counts misses caused by writes since the entry was cached
+	Stale       int64 `json:"stale"`
+	Evictions   int64 `json:"evictions"`
+	DataVersion int64 `json:"dataVersion"`
+}
+
+func (q *queryCache) get(key string, version int64) (*cacheEntry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	element, ok := q.entries[key]
+	if !ok {
+		q.misses++
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if entry.version != version {
+		q.stale++
+		q.misses++
+		q.order.Remove(element)
+		delete(q.entries, key)
+		return nil, false
+	}
+	q.hits++
+	q.order.MoveToFront(element)
+	return entry, true
+}
+
+func (q *queryCache) put(entry *cacheEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if element, ok := q.entries[entry.key]; ok {
+		// A concurrent miss may have stored an older version already
+		if element.Value.(*cacheEntry).version > entry.version {
+			return
+		}
+		element.Value = entry
+		q.order.MoveToFront(element)
+		return
+	}
+	q.entries[entry.key] = q.order.PushFront(entry)
+	for max := cacheMaxEntries(); q.order.Len() > max; {
+		oldest := q.order.Back()
+		q.order.Remove(oldest)
+		delete(q.entries, oldest.Value.(*cacheEntry).key)
+		q.evictions++
+	}
+}
+
+func (q *queryCache) stats() CacheStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := CacheStats{
+		Entries:    q.order.Len(),
+		MaxEntries: cacheMaxEntries(),
+		Hits:       q.hits,
+		Misses:     q.misses,
+		Stale:      q.stale,
+		Evictions:  q.evictions,
+	}
+	if total := q.hits + q.misses; total > 0 {
+		stats.HitRate = roundTo(float64(q.hits)/float64(total), 4)
+	}
+	return stats
+}
+
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches implements If-None-Match, which may list several tags or "*"
+func etagMatches(header, etag string) bool {
+	for _, candidate := range splitMultiSelect(header) {
+		if candidate == "*" || candidate == etag || candidate == "W/"+etag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveCached answers GET requests from the cache, keyed by path and query
+// string, as long as no write happened since the entry was stored. compute
+// produces the response on a miss. Every answer carries an ETag, and clients
+// sending a matching If-None-Match get 304 Not Modified.
+func serveCached(c *gin.Context, compute func() (interface{}, error)) {
+	version, err := dataVersion()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	key := c.Request.URL.Path + "?" + c.Request.URL.Query().Encode()
+
+	entry, hit := responseCache.get(key, version)
+	if !hit {
+		value, err := compute()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		body, err := json.Marshal(value)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		entry = &cacheEntry{key: key, version: version, body: body, etag: bodyETag(body), storedAt: time.Now()}
+		responseCache.put(entry)
+	}
+
+	c.Header("ETag", entry.etag)
+	// Browsers keep the response but check back every time
+	c.Header("Cache-Control", "private, no-cache")
+	if hit {
+		c.Header("X-Cache", "HIT")
+	} else {
+		c.Header("X-Cache", "MISS")
+	}
+	if etagMatches(c.GetHeader("If-None-Match"), entry.etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", entry.body)
+}
+
+func getCacheStats(c *gin.Context) {
+	stats := responseCache.stats()
+	version, err := dataVersion()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stats.DataVersion = version
+	c.JSON(http.StatusOK, stats)
+}
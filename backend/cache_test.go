@@ -0,0 +1,164 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestETagMatches(t *testing.T) {
+	etag := `"abc"`
+	tests := map[string]bool{
+		"":                false,
+		`"abc"`:           true,
+		`W/"abc"`:         true,
+		`"xyz", "abc"`:    true,
+		"*":               true,
+		`"xyz"`:           false,
+		`"abcd", W/"xyz"`: false,
+	}
+	for header, want := range tests {
+		if got := etagMatches(header, etag); got != want {
+			t.Errorf("etagMatches(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestQueryCacheEviction(t *testing.T) {
+	t.Setenv("CACHE_MAX_ENTRIES", "2")
+	cache := &queryCache{entries: make(map[string]*list.Element), order: list.New()}
+	cache.put(&cacheEntry{key: "a", version: 1})
+	cache.put(&cacheEntry{key: "b", version: 1})
+	// Reading a makes b the least recently used
+	if _, ok := cache.get("a", 1); !ok {
+		t.Fatal("a missing")
+	}
+	cache.put(&cacheEntry{key: "c", version: 1})
+	if _, ok := cache.get("b", 1); ok {
+		t.Error("b survived eviction")
+	}
+	if _, ok := cache.get("a", 2); ok {
+		t.Error("a returned for a newer data version")
+	}
+
+	// A slow miss must not replace what a newer one stored
+	cache.put(&cacheEntry{key: "c", version: 3, body: []byte("new")})
+	cache.put(&cacheEntry{key: "c", version: 2, body: []byte("old")})
+	if entry, ok := cache.get("c", 3); !ok || string(entry.body) != "new" {
+		t.Errorf("c = %+v, want the newer entry", entry)
+	}
+
+	stats := cache.stats()
+	if stats.Entries != 1 || stats.Hits != 2 || stats.Misses != 2 || stats.Stale != 1 || stats.Evictions != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// getCached runs a cached GET handler, optionally revalidating with an ETag
+func getCached(handler gin.HandlerFunc, target, ifNoneMatch string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", target, nil)
+	if ifNoneMatch != "" {
+		c.Request.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	handler(c)
+	// The router does this after the handlers, for responses without a body
+	c.Writer.WriteHeaderNow()
+	return recorder
+}
+
+func TestServeCached(t *testing.T) {
+	openTestDB(t)
+	submitTestSurvey(t, `{"role":"developer"}`)
+
+	first := getCached(getSurveyResults, "/results", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || first.Header().Get("X-Cache") != "MISS" || etag == "" {
+		t.Fatalf("first request: %d, X-Cache %q, ETag %q", first.Code, first.Header().Get("X-Cache"), etag)
+	}
+	second := getCached(getSurveyResults, "/results", "")
+	if second.Header().Get("X-Cache") != "HIT" || second.Header().Get("ETag") != etag || second.Body.String() != first.Body.String() {
+		t.Errorf("second request: X-Cache %q, ETag %q", second.Header().Get("X-Cache"), second.Header().Get("ETag"))
+	}
+	notModified := getCached(getSurveyResults, "/results", etag)
+	if notModified.Code != http.StatusNotModified || notModified.Body.Len() != 0 {
+		t.Errorf("revalidation returned %d with %d bytes, want an empty 304", notModified.Code, notModified.Body.Len())
+	}
+
+	// Query parameters are part of the key, in any order
+	filtered := getCached(getSurveyResults, "/results?role=developer&language=en", "")
+	reordered := getCached(getSurveyResults, "/results?language=en&role=developer", "")
+	if filtered.Header().Get("X-Cache") != "MISS" || reordered.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("filtered requests: %q then %q", filtered.Header().Get("X-Cache"), reordered.Header().Get("X-Cache"))
+	}
+
+	// A new submission invalidates every cached query
+	submitTestSurvey(t, `{"role":"designer"}`)
+	changed := getCached(getSurveyResults, "/results", etag)
+	if changed.Code != http.StatusOK || changed.Header().Get("X-Cache") != "MISS" || changed.Header().Get("ETag") == etag {
+		t.Errorf("after a submission: %d, X-Cache %q", changed.Code, changed.Header().Get("X-Cache"))
+	}
+	var results []SurveyResponse
+	if err := json.Unmarshal(changed.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Errorf("got %d results, want 2", len(results))
+	}
+
+	var stats CacheStats
+	if err := json.Unmarshal(callHandler(getCacheStats, "GET", "/cache/stats", nil).Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Hits != 3 || stats.Misses != 3 || stats.Stale != 1 || stats.DataVersion == 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestCacheInvalidatedByDirectWrites(t *testing.T) {
+	openTestDB(t)
+	response := submitTestSurvey(t, `{"role":"developer","biggestFrustrations":"Slow sync"}`)
+
+	// Filtered metrics are queried live, so they see writes the aggregates miss
+	metrics := getCached(getMetrics, "/metrics?role=developer", "")
+	etag := metrics.Header().Get("ETag")
+
+	// Maintenance commands write without going through the handlers, so the
+	// triggers have to notice
+	for _, statement := range []string{
+		`UPDATE survey_responses SET team_size = '6-20'`,
+		`INSERT INTO survey_response_sentiment (response_id, field, score) VALUES ('` + response.ID + `', 'specificProblems', -0.5)`,
+	} {
+		before, err := dataVersion()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+		after, err := dataVersion()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after <= before {
+			t.Errorf("%s left the data version at %d", statement, after)
+		}
+	}
+
+	recorder := getCached(getMetrics, "/metrics?role=developer", etag)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("metrics after direct writes: %d, X-Cache %q", recorder.Code, recorder.Header().Get("X-Cache"))
+	}
+	var fresh Metrics
+	if err := json.Unmarshal(recorder.Body.Bytes(), &fresh); err != nil {
+		t.Fatal(err)
+	}
+	if fresh.TeamSizeDistribution["6-20"] != 1 {
+		t.Errorf("team sizes = %v, want the updated answer", fresh.TeamSizeDistribution)
+	}
+}
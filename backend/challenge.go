@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	challengePurpose = "survey-challenge"
+	// Window over which per-IP activity raises the difficulty
+	difficultyWindow = 10 * time.Minute
+)
+
+var (
+	errChallengeRequired = errors.New("a solved challenge is required")
+	errChallengeInvalid  = errors.New("challenge is invalid or expired")
+	errChallengeUnsolved = errors.New("challenge solution is incorrect")
+	errChallengeUsed     = errors.New("challenge has already been used")
+	errSubmittedTooFast  = errors.New("survey was submitted too quickly")
+	errSubmissionBlocked = errors.New("submission rejected")
+)
+
+// isChallengeError reports whether err should be shown to the respondent as a 403
+func isChallengeError(err error) bool {
+	return errors.Is(err, errChallengeRequired) || errors.Is(err, errChallengeInvalid) ||
+		errors.Is(err, errChallengeUnsolved) || errors.Is(err, errChallengeUsed) ||
+		errors.Is(err, errSubmittedTooFast) || errors.Is(err, errSubmissionBlocked)
+}
+
+// challengeRequired rejects submissions without a solved challenge. Without
+// it a bot would skip the proof of work and fill time by leaving the challenge
+// out, so only set SURVEY_CHALLENGE_REQUIRED=false for API clients written
+// before challenges existed.
+func challengeRequired() bool {
+	required, err := strconv.ParseBool(getEnvWithFallback("SURVEY_CHALLENGE_REQUIRED", "true"))
+	return err != nil || required
+}
+
+// baseDifficulty is the number of leading zero bits a solution hash needs
+func baseDifficulty() int {
+	difficulty, err := strconv.Atoi(getEnvWithFallback("SURVEY_CHALLENGE_DIFFICULTY", "16"))
+	if err != nil || difficulty < 0 || difficulty > maxDifficulty() {
+		return 16
+	}
+	return difficulty
+}
+
+// maxDifficulty caps the difficulty busy IPs are raised to. Each bit doubles
+// the expected work; 20 bits is about a million hashes, a few seconds on a
+// slow phone, while 26 would keep real respondents waiting for minutes.
+func maxDifficulty() int {
+	difficulty, err := strconv.Atoi(getEnvWithFallback("SURVEY_CHALLENGE_MAX_DIFFICULTY", "20"))
+	if err != nil || difficulty <= 0 || difficulty > 32 {
+		return 20
+	}
+	return difficulty
+}
+
+func challengeTTL() time.Duration {
+	minutes, err := strconv.Atoi(getEnvWithFallback("SURVEY_CHALLENGE_TTL_MINUTES", "120"))
+	if err != nil || minutes <= 0 {
+		minutes = 120
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// minFillTime is the least time allowed between fetching a challenge, which
+// the form does when it loads, and submitting the answers
+func minFillTime() time.Duration {
+	seconds, err := strconv.Atoi(getEnvWithFallback("SURVEY_MIN_FILL_SECONDS", "10"))
+	if err != nil || seconds < 0 {
+		seconds = 10
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func challengeKey() []byte {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte(challengePurpose))
+	return mac.Sum(nil)
+}
+
+func createChallengeTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS survey_challenge_nonces (
+		nonce TEXT PRIMARY KEY,
+		used_at TIMESTAMP NOT NULL
+	)`)
+	return err
+}
+
+// activityTracker counts recent challenges and submissions per IP
+type activityTracker struct {
+	mu        sync.Mutex
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+var surveyActivity = &activityTracker{events: make(map[string][]time.Time)}
+
+// record notes one event for ip and returns how many it had within the window
+func (t *activityTracker) record(ip string, now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := now.Add(-difficultyWindow)
+	if now.Sub(t.lastSweep) > difficultyWindow {
+		for key, times := range t.events {
+			if len(times) == 0 || times[len(times)-1].Before(cutoff) {
+				delete(t.events, key)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	times := t.events[ip]
+	kept := times[:0]
+	for _, at := range times {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	kept = append(kept, now)
+	t.events[ip] = kept
+	return len(kept)
+}
+
+// difficultyFor raises the base difficulty as an IP makes more requests; each
+// extra two bits makes solving four times slower
+func difficultyFor(recent int) int {
+	difficulty := baseDifficulty()
+	switch {
+	case recent > 50:
+		difficulty += 6
+	case recent > 20:
+		difficulty += 4
+	case recent > 5:
+		difficulty += 2
+	}
+	if limit := maxDifficulty(); difficulty > limit {
+		difficulty = limit
+	}
+	return difficulty
+}
+
+// leadingZeroBits counts the zero bits at the start of a hash
+func leadingZeroBits(hash []byte) int {
+	count := 0
+	for _, b := range hash {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// checkSolution verifies that SHA-256("<nonce>:<solution>") starts with
+// difficulty zero bits
+func checkSolution(nonce, solution string, difficulty int) bool {
+	hash := sha256.Sum256([]byte(nonce + ":" + solution))
+	return leadingZeroBits(hash[:]) >= difficulty
+}
+
+// getChallenge issues a signed proof-of-work challenge. The client finds any
+// solution string for which SHA-256("<nonce>:<solution>") has at least
+// difficulty leading zero bits and submits it with the challenge token.
+func getChallenge(c *gin.Context) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	nonce := hex.EncodeToString(raw)
+	now := time.Now()
+	difficulty := difficultyFor(surveyActivity.record(c.ClientIP(), now))
+	expiresAt := now.Add(challengeTTL())
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti":        nonce,
+		"purpose":    challengePurpose,
+		"difficulty": difficulty,
+		"iat":        now.Unix(),
+		"exp":        expiresAt.Unix(),
+	})
+	signed, err := token.SignedString(challengeKey())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"challenge":  signed,
+		"nonce":      nonce,
+		"difficulty": difficulty,
+		"issuedAt":   now.UTC(),
+		"expiresAt":  expiresAt.UTC(),
+	})
+}
+
+// verifyChallenge checks the bot defences of a submission before anything is
+// stored and returns the challenge nonce, which consumeChallenge then marks
+// as used. The nonce is empty when no challenge was sent and none is required.
+func verifyChallenge(c *gin.Context, submission *surveySubmission, now time.Time) (string, error) {
+	// Real respondents never see the honeypot field, so only bots fill it in
+	if submission.Website != "" {
+		return "", errSubmissionBlocked
+	}
+	surveyActivity.record(c.ClientIP(), now)
+
+	if submission.Challenge == "" {
+		if challengeRequired() {
+			return "", errChallengeRequired
+		}
+		return "", nil
+	}
+
+	token, err := jwt.Parse(submission.Challenge, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return challengeKey(), nil
+	})
+	if err != nil {
+		return "", errChallengeInvalid
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != challengePurpose {
+		return "", errChallengeInvalid
+	}
+	nonce, _ := claims["jti"].(string)
+	difficulty, _ := claims["difficulty"].(float64)
+	issuedAt, err := claims.GetIssuedAt()
+	if nonce == "" || err != nil || issuedAt == nil {
+		return "", errChallengeInvalid
+	}
+
+	if !checkSolution(nonce, submission.Solution, int(difficulty)) {
+		return "", errChallengeUnsolved
+	}
+	if now.Sub(issuedAt.Time) < minFillTime() {
+		return "", errSubmittedTooFast
+	}
+	return nonce, nil
+}
+
+// consumeChallenge marks a nonce as used so each solved challenge admits one
+// submission, forgetting nonces old enough that their tokens have expired
+func consumeChallenge(tx *sql.Tx, nonce string, now time.Time) error {
+	if nonce == "" {
+		return nil
+	}
+	if _, err := tx.Exec(`DELETE FROM survey_challenge_nonces WHERE julianday(used_at) < julianday(?)`,
+		now.Add(-challengeTTL())); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`INSERT OR IGNORE INTO survey_challenge_nonces (nonce, used_at) VALUES (?, ?)`, nonce, now)
+	if err != nil {
+		return err
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		return errChallengeUsed
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func testContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/survey", nil)
+	return c
+}
+
+// issueTestChallenge fetches a challenge the way the survey form does
+func issueTestChallenge(t *testing.T) (token, nonce string, difficulty int) {
+	t.Helper()
+	recorder := callHandler(getChallenge, "GET", "/survey/challenge", nil)
+	var body struct {
+		Challenge  string `json:"challenge"`
+		Nonce      string `json:"nonce"`
+		Difficulty int    `json:"difficulty"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Challenge, body.Nonce, body.Difficulty
+}
+
+func solveTestChallenge(nonce string, difficulty int) string {
+	for i := 0; ; i++ {
+		if solution := strconv.Itoa(i); checkSolution(nonce, solution, difficulty) {
+			return solution
+		}
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		hash []byte
+		want int
+	}{
+		{[]byte{0xff}, 0},
+		{[]byte{0x01, 0xff}, 7},
+		{[]byte{0x00, 0x20}, 10},
+		{[]byte{0x00, 0x00}, 16},
+	}
+	for _, tt := range tests {
+		if got := leadingZeroBits(tt.hash); got != tt.want {
+			t.Errorf("leadingZeroBits(%x) = %d, want %d", tt.hash, got, tt.want)
+		}
+	}
+}
+
+func TestDifficultyFor(t *testing.T) {
+	t.Setenv("SURVEY_CHALLENGE_DIFFICULTY", "12")
+	t.Setenv("SURVEY_CHALLENGE_MAX_DIFFICULTY", "")
+	tests := map[int]int{1: 12, 6: 14, 21: 16, 51: 18}
+	for recent, want := range tests {
+		if got := difficultyFor(recent); got != want {
+			t.Errorf("difficultyFor(%d) = %d, want %d", recent, got, want)
+		}
+	}
+
+	// Busy IPs never push the difficulty past the cap, 20 by default
+	t.Setenv("SURVEY_CHALLENGE_DIFFICULTY", "18")
+	if got := difficultyFor(100); got != 20 {
+		t.Errorf("difficultyFor(100) = %d, want the default cap 20", got)
+	}
+	t.Setenv("SURVEY_CHALLENGE_MAX_DIFFICULTY", "22")
+	if got := difficultyFor(100); got != 22 {
+		t.Errorf("difficultyFor(100) = %d, want the configured cap 22", got)
+	}
+	t.Setenv("SURVEY_CHALLENGE_DIFFICULTY", "23")
+	if got := baseDifficulty(); got != 16 {
+		t.Errorf("baseDifficulty() over the cap = %d, want the default 16", got)
+	}
+	t.Setenv("SURVEY_CHALLENGE_MAX_DIFFICULTY", "64")
+	if got := maxDifficulty(); got != 20 {
+		t.Errorf("maxDifficulty() = %d for an out of range setting, want 20", got)
+	}
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("SURVEY_CHALLENGE_DIFFICULTY", "4")
+	t.Setenv("SURVEY_MIN_FILL_SECONDS", "10")
+	// Challenges are required unless turned off
+	t.Setenv("SURVEY_CHALLENGE_REQUIRED", "")
+
+	token, nonce, difficulty := issueTestChallenge(t)
+	solution := solveTestChallenge(nonce, difficulty)
+	// Any string solves a low difficulty by chance now and then, so pick one
+	// known to miss
+	wrong := solution + "x"
+	for checkSolution(nonce, wrong, difficulty) {
+		wrong += "x"
+	}
+	later := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name       string
+		submission surveySubmission
+		now        time.Time
+		want       error
+	}{
+		{"solved", surveySubmission{Challenge: token, Solution: solution}, later, nil},
+		{"omitted", surveySubmission{}, later, errChallengeRequired},
+		{"unsolved", surveySubmission{Challenge: token, Solution: wrong}, later, errChallengeUnsolved},
+		{"too fast", surveySubmission{Challenge: token, Solution: solution}, time.Now(), errSubmittedTooFast},
+		{"tampered", surveySubmission{Challenge: token + "x", Solution: solution}, later, errChallengeInvalid},
+		{"honeypot", surveySubmission{Challenge: token, Solution: solution, Website: "http://spam.example"}, later, errSubmissionBlocked},
+	}
+	for _, tt := range tests {
+		got, err := verifyChallenge(testContext(), &tt.submission, tt.now)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+		if tt.want == nil && got != nonce {
+			t.Errorf("%s: nonce = %q, want %q", tt.name, got, nonce)
+		}
+	}
+}
+
+func TestChallengeOptOut(t *testing.T) {
+	t.Setenv("SURVEY_CHALLENGE_REQUIRED", "false")
+	nonce, err := verifyChallenge(testContext(), &surveySubmission{}, time.Now())
+	if err != nil || nonce != "" {
+		t.Errorf("omitted challenge with opt-out: %q, %v", nonce, err)
+	}
+}
+
+func TestConsumeChallengeOnce(t *testing.T) {
+	openTestDB(t)
+	now := time.Now()
+	for i, want := range []error{nil, errChallengeUsed} {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := consumeChallenge(tx, "nonce-1", now); !errors.Is(err, want) {
+			t.Errorf("use %d: error = %v, want %v", i+1, err, want)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSubmitWithChallenge(t *testing.T) {
+	openTestDB(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("SURVEY_CHALLENGE_DIFFICULTY", "4")
+	t.Setenv("SURVEY_MIN_FILL_SECONDS", "0")
+	t.Setenv("SURVEY_CHALLENGE_REQUIRED", "true")
+
+	token, nonce, difficulty := issueTestChallenge(t)
+	body := fmt.Sprintf(`{"role":"developer","challenge":%q,"solution":%q}`, token, solveTestChallenge(nonce, difficulty))
+	submitTestSurvey(t, body)
+
+	// A solved challenge admits a single submission
+	if recorder := callHandler(submitSurvey, "POST", "/survey", strings.NewReader(body)); recorder.Code != http.StatusForbidden {
+		t.Errorf("replayed challenge returned %d, want 403", recorder.Code)
+	}
+	if recorder := callHandler(submitSurvey, "POST", "/survey", strings.NewReader(`{"role":"developer"}`)); recorder.Code != http.StatusForbidden {
+		t.Errorf("submission without a challenge returned %d, want 403", recorder.Code)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM survey_responses`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("stored %d responses, want 1", count)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// sparseVector maps term indexes to weights
+type sparseVector map[int]float64
+
+// textDocument is one free-text answer prepared for clustering
+type textDocument struct {
+	responseID string
+	text       string
+	stems      []string
+	vector     sparseVector
+}
+
+// termVocabulary remembers each stem's index and the spelling respondents
+// used most for it, so cluster labels read as words rather than stems
+type termVocabulary struct {
+	stems     []string
+	index     map[string]int
+	spellings []map[string]int
+}
+
+// prepareDocuments tokenizes answers into stems, dropping stop words
+func prepareDocuments(docs []textDocument) ([]textDocument, *termVocabulary) {
+	vocab := &termVocabulary{index: make(map[string]int)}
+	for i := range docs {
+		for _, phrase := range tokenizeText(docs[i].text) {
+			for _, word := range phrase {
+				if stopWords[word] {
+					continue
+				}
+				stemmed := stem(word)
+				idx, ok := vocab.index[stemmed]
+				if !ok {
+					idx = len(vocab.stems)
+					vocab.index[stemmed] = idx
+					vocab.stems = append(vocab.stems, stemmed)
+					vocab.spellings = append(vocab.spellings, make(map[string]int))
+				}
+				vocab.spellings[idx][word]++
+				docs[i].stems = append(docs[i].stems, stemmed)
+			}
+		}
+	}
+	return docs, vocab
+}
+
+// vectorize computes L2-normalised TF-IDF vectors. Terms used in only one
+// answer cannot group answers together and are left out, as are answers
+// left with no terms at all.
+func vectorize(docs []textDocument, vocab *termVocabulary) []textDocument {
+	df := make([]int, len(vocab.stems))
+	for _, doc := range docs {
+		seen := make(map[int]bool)
+		for _, s := range doc.stems {
+			idx := vocab.index[s]
+			if !seen[idx] {
+				seen[idx] = true
+				df[idx]++
+			}
+		}
+	}
+
+	n := float64(len(docs))
+	var kept []textDocument
+	for _, doc := range docs {
+		vector := make(sparseVector)
+		for _, s := range doc.stems {
+			if idx := vocab.index[s]; df[idx] > 1 {
+				vector[idx]++
+			}
+		}
+		var norm float64
+		for idx, tf := range vector {
+			weight := (1 + math.Log(tf)) * math.Log(n/float64(df[idx]))
+			vector[idx] = weight
+			norm += weight * weight
+		}
+		if norm == 0 {
+			continue
+		}
+		norm = math.Sqrt(norm)
+		for idx := range vector {
+			vector[idx] /= norm
+		}
+		doc.vector = vector
+		kept = append(kept, doc)
+	}
+	return kept
+}
+
+func cosineSimilarity(v sparseVector, centroid []float64) float64 {
+	var dot float64
+	for idx, weight := range v {
+		dot += weight * centroid[idx]
+	}
+	return dot
+}
+
+// defaultClusterCount picks k from the number of answers, the common sqrt(n/2) rule
+func defaultClusterCount(n int) int {
+	k := int(math.Round(math.Sqrt(float64(n) / 2)))
+	if k < 2 {
+		k = 2
+	}
+	if k > 12 {
+		k = 12
+	}
+	return k
+}
+
+// Independent k-means runs; the tightest clustering wins
+const kMeansRestarts = 8
+
+// kMeans runs spherical k-means (cosine similarity) with k-means++ seeding,
+// restarting a few times to avoid poor local optima. The seed is fixed so the
+// same answers always produce the same themes. It returns each document's
+// cluster, its similarity to the centroid, and the centroids.
+func kMeans(docs []textDocument, dims, k, maxIterations int) ([]int, []float64, [][]float64) {
+	if k > len(docs) {
+		k = len(docs)
+	}
+	rng := rand.New(rand.NewSource(1))
+
+	var bestAssignments []int
+	var bestSimilarities []float64
+	var bestCentroids [][]float64
+	bestScore := math.Inf(-1)
+	for run := 0; run < kMeansRestarts; run++ {
+		assignments, similarities, centroids := kMeansRun(docs, dims, k, maxIterations, rng)
+		var score float64
+		for _, sim := range similarities {
+			score += sim
+		}
+		if score > bestScore {
+			bestAssignments, bestSimilarities, bestCentroids, bestScore = assignments, similarities, centroids, score
+		}
+	}
+	return bestAssignments, bestSimilarities, bestCentroids
+}
+
+func kMeansRun(docs []textDocument, dims, k, maxIterations int, rng *rand.Rand) ([]int, []float64, [][]float64) {
+	centroids := make([][]float64, 0, k)
+
+	addCentroid := func(doc textDocument) {
+		centroid := make([]float64, dims)
+		for idx, weight := range doc.vector {
+			centroid[idx] = weight
+		}
+		centroids = append(centroids, centroid)
+	}
+	addCentroid(docs[rng.Intn(len(docs))])
+	for len(centroids) < k {
+		distances := make([]float64, len(docs))
+		var total float64
+		for i, doc := range docs {
+			best := math.Inf(1)
+			for _, centroid := range centroids {
+				best = math.Min(best, 1-cosineSimilarity(doc.vector, centroid))
+			}
+			distances[i] = best * best
+			total += distances[i]
+		}
+		if total == 0 {
+			break
+		}
+		target := rng.Float64() * total
+		i := 0
+		for ; i < len(docs)-1; i++ {
+			target -= distances[i]
+			if target <= 0 {
+				break
+			}
+		}
+		addCentroid(docs[i])
+	}
+
+	assignments := make([]int, len(docs))
+	similarities := make([]float64, len(docs))
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		changed := false
+		for i, doc := range docs {
+			best, bestSim := 0, math.Inf(-1)
+			for c, centroid := range centroids {
+				if sim := cosineSimilarity(doc.vector, centroid); sim > bestSim {
+					best, bestSim = c, sim
+				}
+			}
+			if iteration == 0 || assignments[i] != best {
+				changed = true
+			}
+			assignments[i] = best
+			similarities[i] = bestSim
+		}
+		if !changed {
+			break
+		}
+
+		for c := range centroids {
+			sum := make([]float64, dims)
+			members := 0
+			for i, doc := range docs {
+				if assignments[i] != c {
+					continue
+				}
+				members++
+				for idx, weight := range doc.vector {
+					sum[idx] += weight
+				}
+			}
+			// An empty cluster keeps its old centroid
+			if members == 0 {
+				continue
+			}
+			var norm float64
+			for _, v := range sum {
+				norm += v * v
+			}
+			norm = math.Sqrt(norm)
+			for idx := range sum {
+				sum[idx] /= norm
+			}
+			centroids[c] = sum
+		}
+	}
+	return assignments, similarities, centroids
+}
+
+// topCentroidTerms returns the most heavily weighted terms of a centroid
+func topCentroidTerms(centroid []float64, vocab *termVocabulary, n int) []string {
+	indexes := make([]int, 0, len(centroid))
+	for idx, weight := range centroid {
+		if weight > 0 {
+			indexes = append(indexes, idx)
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		if centroid[indexes[i]] != centroid[indexes[j]] {
+			return centroid[indexes[i]] > centroid[indexes[j]]
+		}
+		return vocab.stems[indexes[i]] < vocab.stems[indexes[j]]
+	})
+	if len(indexes) > n {
+		indexes = indexes[:n]
+	}
+	terms := make([]string, len(indexes))
+	for i, idx := range indexes {
+		terms[i] = mostFrequent(vocab.spellings[idx])
+	}
+	return terms
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var clusteringAnswers = [][]string{
+	{
+		"Offline editing breaks when sync fails",
+		"Sync conflicts after offline editing",
+		"Offline mode loses edits during sync",
+		"Editing offline then sync overwrites changes",
+	},
+	{
+		"PDF export mangles tables",
+		"Exporting PDF files loses tables",
+		"PDF tables break on export",
+		"Tables in exported PDF look wrong",
+	},
+	{
+		"Pricing per seat is too expensive",
+		"Seat pricing gets expensive for teams",
+		"Too expensive pricing for small teams",
+		"Per seat pricing expensive",
+	},
+}
+
+func clusterTestAnswers() ([]textDocument, *termVocabulary, []int, [][]float64) {
+	var docs []textDocument
+	for group, answers := range clusteringAnswers {
+		for i, text := range answers {
+			docs = append(docs, textDocument{responseID: string(rune('a'+group)) + string(rune('0'+i)), text: text})
+		}
+	}
+	docs, vocab := prepareDocuments(docs)
+	docs = vectorize(docs, vocab)
+	assignments, _, centroids := kMeans(docs, len(vocab.stems), 3, 50)
+	return docs, vocab, assignments, centroids
+}
+
+func TestKMeansSeparatesThemes(t *testing.T) {
+	docs, vocab, assignments, centroids := clusterTestAnswers()
+	if len(docs) != 12 {
+		t.Fatalf("vectorize kept %d of 12 answers", len(docs))
+	}
+
+	// Every answer lands with the others from its group and no one else
+	clusterOf := make(map[byte]int)
+	used := make(map[int]byte)
+	for i, doc := range docs {
+		group := doc.responseID[0]
+		cluster, seen := clusterOf[group]
+		if !seen {
+			if other, taken := used[assignments[i]]; taken {
+				t.Fatalf("groups %c and %c share cluster %d", other, group, assignments[i])
+			}
+			clusterOf[group], used[assignments[i]] = assignments[i], group
+			continue
+		}
+		if assignments[i] != cluster {
+			t.Errorf("answer %q is in cluster %d, want %d with its group", doc.text, assignments[i], cluster)
+		}
+	}
+
+	labels := map[byte]string{'a': "offline", 'b': "pdf", 'c': "pricing"}
+	for group, label := range labels {
+		terms := topCentroidTerms(centroids[clusterOf[group]], vocab, 3)
+		found := false
+		for _, term := range terms {
+			found = found || term == label
+		}
+		if !found {
+			t.Errorf("top terms for group %c = %v, want them to include %q", group, terms, label)
+		}
+	}
+}
+
+func TestKMeansIsDeterministic(t *testing.T) {
+	_, _, first, _ := clusterTestAnswers()
+	_, _, second, _ := clusterTestAnswers()
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("assignments differ between runs: %v and %v", first, second)
+	}
+}
+
+func TestDefaultClusterCount(t *testing.T) {
+	tests := []struct{ n, want int }{
+		{1, 2},
+		{8, 2},
+		{50, 5},
+		{200, 10},
+		{10000, 12},
+	}
+	for _, tt := range tests {
+		if got := defaultClusterCount(tt.n); got != tt.want {
+			t.Errorf("defaultClusterCount(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,757 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// codePathSeparator joins a code's ancestors into its display path
+const codePathSeparator = " > "
+
+// Code is an entry in the qualitative codebook. Codes nest to any depth.
+type Code struct {
+	ID          int64     `json:"id"`
+	ParentID    *int64    `json:"parentId"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Path        string    `json:"path"`
+	Children    []*Code   `json:"children"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type CodeRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ParentID    *int64 `json:"parentId"`
+}
+
+// CodeApplication tags one free-text answer with a code. SpanStart and
+// SpanEnd optionally mark the highlighted passage as character offsets.
+type CodeApplication struct {
+	ID         int64     `json:"id"`
+	ResponseID string    `json:"responseId"`
+	Field      string    `json:"field"`
+	CodeID     int64     `json:"codeId"`
+	Code       string    `json:"code"`
+	Coder      string    `json:"coder"`
+	SpanStart  *int      `json:"spanStart"`
+	SpanEnd    *int      `json:"spanEnd"`
+	Excerpt    string    `json:"excerpt,omitempty"`
+	Note       string    `json:"note"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type CodeApplicationRequest struct {
+	Field     string `json:"field"`
+	CodeID    int64  `json:"codeId"`
+	SpanStart *int   `json:"spanStart"`
+	SpanEnd   *int   `json:"spanEnd"`
+	Note      string `json:"note"`
+	// Coder names who is coding, since coders share the admin login; it
+	// defaults to the signed-in user
+	Coder string `json:"coder"`
+}
+
+// coderPattern limits coder names to short, readable identifiers
+var coderPattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} ._@-]{0,63}$`)
+
+// codingCoder returns the coder an application is recorded for
+func codingCoder(c *gin.Context, requested string) (string, error) {
+	coder := strings.TrimSpace(requested)
+	if coder == "" {
+		return changedBy(c), nil
+	}
+	if !coderPattern.MatchString(coder) {
+		return "", fmt.Errorf("coder must be 1-64 letters, digits, spaces or . _ @ -, starting with a letter or digit")
+	}
+	return coder, nil
+}
+
+// CodeFrequency counts how often a code was applied. TotalResponses also
+// includes responses tagged with any of the code's descendants.
+type CodeFrequency struct {
+	CodeID         int64  `json:"codeId"`
+	Code           string `json:"code"`
+	Applications   int    `json:"applications"`
+	Responses      int    `json:"responses"`
+	TotalResponses int    `json:"totalResponses"`
+	Coders         int    `json:"coders"`
+}
+
+func createCodingTables() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS survey_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		parent_id INTEGER,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS survey_code_applications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		response_id TEXT NOT NULL,
+		field TEXT NOT NULL,
+		code_id INTEGER NOT NULL,
+		coder TEXT NOT NULL,
+		span_start INTEGER,
+		span_end INTEGER,
+		note TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_survey_code_applications_unique
+		ON survey_code_applications (response_id, field, code_id, coder, COALESCE(span_start, -1), COALESCE(span_end, -1))`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_survey_code_applications_code
+		ON survey_code_applications (code_id)`)
+	return err
+}
+
+// loadCodebook returns every code by id along with the top-level codes, with
+// children and paths filled in
+func loadCodebook() (map[int64]*Code, []*Code, error) {
+	rows, err := db.Query(`SELECT id, parent_id, name, description, created_at FROM survey_codes ORDER BY name, id`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	codes := make(map[int64]*Code)
+	var ordered []*Code
+	for rows.Next() {
+		code := &Code{Children: []*Code{}}
+		var parentID sql.NullInt64
+		if err := rows.Scan(&code.ID, &parentID, &code.Name, &code.Description, &code.CreatedAt); err != nil {
+			return nil, nil, err
+		}
+		if parentID.Valid {
+			code.ParentID = &parentID.Int64
+		}
+		codes[code.ID] = code
+		ordered = append(ordered, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	roots := []*Code{}
+	for _, code := range ordered {
+		if code.ParentID != nil && codes[*code.ParentID] != nil {
+			parent := codes[*code.ParentID]
+			parent.Children = append(parent.Children, code)
+		} else {
+			roots = append(roots, code)
+		}
+	}
+	var setPaths func(codes []*Code, prefix string)
+	setPaths = func(codes []*Code, prefix string) {
+		for _, code := range codes {
+			code.Path = prefix + code.Name
+			setPaths(code.Children, code.Path+codePathSeparator)
+		}
+	}
+	setPaths(roots, "")
+	return codes, roots, nil
+}
+
+func derefID(id *int64) int64 {
+	if id == nil {
+		return 0
+	}
+	return *id
+}
+
+// isDescendant reports whether code is candidate or lies below it
+func isDescendant(codes map[int64]*Code, code, candidate int64) bool {
+	for id := code; ; {
+		if id == candidate {
+			return true
+		}
+		current, ok := codes[id]
+		if !ok || current.ParentID == nil {
+			return false
+		}
+		id = *current.ParentID
+	}
+}
+
+func codeIDParam(c *gin.Context, name string) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// validateCodeRequest checks a new or edited code against the codebook.
+// id is 0 for new codes.
+func validateCodeRequest(codes map[int64]*Code, id int64, req *CodeRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if strings.Contains(req.Name, strings.TrimSpace(codePathSeparator)) {
+		return fmt.Errorf("name cannot contain %q", strings.TrimSpace(codePathSeparator))
+	}
+	if req.ParentID != nil {
+		if _, ok := codes[*req.ParentID]; !ok {
+			return fmt.Errorf("parent code not found")
+		}
+		if id != 0 && isDescendant(codes, *req.ParentID, id) {
+			return fmt.Errorf("a code cannot be moved below itself")
+		}
+	}
+	for _, code := range codes {
+		if code.ID != id && code.Name == req.Name && derefID(code.ParentID) == derefID(req.ParentID) {
+			return fmt.Errorf("code %q already exists here", req.Name)
+		}
+	}
+	return nil
+}
+
+func getCodebook(c *gin.Context) {
+	_, roots, err := loadCodebook()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, roots)
+}
+
+func createCode(c *gin.Context) {
+	var req CodeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	codes, _, err := loadCodebook()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateCodeRequest(codes, 0, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := db.Exec(`INSERT INTO survey_codes (parent_id, name, description, created_at) VALUES (?, ?, ?, ?)`,
+		req.ParentID, req.Name, req.Description, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	id, _ := result.LastInsertId()
+	respondWithCode(c, http.StatusCreated, id)
+}
+
+func updateCode(c *gin.Context) {
+	id, ok := codeIDParam(c, "id")
+	if !ok {
+		return
+	}
+	var req CodeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	codes, _, err := loadCodebook()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := codes[id]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "code not found"})
+		return
+	}
+	if err := validateCodeRequest(codes, id, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = db.Exec(`UPDATE survey_codes SET parent_id = ?, name = ?, description = ? WHERE id = ?`,
+		req.ParentID, req.Name, req.Description, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	respondWithCode(c, http.StatusOK, id)
+}
+
+func respondWithCode(c *gin.Context, status int, id int64) {
+	codes, _, err := loadCodebook()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(status, codes[id])
+}
+
+// deleteCode removes an unused code. Codes with children or applications
+// have to be emptied first so no coding work is lost by accident.
+func deleteCode(c *gin.Context) {
+	id, ok := codeIDParam(c, "id")
+	if !ok {
+		return
+	}
+	var children, applications int
+	err := db.QueryRow(`SELECT
+			(SELECT COUNT(*) FROM survey_codes WHERE parent_id = ?),
+			(SELECT COUNT(*) FROM survey_code_applications WHERE code_id = ?)`, id, id).
+		Scan(&children, &applications)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if children > 0 || applications > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("code has %d child codes and %d applications", children, applications)})
+		return
+	}
+
+	result, err := db.Exec(`DELETE FROM survey_codes WHERE id = ?`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "code not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Code deleted"})
+}
+
+// spanExcerpt returns the highlighted passage of an answer
+func spanExcerpt(text string, start, end *int) string {
+	if start == nil || end == nil {
+		return ""
+	}
+	runes := []rune(text)
+	if *start < 0 || *end > len(runes) || *start >= *end {
+		return ""
+	}
+	return string(runes[*start:*end])
+}
+
+func getResponseCodes(c *gin.Context) {
+	response, err := getSurveyResponse(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "response not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	codes, _, err := loadCodebook()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := db.Query(`SELECT id, response_id, field, code_id, coder, span_start, span_end, note, created_at
+		FROM survey_code_applications WHERE response_id = ? ORDER BY field, span_start, id`, response.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	applications := []CodeApplication{}
+	for rows.Next() {
+		var a CodeApplication
+		var start, end sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.ResponseID, &a.Field, &a.CodeID, &a.Coder, &start, &end,
+			&a.Note, &a.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if start.Valid && end.Valid {
+			s, e := int(start.Int64), int(end.Int64)
+			a.SpanStart, a.SpanEnd = &s, &e
+		}
+		if code, ok := codes[a.CodeID]; ok {
+			a.Code = code.Path
+		}
+		if field, ok := lookupFreeTextField(a.Field); ok {
+			a.Excerpt = spanExcerpt(*field.answer(&response), a.SpanStart, a.SpanEnd)
+		}
+		applications = append(applications, a)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, applications)
+}
+
+// applyCode tags a free-text answer with a code on behalf of the coder named
+// in the request. Spans are character offsets into the answer, end exclusive.
+func applyCode(c *gin.Context) {
+	var req CodeApplicationRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	coder, err := codingCoder(c, req.Coder)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	field, ok := lookupFreeTextField(req.Field)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown free-text field %q", req.Field)})
+		return
+	}
+	response, err := getSurveyResponse(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "response not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	codes, _, err := loadCodebook()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	code, ok := codes[req.CodeID]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code not found"})
+		return
+	}
+
+	text := *field.answer(&response)
+	if strings.TrimSpace(text) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the response has no answer for this field"})
+		return
+	}
+	if (req.SpanStart == nil) != (req.SpanEnd == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "spanStart and spanEnd must be given together"})
+		return
+	}
+	if req.SpanStart != nil && spanExcerpt(text, req.SpanStart, req.SpanEnd) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("span must lie within the %d characters of the answer", len([]rune(text)))})
+		return
+	}
+
+	application := CodeApplication{
+		ResponseID: response.ID,
+		Field:      field.key,
+		CodeID:     code.ID,
+		Code:       code.Path,
+		Coder:      coder,
+		SpanStart:  req.SpanStart,
+		SpanEnd:    req.SpanEnd,
+		Excerpt:    spanExcerpt(text, req.SpanStart, req.SpanEnd),
+		Note:       req.Note,
+		CreatedAt:  time.Now(),
+	}
+	result, err := db.Exec(`INSERT OR IGNORE INTO survey_code_applications
+			(response_id, field, code_id, coder, span_start, span_end, note, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		application.ResponseID, application.Field, application.CodeID, application.Coder,
+		application.SpanStart, application.SpanEnd, application.Note, application.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "this coder already applied this code here"})
+		return
+	}
+	application.ID, _ = result.LastInsertId()
+	c.JSON(http.StatusCreated, application)
+}
+
+func removeCodeApplication(c *gin.Context) {
+	id, ok := codeIDParam(c, "applicationId")
+	if !ok {
+		return
+	}
+	result, err := db.Exec(`DELETE FROM survey_code_applications WHERE id = ? AND response_id = ?`, id, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "code application not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Code removed"})
+}
+
+// codedUnit identifies one answer: a field of a response
+type codedUnit struct {
+	responseID string
+	field      string
+}
+
+type codeApplicationRow struct {
+	unit   codedUnit
+	codeID int64
+	coder  string
+}
+
+// loadCodeApplications returns the applications on responses matching the
+// filter, optionally limited to one field. Applications on deleted responses
+// are kept for when a response is restored, but are not reported.
+func loadCodeApplications(filter responseFilter, field string) ([]codeApplicationRow, error) {
+	query := `SELECT response_id, field, code_id, coder FROM survey_code_applications
+		WHERE response_id IN (SELECT id FROM survey_responses` + filter.where() + `)`
+	args := append([]interface{}(nil), filter.args...)
+	if field != "" {
+		query += " AND field = ?"
+		args = append(args, field)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applications []codeApplicationRow
+	for rows.Next() {
+		var a codeApplicationRow
+		if err := rows.Scan(&a.unit.responseID, &a.unit.field, &a.codeID, &a.coder); err != nil {
+			return nil, err
+		}
+		applications = append(applications, a)
+	}
+	return applications, rows.Err()
+}
+
+// codeFrequencies counts code usage among the filtered responses, ordered by
+// codebook path
+func codeFrequencies(filter responseFilter, field, coder string) ([]CodeFrequency, error) {
+	codes, _, err := loadCodebook()
+	if err != nil {
+		return nil, err
+	}
+	applications, err := loadCodeApplications(filter, field)
+	if err != nil {
+		return nil, err
+	}
+
+	type tally struct {
+		applications int
+		direct       map[string]bool
+		total        map[string]bool
+		coders       map[string]bool
+	}
+	tallies := make(map[int64]*tally)
+	for id := range codes {
+		tallies[id] = &tally{direct: map[string]bool{}, total: map[string]bool{}, coders: map[string]bool{}}
+	}
+	for _, a := range applications {
+		if coder != "" && a.coder != coder {
+			continue
+		}
+		t, ok := tallies[a.codeID]
+		if !ok {
+			continue
+		}
+		t.applications++
+		t.direct[a.unit.responseID] = true
+		t.coders[a.coder] = true
+		// Roll the response up to every ancestor
+		for id := a.codeID; ; {
+			tallies[id].total[a.unit.responseID] = true
+			parent := codes[id].ParentID
+			if parent == nil || codes[*parent] == nil {
+				break
+			}
+			id = *parent
+		}
+	}
+
+	frequencies := make([]CodeFrequency, 0, len(codes))
+	for id, t := range tallies {
+		frequencies = append(frequencies, CodeFrequency{
+			CodeID:         id,
+			Code:           codes[id].Path,
+			Applications:   t.applications,
+			Responses:      len(t.direct),
+			TotalResponses: len(t.total),
+			Coders:         len(t.coders),
+		})
+	}
+	sort.Slice(frequencies, func(i, j int) bool { return frequencies[i].Code < frequencies[j].Code })
+	return frequencies, nil
+}
+
+// getCodeReport returns code frequencies, optionally for one ?field= or
+// ?coder=, with the usual segment filters
+func getCodeReport(c *gin.Context) {
+	field := c.Query("field")
+	if field != "" {
+		if _, ok := lookupFreeTextField(field); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown free-text field %q", field)})
+			return
+		}
+	}
+	filter, err := parseResponseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	frequencies, err := codeFrequencies(filter, field, c.Query("coder"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, frequencies)
+}
+
+type CodeAgreement struct {
+	CodeID    int64    `json:"codeId"`
+	Code      string   `json:"code"`
+	Agreement float64  `json:"agreement"`
+	Kappa     *float64 `json:"kappa"`
+}
+
+// CoderAgreement compares two coders over the answers both of them coded
+type CoderAgreement struct {
+	Coders    [2]string       `json:"coders"`
+	Units     int             `json:"units"`
+	MeanKappa *float64        `json:"meanKappa"`
+	Codes     []CodeAgreement `json:"codes"`
+}
+
+// compareCoders computes per-code agreement and Cohen's kappa between two
+// coders. Only answers both coders worked on count, so a code one coder
+// left out is a disagreement rather than unfinished work.
+func compareCoders(codes map[int64]*Code, applied map[string]map[codedUnit]map[int64]bool, first, second string) CoderAgreement {
+	result := CoderAgreement{Coders: [2]string{first, second}, Codes: []CodeAgreement{}}
+
+	var units []codedUnit
+	usedCodes := make(map[int64]bool)
+	for unit, firstCodes := range applied[first] {
+		secondCodes, ok := applied[second][unit]
+		if !ok {
+			continue
+		}
+		units = append(units, unit)
+		for id := range firstCodes {
+			usedCodes[id] = true
+		}
+		for id := range secondCodes {
+			usedCodes[id] = true
+		}
+	}
+	result.Units = len(units)
+
+	var kappaSum float64
+	var kappaCount int
+	for id := range usedCodes {
+		var both, onlyFirst, onlySecond, neither int
+		for _, unit := range units {
+			a, b := applied[first][unit][id], applied[second][unit][id]
+			switch {
+			case a && b:
+				both++
+			case a:
+				onlyFirst++
+			case b:
+				onlySecond++
+			default:
+				neither++
+			}
+		}
+		agreement := CodeAgreement{
+			CodeID:    id,
+			Agreement: roundTo(100*ratio(both+neither, len(units)), 2),
+		}
+		if code, ok := codes[id]; ok {
+			agreement.Code = code.Path
+		}
+		if kappa, ok := cohensKappa(both, onlyFirst, onlySecond, neither); ok {
+			kappa = roundTo(kappa, 4)
+			agreement.Kappa = &kappa
+			kappaSum += kappa
+			kappaCount++
+		}
+		result.Codes = append(result.Codes, agreement)
+	}
+	sort.Slice(result.Codes, func(i, j int) bool { return result.Codes[i].Code < result.Codes[j].Code })
+	if kappaCount > 0 {
+		mean := roundTo(kappaSum/float64(kappaCount), 4)
+		result.MeanKappa = &mean
+	}
+	return result
+}
+
+// getCodeAgreement reports inter-rater agreement for every pair of coders, or
+// for the coders listed in ?coders=
+func getCodeAgreement(c *gin.Context) {
+	field := c.Query("field")
+	if field != "" {
+		if _, ok := lookupFreeTextField(field); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown free-text field %q", field)})
+			return
+		}
+	}
+	filter, err := parseResponseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	codes, _, err := loadCodebook()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	applications, err := loadCodeApplications(filter, field)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// coder -> answer -> codes applied, ignoring spans
+	applied := make(map[string]map[codedUnit]map[int64]bool)
+	for _, a := range applications {
+		if applied[a.coder] == nil {
+			applied[a.coder] = make(map[codedUnit]map[int64]bool)
+		}
+		if applied[a.coder][a.unit] == nil {
+			applied[a.coder][a.unit] = make(map[int64]bool)
+		}
+		applied[a.coder][a.unit][a.codeID] = true
+	}
+
+	var coders []string
+	if raw := c.Query("coders"); raw != "" {
+		for _, coder := range strings.Split(raw, ",") {
+			coders = append(coders, strings.TrimSpace(coder))
+		}
+	} else {
+		for coder := range applied {
+			coders = append(coders, coder)
+		}
+	}
+	sort.Strings(coders)
+
+	pairs := []CoderAgreement{}
+	for i := range coders {
+		for j := i + 1; j < len(coders); j++ {
+			pairs = append(pairs, compareCoders(codes, applied, coders[i], coders[j]))
+		}
+	}
+	c.JSON(http.StatusOK, pairs)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCohensKappa(t *testing.T) {
+	tests := []struct {
+		both, onlyFirst, onlySecond, neither int
+		want                                 float64
+		ok                                   bool
+	}{
+		// Textbook example: 70% observed, 50% chance agreement
+		{20, 5, 10, 15, 0.4, true},
+		{10, 0, 0, 10, 1, true},
+		{0, 10, 10, 0, -1, true},
+		// Neither rater ever applied the code
+		{0, 0, 0, 10, 0, false},
+		{0, 0, 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := cohensKappa(tt.both, tt.onlyFirst, tt.onlySecond, tt.neither)
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("cohensKappa(%d, %d, %d, %d) = %v, %v; want %v, %v",
+				tt.both, tt.onlyFirst, tt.onlySecond, tt.neither, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCompareCoders(t *testing.T) {
+	codes := map[int64]*Code{1: {ID: 1, Path: "Offline"}, 2: {ID: 2, Path: "Sync"}}
+	unit := func(id string) codedUnit { return codedUnit{responseID: id, field: "biggestFrustrations"} }
+	set := func(ids ...int64) map[int64]bool {
+		m := make(map[int64]bool)
+		for _, id := range ids {
+			m[id] = true
+		}
+		return m
+	}
+	applied := map[string]map[codedUnit]map[int64]bool{
+		"alice": {unit("r1"): set(1), unit("r2"): set(1), unit("r3"): set(2), unit("r4"): set(2), unit("r5"): set(1)},
+		"bob":   {unit("r1"): set(1), unit("r2"): set(2), unit("r3"): set(2), unit("r4"): set(1, 2)},
+	}
+
+	result := compareCoders(codes, applied, "alice", "bob")
+	// r5 was only coded by alice, so it doesn't count
+	if result.Units != 4 {
+		t.Fatalf("units = %d, want 4", result.Units)
+	}
+	want := map[string]struct{ agreement, kappa float64 }{
+		"Offline": {50, 0},
+		"Sync":    {75, 0.5},
+	}
+	if len(result.Codes) != len(want) {
+		t.Fatalf("got %d codes, want %d", len(result.Codes), len(want))
+	}
+	for _, code := range result.Codes {
+		w := want[code.Code]
+		if code.Agreement != w.agreement || code.Kappa == nil || *code.Kappa != w.kappa {
+			t.Errorf("%s: agreement %v, kappa %v; want %v, %v", code.Code, code.Agreement, code.Kappa, w.agreement, w.kappa)
+		}
+	}
+	if result.MeanKappa == nil || *result.MeanKappa != 0.25 {
+		t.Errorf("mean kappa = %v, want 0.25", result.MeanKappa)
+	}
+}
+
+// callAsCoder runs a handler as a signed-in admin
+func callAsCoder(handler gin.HandlerFunc, username, method, target string, body io.Reader, params ...gin.Param) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, target, body)
+	c.Params = params
+	c.Set("username", username)
+	handler(c)
+	return recorder
+}
+
+func createTestCode(t *testing.T, body string) Code {
+	t.Helper()
+	recorder := callHandler(createCode, "POST", "/codes", strings.NewReader(body))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("createCode(%s) returned %d: %s", body, recorder.Code, recorder.Body.String())
+	}
+	var code Code
+	if err := json.Unmarshal(recorder.Body.Bytes(), &code); err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestCodebook(t *testing.T) {
+	openTestDB(t)
+	tooling := createTestCode(t, `{"name":"Tooling"}`)
+	offline := createTestCode(t, fmt.Sprintf(`{"name":"Offline","parentId":%d}`, tooling.ID))
+	sync := createTestCode(t, fmt.Sprintf(`{"name":"Sync","parentId":%d}`, offline.ID))
+	if sync.Path != "Tooling > Offline > Sync" {
+		t.Errorf("path = %q", sync.Path)
+	}
+
+	for _, body := range []string{
+		`{"name":" "}`,
+		`{"name":"A > B"}`,
+		`{"name":"Tooling"}`,
+		`{"name":"Orphan","parentId":999}`,
+	} {
+		if recorder := callHandler(createCode, "POST", "/codes", strings.NewReader(body)); recorder.Code != http.StatusBadRequest {
+			t.Errorf("createCode(%s) returned %d, want 400", body, recorder.Code)
+		}
+	}
+
+	// A code can't be moved below its own descendant
+	id := fmt.Sprint(tooling.ID)
+	cycle := fmt.Sprintf(`{"name":"Tooling","parentId":%d}`, sync.ID)
+	if recorder := callHandler(updateCode, "PUT", "/codes/"+id, strings.NewReader(cycle), gin.Param{Key: "id", Value: id}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("moving a code below itself returned %d, want 400", recorder.Code)
+	}
+
+	if recorder := callHandler(deleteCode, "DELETE", "/codes/"+id, nil, gin.Param{Key: "id", Value: id}); recorder.Code != http.StatusConflict {
+		t.Errorf("deleting a code with children returned %d, want 409", recorder.Code)
+	}
+
+	recorder := callHandler(getCodebook, "GET", "/codes", nil)
+	var roots []*Code
+	if err := json.Unmarshal(recorder.Body.Bytes(), &roots); err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || len(roots[0].Children) != 1 || len(roots[0].Children[0].Children) != 1 {
+		t.Errorf("codebook = %s", recorder.Body.String())
+	}
+}
+
+func TestApplyCodesAndReport(t *testing.T) {
+	openTestDB(t)
+	offline := createTestCode(t, `{"name":"Offline"}`)
+	sync := createTestCode(t, fmt.Sprintf(`{"name":"Sync","parentId":%d}`, offline.ID))
+	first := submitTestSurvey(t, `{"role":"Developer","biggestFrustrations":"Sync breaks offline"}`)
+	second := submitTestSurvey(t, `{"role":"Designer","biggestFrustrations":"Working offline"}`)
+
+	apply := func(coder, responseID, body string) int {
+		return callAsCoder(applyCode, coder, "POST", "/results/"+responseID+"/codes", strings.NewReader(body),
+			gin.Param{Key: "id", Value: responseID}).Code
+	}
+	syncBody := fmt.Sprintf(`{"field":"biggestFrustrations","codeId":%d,"spanStart":0,"spanEnd":4}`, sync.ID)
+	offlineBody := fmt.Sprintf(`{"field":"biggestFrustrations","codeId":%d}`, offline.ID)
+
+	if code := apply("alice", first.ID, syncBody); code != http.StatusCreated {
+		t.Fatalf("applyCode returned %d", code)
+	}
+	if code := apply("alice", first.ID, syncBody); code != http.StatusConflict {
+		t.Errorf("applying the same code twice returned %d, want 409", code)
+	}
+	if code := apply("bob", first.ID, syncBody); code != http.StatusCreated {
+		t.Errorf("a second coder returned %d, want 201", code)
+	}
+	if code := apply("alice", second.ID, offlineBody); code != http.StatusCreated {
+		t.Errorf("applyCode returned %d", code)
+	}
+	for _, body := range []string{
+		`{"field":"email","codeId":1}`,
+		fmt.Sprintf(`{"field":"specificProblems","codeId":%d}`, offline.ID),
+		fmt.Sprintf(`{"field":"biggestFrustrations","codeId":%d,"spanStart":5,"spanEnd":500}`, offline.ID),
+		fmt.Sprintf(`{"field":"biggestFrustrations","codeId":%d,"spanStart":5}`, offline.ID),
+	} {
+		if code := apply("alice", second.ID, body); code != http.StatusBadRequest {
+			t.Errorf("applyCode(%s) returned %d, want 400", body, code)
+		}
+	}
+
+	recorder := callHandler(getResponseCodes, "GET", "/results/"+first.ID+"/codes", nil, gin.Param{Key: "id", Value: first.ID})
+	var applications []CodeApplication
+	if err := json.Unmarshal(recorder.Body.Bytes(), &applications); err != nil {
+		t.Fatal(err)
+	}
+	if len(applications) != 2 || applications[0].Excerpt != "Sync" || applications[0].Code != "Offline > Sync" {
+		t.Errorf("applications = %+v", applications)
+	}
+
+	recorder = callHandler(getCodeReport, "GET", "/codes/report", nil)
+	var frequencies []CodeFrequency
+	if err := json.Unmarshal(recorder.Body.Bytes(), &frequencies); err != nil {
+		t.Fatal(err)
+	}
+	// Responses tagged with Sync roll up into Offline's total
+	want := []CodeFrequency{
+		{CodeID: offline.ID, Code: "Offline", Applications: 1, Responses: 1, TotalResponses: 2, Coders: 1},
+		{CodeID: sync.ID, Code: "Offline > Sync", Applications: 2, Responses: 1, TotalResponses: 1, Coders: 2},
+	}
+	if len(frequencies) != 2 || frequencies[0] != want[0] || frequencies[1] != want[1] {
+		t.Errorf("report = %+v, want %+v", frequencies, want)
+	}
+
+	recorder = callHandler(getCodeReport, "GET", "/codes/report?role=Designer&coder=alice", nil)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &frequencies); err != nil {
+		t.Fatal(err)
+	}
+	if frequencies[0].Applications != 1 || frequencies[1].Applications != 0 {
+		t.Errorf("filtered report = %+v", frequencies)
+	}
+
+	recorder = callHandler(getCodeAgreement, "GET", "/codes/agreement", nil)
+	var pairs []CoderAgreement
+	if err := json.Unmarshal(recorder.Body.Bytes(), &pairs); err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 1 || pairs[0].Coders != [2]string{"alice", "bob"} || pairs[0].Units != 1 {
+		t.Errorf("agreement = %+v", pairs)
+	}
+}
+
+func TestCodingCoder(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("username", "admin")
+
+	tests := []struct {
+		requested, want string
+		valid           bool
+	}{
+		{"", "admin", true},
+		{"  Jana Novák ", "Jana Novák", true},
+		{"coder-2@example.com", "coder-2@example.com", true},
+		{"-dash", "", false},
+		{"a\nb", "", false},
+	}
+	for _, tt := range tests {
+		got, err := codingCoder(c, tt.requested)
+		if (err == nil) != tt.valid || got != tt.want {
+			t.Errorf("codingCoder(%q) = %q, %v; want %q, valid %v", tt.requested, got, err, tt.want, tt.valid)
+		}
+	}
+}
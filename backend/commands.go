@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// commands are maintenance tasks run as `main <command> [args]` instead of
+// starting the server
+var commands = map[string]func(args []string) error{
+	"backfill-sentiment": backfillSentiment,
+	"check-aggregates":   checkAggregates,
+	"cluster-themes":     clusterThemes,
+	"rebuild-aggregates": rebuildAggregates,
+	"rebuild-search":     rebuildSearchIndex,
+}
+
+func runCommand(name string, args []string) error {
+	command, ok := commands[name]
+	if !ok {
+		names := make([]string, 0, len(commands))
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown command %q, available: %s", name, strings.Join(names, ", "))
+	}
+	if err := initDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+	return command(args)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Differences are reported as significant below this p-value
+const significanceLevel = 0.05
+
+// categoricalColumns are the SurveyResponse fields that can be cross-tabulated
+// directly, keyed by their JSON name
+var categoricalColumns = map[string]string{
+	"role":                   "role",
+	"cmsUsage":               "cms_usage",
+	"teamSize":               "team_size",
+	"usageFrequency":         "usage_frequency",
+	"pricingModel":           "pricing_model",
+	"pricingSensitivity":     "pricing_sensitivity",
+	"cmsPreference":          "cms_preference",
+	"workflowImportance":     "workflow_importance",
+	"integrationImportance":  "integration_importance",
+	"collaborationFrequency": "collaboration_frequency",
+	"offlineWorkFrequency":   "offline_work_frequency",
+	"language":               "language",
+}
+
+type ChiSquareTest struct {
+	Statistic        float64 `json:"statistic"`
+	DegreesOfFreedom int     `json:"degreesOfFreedom"`
+	PValue           float64 `json:"pValue"`
+	CramersV         float64 `json:"cramersV"`
+	Significant      bool    `json:"significant"`
+	LowExpectedCells int     `json:"lowExpectedCells"`
+	Warning          string  `json:"warning,omitempty"`
+}
+
+type Crosstab struct {
+	RowField          string        `json:"rowField"`
+	ColumnField       string        `json:"columnField"`
+	Rows              []string      `json:"rows"`
+	Columns           []string      `json:"columns"`
+	Counts            [][]int       `json:"counts"`
+	RowTotals         []int         `json:"rowTotals"`
+	ColumnTotals      []int         `json:"columnTotals"`
+	Total             int           `json:"total"`
+	RowPercentages    [][]float64   `json:"rowPercentages"`
+	ColumnPercentages [][]float64   `json:"columnPercentages"`
+	ChiSquare         ChiSquareTest `json:"chiSquare"`
+}
+
+// crosstabExpression returns the SQL expression producing a field's category.
+// Feature scores ("features.<name>") are bucketed into low (1-2), neutral (3)
+// and high (4-5), leaving unanswered scores out.
+func crosstabExpression(field string) (string, error) {
+	if column, ok := categoricalColumns[field]; ok {
+		return "NULLIF(TRIM(" + column + "), '')", nil
+	}
+	if field == "betaInterest" {
+		return "CASE WHEN beta_interest = 1 THEN 'true' ELSE 'false' END", nil
+	}
+	if strings.HasPrefix(field, "features.") {
+		column, ok := featureColumns[strings.TrimPrefix(field, "features.")]
+		if !ok {
+			return "", fmt.Errorf("unknown feature %q", field)
+		}
+		return fmt.Sprintf(`CASE WHEN %[1]s BETWEEN 1 AND 2 THEN 'low'
+			WHEN %[1]s = 3 THEN 'neutral'
+			WHEN %[1]s BETWEEN 4 AND 5 THEN 'high' END`, column), nil
+	}
+	return "", fmt.Errorf("field %q cannot be cross-tabulated", field)
+}
+
+// chiSquareTest runs Pearson's test of independence on a contingency table
+func chiSquareTest(counts [][]int, rowTotals, columnTotals []int, total int) ChiSquareTest {
+	var test ChiSquareTest
+	if len(rowTotals) < 2 || len(columnTotals) < 2 || total == 0 {
+		test.PValue = 1
+		test.Warning = "at least two rows and two columns are needed for a chi-square test"
+		return test
+	}
+
+	for i, row := range counts {
+		for j, observed := range row {
+			expected := float64(rowTotals[i]) * float64(columnTotals[j]) / float64(total)
+			if expected < 5 {
+				test.LowExpectedCells++
+			}
+			if expected > 0 {
+				diff := float64(observed) - expected
+				test.Statistic += diff * diff / expected
+			}
+		}
+	}
+
+	test.DegreesOfFreedom = (len(rowTotals) - 1) * (len(columnTotals) - 1)
+	test.PValue = chiSquarePValue(test.Statistic, test.DegreesOfFreedom)
+	minDim := len(rowTotals) - 1
+	if len(columnTotals)-1 < minDim {
+		minDim = len(columnTotals) - 1
+	}
+	test.CramersV = math.Sqrt(test.Statistic / (float64(total) * float64(minDim)))
+	test.Significant = test.PValue < significanceLevel
+
+	// The usual rule of thumb: the approximation is unreliable when more than
+	// a fifth of the cells expect fewer than five responses
+	if cells := len(rowTotals) * len(columnTotals); test.LowExpectedCells*5 > cells {
+		test.Warning = "more than 20% of cells have an expected count below 5; the p-value may be unreliable"
+	}
+
+	test.Statistic = roundTo(test.Statistic, 4)
+	test.PValue = roundTo(test.PValue, 6)
+	test.CramersV = roundTo(test.CramersV, 4)
+	return test
+}
+
+func getCrosstab(c *gin.Context) {
+	rowField, columnField := c.Query("rows"), c.Query("columns")
+	if rowField == "" || columnField == "" || rowField == columnField {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rows and columns must name two different fields"})
+		return
+	}
+	rowExpr, err := crosstabExpression(rowField)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	columnExpr, err := crosstabExpression(columnField)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := parseResponseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := `SELECT * FROM (
+			SELECT ` + rowExpr + ` AS row_value, ` + columnExpr + ` AS column_value, COUNT(*)
+			FROM survey_responses` + filter.where() + `
+			GROUP BY row_value, column_value
+		) WHERE row_value IS NOT NULL AND column_value IS NOT NULL`
+	rows, err := db.Query(query, filter.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	cells := make(map[[2]string]int)
+	rowSet := make(map[string]bool)
+	columnSet := make(map[string]bool)
+	for rows.Next() {
+		var rowValue, columnValue string
+		var count int
+		if err := rows.Scan(&rowValue, &columnValue, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		cells[[2]string{rowValue, columnValue}] = count
+		rowSet[rowValue] = true
+		columnSet[columnValue] = true
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	table := Crosstab{
+		RowField:    rowField,
+		ColumnField: columnField,
+		Rows:        sortedCategories(rowField, rowSet),
+		Columns:     sortedCategories(columnField, columnSet),
+	}
+	table.RowTotals = make([]int, len(table.Rows))
+	table.ColumnTotals = make([]int, len(table.Columns))
+	table.Counts = make([][]int, len(table.Rows))
+	for i, r := range table.Rows {
+		table.Counts[i] = make([]int, len(table.Columns))
+		for j, col := range table.Columns {
+			count := cells[[2]string{r, col}]
+			table.Counts[i][j] = count
+			table.RowTotals[i] += count
+			table.ColumnTotals[j] += count
+			table.Total += count
+		}
+	}
+
+	table.RowPercentages = make([][]float64, len(table.Rows))
+	table.ColumnPercentages = make([][]float64, len(table.Rows))
+	for i := range table.Rows {
+		table.RowPercentages[i] = make([]float64, len(table.Columns))
+		table.ColumnPercentages[i] = make([]float64, len(table.Columns))
+		for j := range table.Columns {
+			table.RowPercentages[i][j] = roundTo(100*ratio(table.Counts[i][j], table.RowTotals[i]), 2)
+			table.ColumnPercentages[i][j] = roundTo(100*ratio(table.Counts[i][j], table.ColumnTotals[j]), 2)
+		}
+	}
+
+	table.ChiSquare = chiSquareTest(table.Counts, table.RowTotals, table.ColumnTotals, table.Total)
+	c.JSON(http.StatusOK, table)
+}
+
+// sortedCategories orders values by the question's option list where there is
+// one, so e.g. team sizes read smallest to largest, and alphabetically otherwise
+func sortedCategories(field string, set map[string]bool) []string {
+	var order []string
+	switch {
+	case strings.HasPrefix(field, "features."):
+		order = []string{"low", "neutral", "high"}
+	case field == "betaInterest":
+		order = []string{"false", "true"}
+	default:
+		order = questionOptions(field)
+	}
+	rank := make(map[string]int)
+	for i, value := range order {
+		rank[value] = i
+	}
+
+	values := make([]string, 0, len(set))
+	for value := range set {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		ri, iKnown := rank[values[i]]
+		rj, jKnown := rank[values[j]]
+		switch {
+		case iKnown && jKnown:
+			return ri < rj
+		case iKnown != jKnown:
+			return iKnown
+		}
+		return values[i] < values[j]
+	})
+	return values
+}
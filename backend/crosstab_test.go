@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSortedCategories(t *testing.T) {
+	set := map[string]bool{"200+": true, "1-5": true, "unknown": true, "21-50": true}
+	if got, want := sortedCategories("teamSize", set), []string{"1-5", "21-50", "200+", "unknown"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("team sizes sorted as %v, want %v", got, want)
+	}
+	features := map[string]bool{"high": true, "low": true, "neutral": true}
+	if got, want := sortedCategories("features.offline", features), []string{"low", "neutral", "high"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("feature buckets sorted as %v, want %v", got, want)
+	}
+	free := map[string]bool{"b": true, "a": true}
+	if got, want := sortedCategories("cmsPreference", free), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("free-text values sorted as %v, want %v", got, want)
+	}
+}
+
+func TestGetCrosstab(t *testing.T) {
+	openTestDB(t)
+	for _, body := range []string{
+		`{"role":"Developer","features":{"offline":5}}`,
+		`{"role":"Developer","features":{"offline":4}}`,
+		`{"role":"Developer","features":{"offline":1}}`,
+		`{"role":"Designer","features":{"offline":2}}`,
+		`{"role":"Designer","features":{"offline":3}}`,
+		`{"role":"Designer"}`,
+	} {
+		submitTestSurvey(t, body)
+	}
+
+	recorder := callHandler(getCrosstab, "GET", "/metrics/crosstab?rows=role&columns=features.offline", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("getCrosstab returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var table Crosstab
+	if err := json.Unmarshal(recorder.Body.Bytes(), &table); err != nil {
+		t.Fatal(err)
+	}
+
+	// The unanswered score is left out rather than counted as low
+	if want := []string{"Developer", "Designer"}; !reflect.DeepEqual(table.Rows, want) {
+		t.Errorf("rows = %v, want %v", table.Rows, want)
+	}
+	if want := []string{"low", "neutral", "high"}; !reflect.DeepEqual(table.Columns, want) {
+		t.Errorf("columns = %v, want %v", table.Columns, want)
+	}
+	if want := [][]int{{1, 0, 2}, {1, 1, 0}}; !reflect.DeepEqual(table.Counts, want) {
+		t.Errorf("counts = %v, want %v", table.Counts, want)
+	}
+	if table.Total != 5 || table.RowPercentages[0][2] != 66.67 || table.ColumnPercentages[0][0] != 50 {
+		t.Errorf("total %d, row %% %v, column %% %v", table.Total, table.RowPercentages, table.ColumnPercentages)
+	}
+	if table.ChiSquare.DegreesOfFreedom != 2 || table.ChiSquare.Warning == "" || table.ChiSquare.Significant {
+		t.Errorf("chi-square on a tiny sample = %+v, want an unreliable, insignificant result", table.ChiSquare)
+	}
+}
+
+func TestGetCrosstabErrors(t *testing.T) {
+	openTestDB(t)
+	for _, target := range []string{
+		"/metrics/crosstab?rows=role",
+		"/metrics/crosstab?rows=role&columns=role",
+		"/metrics/crosstab?rows=role&columns=email",
+		"/metrics/crosstab?rows=role&columns=features.speed",
+	} {
+		if recorder := callHandler(getCrosstab, "GET", target, nil); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, want 400", target, recorder.Code)
+		}
+	}
+}
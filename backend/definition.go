@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The survey form shows this many questions per step
+const fieldsPerStep = 5
+
+type SurveyOption struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+type SurveyQuestion struct {
+	ID             string         `json:"id"`
+	Name           string         `json:"name"`
+	Label          string         `json:"label"`
+	Type           string         `json:"type"`
+	Required       bool           `json:"required"`
+	Options        []SurveyOption `json:"options,omitempty"`
+	DependsOn      string         `json:"dependsOn,omitempty"`
+	DependsOnValue interface{}    `json:"dependsOnValue,omitempty"`
+	Step           int            `json:"step"`
+}
+
+type SurveyDefinition struct {
+	Language  string           `json:"language"`
+	Languages []string         `json:"languages"`
+	Steps     int              `json:"steps"`
+	Questions []SurveyQuestion `json:"questions"`
+}
+
+type questionSpec struct {
+	id             string
+	label          string
+	kind           string
+	options        []string
+	required       bool
+	dependsOn      string
+	dependsOnValue interface{}
+}
+
+// surveyQuestions is the canonical English survey. Option values are what
+// gets stored in survey_responses, whatever language the form was shown in.
+var surveyQuestions = []questionSpec{
+	{id: "teamSize", label: "What size is your team?", kind: "select",
+		options: []string{"1-5", "6-20", "21-50", "51-200", "200+"}, required: true},
+	{id: "role", label: "What role best describes you?", kind: "select",
+		options: []string{"Developer", "Content Editor", "Designer", "Manager", "Other"}, required: true},
+	{id: "cmsUsage", label: "Do you currently use a CMS?", kind: "select",
+		options: []string{"Yes", "No"}, required: true},
+	{id: "features", label: "Rate the importance of these features (1-5)", kind: "radio",
+		options: []string{"1", "2", "3", "4", "5"}, required: true},
+	{id: "pricingModel", label: "Preferred pricing model?", kind: "select",
+		options: []string{"Per User", "Per Project", "Usage Based", "Flat Rate"}, required: true},
+	{id: "betaInterest", label: "Interested in beta testing?", kind: "select",
+		options: []string{"Yes", "No"}, required: true},
+	{id: "email", label: "Email (if interested in beta)", kind: "email",
+		dependsOn: "betaInterest", dependsOnValue: true},
+	{id: "biggestFrustrations", label: "What are your biggest frustrations with current CMS solutions?", kind: "textarea", required: true},
+	{id: "specificProblems", label: "What specific problems are you trying to solve?", kind: "textarea", required: true},
+	{id: "usageFrequency", label: "How often do you use your CMS?", kind: "select",
+		options: []string{"Daily", "Weekly", "Monthly", "Rarely"}, required: true},
+	{id: "primaryPurpose", label: "What is your primary purpose for using a CMS?", kind: "textarea", required: true},
+	{id: "platforms", label: "Which platforms do you primarily work with?", kind: "textarea", required: true},
+	{id: "collaborationChallenges", label: "What challenges do you face with team collaboration?", kind: "textarea", required: true},
+	{id: "offlineWorkFrequency", label: "How often do you need to work offline?", kind: "select",
+		options: []string{"Never", "Rarely", "Sometimes", "Often", "Always"}, required: true},
+}
+
+var surveyStepCount = (len(surveyQuestions) + fieldsPerStep - 1) / fieldsPerStep
+
+func localizedQuestions(lang string) []SurveyQuestion {
+	questions := make([]SurveyQuestion, 0, len(surveyQuestions))
+	for i, spec := range surveyQuestions {
+		question := SurveyQuestion{
+			ID:             spec.id,
+			Name:           spec.id,
+			Label:          translate(lang, "question."+spec.id, spec.label),
+			Type:           spec.kind,
+			Required:       spec.required,
+			DependsOn:      spec.dependsOn,
+			DependsOnValue: spec.dependsOnValue,
+			Step:           i / fieldsPerStep,
+		}
+		for _, value := range spec.options {
+			question.Options = append(question.Options, SurveyOption{
+				Value: value,
+				Label: translate(lang, "option."+spec.id+"."+value, value),
+			})
+		}
+		questions = append(questions, question)
+	}
+	return questions
+}
+
+// canonicalizeOptions maps any select answers that were sent as translated
+// labels back to their canonical option values
+func canonicalizeOptions(survey *SurveyResponse, lang string) {
+	answers := map[string]*string{
+		"teamSize":             &survey.TeamSize,
+		"role":                 &survey.Role,
+		"cmsUsage":             &survey.CmsUsage,
+		"pricingModel":         &survey.PricingModel,
+		"usageFrequency":       &survey.UsageFrequency,
+		"offlineWorkFrequency": &survey.OfflineWorkFrequency,
+	}
+	for _, spec := range surveyQuestions {
+		answer, ok := answers[spec.id]
+		if !ok || *answer == "" {
+			continue
+		}
+		for _, value := range spec.options {
+			if *answer == translate(lang, "option."+spec.id+"."+value, value) {
+				*answer = value
+				break
+			}
+		}
+	}
+}
+
+// getSurveyDefinition serves the survey questions in the language negotiated
+// from ?lang= or the Accept-Language header
+func getSurveyDefinition(c *gin.Context) {
+	lang := requestLanguage(c)
+	c.Header("Content-Language", lang)
+	c.Header("Vary", "Accept-Language")
+	c.JSON(http.StatusOK, SurveyDefinition{
+		Language:  lang,
+		Languages: supportedLanguages,
+		Steps:     surveyStepCount,
+		Questions: localizedQuestions(lang),
+	})
+}
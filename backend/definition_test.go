@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetSurveyDefinition(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/survey/definition", nil)
+	c.Request.Header.Set("Accept-Language", "de-DE,de;q=0.9")
+	getSurveyDefinition(c)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("getSurveyDefinition returned %d", recorder.Code)
+	}
+	if got := recorder.Header().Get("Content-Language"); got != "de" {
+		t.Errorf("Content-Language = %q, want de", got)
+	}
+
+	var definition SurveyDefinition
+	if err := json.Unmarshal(recorder.Body.Bytes(), &definition); err != nil {
+		t.Fatal(err)
+	}
+	if definition.Language != "de" || definition.Steps != surveyStepCount || len(definition.Questions) != len(surveyQuestions) {
+		t.Fatalf("unexpected definition %+v", definition)
+	}
+	for _, question := range definition.Questions {
+		if question.Step >= definition.Steps {
+			t.Errorf("question %s is on step %d of %d", question.ID, question.Step, definition.Steps)
+		}
+		if question.ID != "role" {
+			continue
+		}
+		if question.Label != "Welche Rolle beschreibt Sie am besten?" {
+			t.Errorf("role label = %q", question.Label)
+		}
+		// Option values stay canonical so answers are stored in English
+		if question.Options[0].Value != "Developer" || question.Options[0].Label != "Entwickler:in" {
+			t.Errorf("role option = %+v", question.Options[0])
+		}
+	}
+}
+
+func TestGetSurveyDefinitionQueryOverridesHeader(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/survey/definition?lang=FR", nil)
+	c.Request.Header.Set("Accept-Language", "de")
+	getSurveyDefinition(c)
+
+	var definition SurveyDefinition
+	if err := json.Unmarshal(recorder.Body.Bytes(), &definition); err != nil {
+		t.Fatal(err)
+	}
+	if definition.Language != "fr" {
+		t.Errorf("language = %q, want fr", definition.Language)
+	}
+}
+
+func TestCanonicalizeOptions(t *testing.T) {
+	survey := SurveyResponse{
+		Role:                 "Designer:in",
+		CmsUsage:             "Ja",
+		PricingModel:         "Per Project",
+		UsageFrequency:       "Wöchentlich",
+		OfflineWorkFrequency: "Something else",
+	}
+	canonicalizeOptions(&survey, "de")
+
+	if survey.Role != "Designer" || survey.CmsUsage != "Yes" || survey.UsageFrequency != "Weekly" {
+		t.Errorf("translated answers not mapped back: %+v", survey)
+	}
+	if survey.PricingModel != "Per Project" || survey.OfflineWorkFrequency != "Something else" {
+		t.Errorf("untranslated answers changed: %+v", survey)
+	}
+}
+
+func TestSubmitStoresLanguage(t *testing.T) {
+	openTestDB(t)
+	submitted := submitTestSurvey(t, `{"role":"Rédacteur·rice","cmsUsage":"Oui","language":"fr"}`)
+
+	response, err := getSurveyResponse(submitted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Language != "fr" || response.Role != "Content Editor" || response.CmsUsage != "Yes" {
+		t.Errorf("stored language %q role %q cmsUsage %q", response.Language, response.Role, response.CmsUsage)
+	}
+}
+
+func TestSubmitWithoutLanguageIsEnglish(t *testing.T) {
+	openTestDB(t)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/survey", strings.NewReader(`{"role":"Developer","cmsUsage":"Yes"}`))
+	c.Request.Header.Set("Accept-Language", "de-DE,de;q=0.9")
+	submitSurvey(c)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("submitSurvey returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var result surveySubmissionResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	response, err := getSurveyResponse(result.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The browser language is not the language the answers were given in
+	if response.Language != "en" {
+		t.Errorf("stored language %q, want en", response.Language)
+	}
+}
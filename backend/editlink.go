@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const editTokenPurpose = "response-edit"
+
+// surveySubmission is the body accepted by POST /survey
+type surveySubmission struct {
+	SurveyResponse
+	RequestEditLink bool   `json:"requestEditLink,omitempty"`
+	InviteCode      string `json:"inviteCode,omitempty"`
+	// SessionID is the progress-tracking session, used to time the submission
+	SessionID string `json:"sessionId,omitempty"`
+	// Challenge and Solution answer the proof-of-work from GET /survey/challenge
+	Challenge string `json:"challenge,omitempty"`
+	Solution  string `json:"solution,omitempty"`
+	// Website is a honeypot the form hides from people
+	Website string `json:"website,omitempty"`
+}
+
+// surveySubmissionResult is returned from POST /survey, carrying the edit
+// token when the respondent asked for one and any uploaded attachments
+type surveySubmissionResult struct {
+	SurveyResponse
+	EditToken          string       `json:"editToken,omitempty"`
+	EditTokenExpiresAt *time.Time   `json:"editTokenExpiresAt,omitempty"`
+	Attachments        []Attachment `json:"attachments,omitempty"`
+}
+
+// editTokenTTL is how long a respondent may edit their answers after submitting
+func editTokenTTL() time.Duration {
+	hours, err := strconv.Atoi(getEnvWithFallback("EDIT_LINK_TTL_HOURS", "168"))
+	if err != nil || hours <= 0 {
+		hours = 168
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// editTokenKey derives a key from JWT_SECRET so edit tokens can never be
+// accepted by AuthMiddleware as admin tokens
+func editTokenKey() []byte {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte(editTokenPurpose))
+	return mac.Sum(nil)
+}
+
+func issueEditToken(responseID string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(editTokenTTL())
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":     responseID,
+		"purpose": editTokenPurpose,
+		"exp":     expiresAt.Unix(),
+		"iat":     time.Now().Unix(),
+	})
+	signed, err := token.SignedString(editTokenKey())
+	return signed, expiresAt, err
+}
+
+func verifyEditToken(tokenString, responseID string) error {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return editTokenKey(), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return fmt.Errorf("invalid token claims")
+	}
+	if claims["purpose"] != editTokenPurpose || claims["sub"] != responseID {
+		return fmt.Errorf("token does not grant access to this response")
+	}
+	return nil
+}
+
+// editLinkURL builds the link emailed to respondents, if a base URL is configured
+func editLinkURL(responseID, token string) string {
+	base := os.Getenv("EDIT_LINK_BASE_URL")
+	if base == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s?id=%s&token=%s", base, url.QueryEscape(responseID), url.QueryEscape(token))
+}
+
+func sendEditLink(survey SurveyResponse, token string, expiresAt time.Time) {
+	link := editLinkURL(survey.ID, token)
+	if survey.Email == "" || link == "" {
+		return
+	}
+
+	body := fmt.Sprintf("Thanks for taking the LocalHaven CMS survey.\r\n\r\n"+
+		"You can review or change your answers until %s using this link:\r\n\r\n%s\r\n",
+		expiresAt.UTC().Format("2 January 2006 15:04 MST"), link)
+	if err := sendMail(survey.Email, "Edit your LocalHaven CMS survey response", body); err != nil {
+		log.Printf("Failed to send edit link for response %s: %v", survey.ID, err)
+	}
+}
+
+// requestEditToken reads the edit token from the X-Edit-Token header, falling
+// back to ?token= for links opened from the email. The access log redacts the
+// query parameter.
+func requestEditToken(c *gin.Context) string {
+	if token := c.GetHeader("X-Edit-Token"); token != "" {
+		return token
+	}
+	return c.Query("token")
+}
+
+// editableResponse checks the edit token on the request and loads the response it grants access to
+func editableResponse(c *gin.Context) (SurveyResponse, bool) {
+	id := c.Param("id")
+	if err := verifyEditToken(requestEditToken(c), id); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Invalid edit token: %v", err)})
+		return SurveyResponse{}, false
+	}
+
+	survey, err := getSurveyResponse(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "response not found"})
+		return SurveyResponse{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return SurveyResponse{}, false
+	}
+	return survey, true
+}
+
+func getOwnResponse(c *gin.Context) {
+	survey, ok := editableResponse(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, survey)
+}
+
+func updateOwnResponse(c *gin.Context) {
+	previous, ok := editableResponse(c)
+	if !ok {
+		return
+	}
+
+	var survey SurveyResponse
+	if err := c.BindJSON(&survey); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateSurveyResponse(&survey); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Identity and submission time always come from the stored row
+	survey.ID = previous.ID
+	survey.CreatedAt = previous.CreatedAt
+	survey.Language = previous.Language
+	canonicalizeOptions(&survey, survey.Language)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := recordRevision(tx, &previous, survey, "respondent", "updated"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := updateSurveyResponse(tx, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Switching role moves the response into another quota segment
+	if survey.Role != previous.Role {
+		if err := checkSurveyAvailability(tx, &survey); err != nil {
+			if isAvailabilityError(err) {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := scoreSubmission(tx, &survey, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := enqueueBetaSignup(tx, &previous, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := syncWaitlist(tx, survey.ID, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyMetricsDelta(tx, &previous, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordResponseEvent(tx, streamResponseUpdated, &previous, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, survey)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// submitTestSurvey posts a submission through the public handler
+func submitTestSurvey(t *testing.T, body string) surveySubmissionResult {
+	t.Helper()
+	recorder := callHandler(submitSurvey, "POST", "/survey", strings.NewReader(body))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("submitSurvey returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var result surveySubmissionResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestVerifyEditToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	token, expiresAt, err := issueEditToken("resp-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(expiresAt) <= 0 {
+		t.Errorf("token expires at %v, want it in the future", expiresAt)
+	}
+
+	// An admin token is signed with JWT_SECRET itself, not the derived key
+	admin, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "resp-1", "purpose": editTokenPurpose, "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "resp-1", "purpose": editTokenPurpose, "exp": time.Now().Add(-time.Minute).Unix(),
+	}).SignedString(editTokenKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		token, id  string
+		wantAccess bool
+	}{
+		{"own response", token, "resp-1", true},
+		{"other response", token, "resp-2", false},
+		{"admin token", admin, "resp-1", false},
+		{"expired", expired, "resp-1", false},
+		{"garbage", "not-a-token", "resp-1", false},
+	}
+	for _, tt := range tests {
+		err := verifyEditToken(tt.token, tt.id)
+		if (err == nil) != tt.wantAccess {
+			t.Errorf("%s: verifyEditToken() error = %v, want access %v", tt.name, err, tt.wantAccess)
+		}
+	}
+}
+
+func TestEditOwnResponse(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("EDIT_LINK_BASE_URL", "")
+	openTestDB(t)
+
+	submitted := submitTestSurvey(t, `{"role":"developer","cmsUsage":"wordpress","requestEditLink":true}`)
+	if submitted.EditToken == "" {
+		t.Fatal("no edit token issued")
+	}
+	id := gin.Param{Key: "id", Value: submitted.ID}
+
+	recorder := callHandler(getOwnResponse, "GET", "/survey/"+submitted.ID+"?token="+submitted.EditToken, nil, id)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("getOwnResponse returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = callHandler(getOwnResponse, "GET", "/survey/"+submitted.ID+"?token=wrong", nil, id)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token returned %d, want 401", recorder.Code)
+	}
+
+	edit := `{"id":"other","role":"designer","cmsUsage":"ghost"}`
+	recorder = callHandler(updateOwnResponse, "PUT", "/survey/"+submitted.ID+"?token="+submitted.EditToken,
+		bytes.NewBufferString(edit), id)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("updateOwnResponse returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	stored, err := getSurveyResponse(submitted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Role != "designer" || stored.CmsUsage != "ghost" {
+		t.Errorf("stored response = %+v, want the edit applied", stored)
+	}
+	if !stored.CreatedAt.Equal(submitted.CreatedAt) {
+		t.Errorf("created at changed from %v to %v", submitted.CreatedAt, stored.CreatedAt)
+	}
+	var revisions int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM survey_response_revisions WHERE response_id = ?`,
+		submitted.ID).Scan(&revisions); err != nil {
+		t.Fatal(err)
+	}
+	if revisions == 0 {
+		t.Error("edit did not record the previous version")
+	}
+}
+
+func insertTestResponse(t *testing.T, survey SurveyResponse) {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := insertSurveyResponse(tx, &survey); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// editOwnResponse sends PUT /survey/:id with the edit token in the header
+func editOwnResponse(t *testing.T, id, token string, survey SurveyResponse) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(survey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("PUT", "/survey/"+id, bytes.NewReader(body))
+	c.Request.Header.Set("X-Edit-Token", token)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	updateOwnResponse(c)
+	return recorder
+}
+
+func TestUpdateOwnResponseRespectsRoleQuota(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	openTestDB(t)
+	insertTestResponse(t, SurveyResponse{ID: "designer-1", Role: "designer", CmsUsage: "wordpress", CreatedAt: time.Now()})
+	insertTestResponse(t, SurveyResponse{ID: "dev-1", Role: "developer", CmsUsage: "wordpress", CreatedAt: time.Now()})
+	if _, err := db.Exec(`INSERT INTO survey_quotas (segment, max_responses) VALUES ('designer', 1)`); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := issueEditToken("dev-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edit := SurveyResponse{Role: "designer", CmsUsage: "ghost"}
+	if recorder := editOwnResponse(t, "dev-1", token, edit); recorder.Code != http.StatusForbidden {
+		t.Fatalf("switching into a full segment returned %d, want 403: %s", recorder.Code, recorder.Body.String())
+	}
+	stored, err := getSurveyResponse("dev-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Role != "developer" || stored.CmsUsage != "wordpress" {
+		t.Errorf("rejected edit was stored: %+v", stored)
+	}
+
+	edit.Role = "developer"
+	if recorder := editOwnResponse(t, "dev-1", token, edit); recorder.Code != http.StatusOK {
+		t.Fatalf("edit within the same segment returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestRequestEditToken(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/survey/abc?token=from-query", nil)
+	if got := requestEditToken(c); got != "from-query" {
+		t.Errorf("requestEditToken() = %q, want the query token", got)
+	}
+	c.Request.Header.Set("X-Edit-Token", "from-header")
+	if got := requestEditToken(c); got != "from-header" {
+		t.Errorf("requestEditToken() = %q, want the header token", got)
+	}
+}
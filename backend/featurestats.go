@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Feature importance is rated on a 1-5 scale; 0 means the question was skipped
+const maxFeatureScore = 5
+
+// FeatureStats summarises the answered (1-5) scores for one feature
+type FeatureStats struct {
+	Feature            string         `json:"feature"`
+	Rank               int            `json:"rank"`
+	Responses          int            `json:"responses"`
+	Mean               float64        `json:"mean"`
+	Median             float64        `json:"median"`
+	StdDev             float64        `json:"stdDev"`
+	ConfidenceInterval [2]float64     `json:"confidenceInterval95"`
+	TopTwoBox          float64        `json:"topTwoBoxPercentage"`
+	Histogram          map[string]int `json:"histogram"`
+
+	// mean is the unrounded Mean, which features are ranked on
+	mean float64
+}
+
+// featureHistograms counts how often each score was given for every feature
+// in a single pass over the table
+func featureHistograms(filter responseFilter) (map[string][]int, error) {
+	var columns []string
+	for _, name := range featureNames {
+		for score := 1; score <= maxFeatureScore; score++ {
+			columns = append(columns, fmt.Sprintf("COALESCE(SUM(CASE WHEN %s = %d THEN 1 ELSE 0 END), 0)",
+				featureColumns[name], score))
+		}
+	}
+
+	counts := make([]int, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range counts {
+		dest[i] = &counts[i]
+	}
+	err := db.QueryRow(`SELECT `+strings.Join(columns, ", ")+` FROM survey_responses`+filter.where(),
+		filter.args...).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	histograms := make(map[string][]int)
+	for i, name := range featureNames {
+		histograms[name] = counts[i*maxFeatureScore : (i+1)*maxFeatureScore]
+	}
+	return histograms, nil
+}
+
+func summariseFeature(name string, counts []int) FeatureStats {
+	stats := FeatureStats{Feature: name, Histogram: make(map[string]int)}
+
+	var sum, sumSquares float64
+	for i, count := range counts {
+		score := float64(i + 1)
+		stats.Histogram[strconv.Itoa(i+1)] = count
+		stats.Responses += count
+		sum += score * float64(count)
+		sumSquares += score * score * float64(count)
+	}
+	if stats.Responses == 0 {
+		return stats
+	}
+
+	n := float64(stats.Responses)
+	mean := sum / n
+	stats.mean = mean
+	stats.Mean = roundTo(mean, 2)
+	stats.Median = histogramMedian(counts, stats.Responses)
+	stats.TopTwoBox = roundTo(100*float64(counts[maxFeatureScore-1]+counts[maxFeatureScore-2])/n, 2)
+
+	// Sample standard deviation and a t-based 95% interval for the mean
+	if stats.Responses > 1 {
+		variance := (sumSquares - n*mean*mean) / (n - 1)
+		stdDev := math.Sqrt(math.Max(variance, 0))
+		margin := tCritical(stats.Responses-1) * stdDev / math.Sqrt(n)
+		stats.StdDev = roundTo(stdDev, 2)
+		stats.ConfidenceInterval = [2]float64{
+			roundTo(math.Max(1, mean-margin), 2),
+			roundTo(math.Min(maxFeatureScore, mean+margin), 2),
+		}
+	} else {
+		stats.ConfidenceInterval = [2]float64{stats.Mean, stats.Mean}
+	}
+	return stats
+}
+
+// rankFeatures returns per-feature statistics and the features ranked by
+// mean score, breaking ties on top-two-box share
+func rankFeatures(histograms map[string][]int) (map[string]FeatureStats, []string) {
+	ranked := make([]FeatureStats, 0, len(featureNames))
+	for _, name := range featureNames {
+		ranked = append(ranked, summariseFeature(name, histograms[name]))
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].mean != ranked[j].mean {
+			return ranked[i].mean > ranked[j].mean
+		}
+		return ranked[i].TopTwoBox > ranked[j].TopTwoBox
+	})
+
+	stats := make(map[string]FeatureStats)
+	priorities := make([]string, 0, len(ranked))
+	for i, s := range ranked {
+		s.Rank = i + 1
+		stats[s.Feature] = s
+		priorities = append(priorities, s.Feature)
+	}
+	return stats, priorities
+}
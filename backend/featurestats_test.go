@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSummariseFeature(t *testing.T) {
+	stats := summariseFeature("offline", []int{1, 1, 2, 3, 3})
+
+	if stats.Responses != 10 || stats.Mean != 3.6 || stats.Median != 4 {
+		t.Errorf("responses %d, mean %v, median %v; want 10, 3.6, 4", stats.Responses, stats.Mean, stats.Median)
+	}
+	if stats.StdDev != 1.35 || stats.TopTwoBox != 60 {
+		t.Errorf("stdDev %v, top two box %v; want 1.35, 60", stats.StdDev, stats.TopTwoBox)
+	}
+	// 3.6 ± 2.262 * 1.35 / sqrt(10)
+	if stats.ConfidenceInterval != [2]float64{2.63, 4.57} {
+		t.Errorf("confidence interval = %v, want [2.63 4.57]", stats.ConfidenceInterval)
+	}
+	if stats.Histogram["5"] != 3 || len(stats.Histogram) != maxFeatureScore {
+		t.Errorf("histogram = %v", stats.Histogram)
+	}
+}
+
+func TestSummariseFeatureSmallSamples(t *testing.T) {
+	empty := summariseFeature("pdfHandling", make([]int, maxFeatureScore))
+	if empty.Responses != 0 || empty.Mean != 0 || empty.ConfidenceInterval != [2]float64{} {
+		t.Errorf("no answers: %+v", empty)
+	}
+
+	single := summariseFeature("pdfHandling", []int{0, 0, 0, 1, 0})
+	if single.Mean != 4 || single.StdDev != 0 || single.ConfidenceInterval != [2]float64{4, 4} {
+		t.Errorf("one answer: %+v", single)
+	}
+
+	// The interval is clamped to the 1-5 scale
+	wide := summariseFeature("pdfHandling", []int{1, 0, 0, 0, 1})
+	if wide.ConfidenceInterval != [2]float64{1, 5} {
+		t.Errorf("two extreme answers: interval %v, want [1 5]", wide.ConfidenceInterval)
+	}
+}
+
+func TestMetricsFeatureStats(t *testing.T) {
+	openTestDB(t)
+	for _, body := range []string{
+		`{"role":"Developer","features":{"offline":5,"pdfHandling":2}}`,
+		`{"role":"Developer","features":{"offline":4,"pdfHandling":3}}`,
+		`{"role":"Designer","features":{"offline":4,"workflows":5}}`,
+	} {
+		submitTestSurvey(t, body)
+	}
+
+	recorder := callHandler(getMetrics, "GET", "/metrics", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("getMetrics returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var metrics Metrics
+	if err := json.Unmarshal(recorder.Body.Bytes(), &metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	offline := metrics.FeatureStats["offline"]
+	if offline.Responses != 3 || offline.Mean != 4.33 || offline.Rank != 2 {
+		t.Errorf("offline stats = %+v", offline)
+	}
+	// Skipped questions are not counted as answers
+	if metrics.FeatureStats["workflows"].Responses != 1 || metrics.FeatureStats["collaboration"].Responses != 0 {
+		t.Errorf("workflows %+v, collaboration %+v", metrics.FeatureStats["workflows"], metrics.FeatureStats["collaboration"])
+	}
+	if len(metrics.FeaturePriorities) != len(featureNames) || metrics.FeaturePriorities[0] != "workflows" ||
+		metrics.FeaturePriorities[1] != "offline" || metrics.FeaturePriorities[2] != "pdfHandling" {
+		t.Errorf("priorities = %v", metrics.FeaturePriorities)
+	}
+}
+
+func TestRankFeaturesUsesUnroundedMean(t *testing.T) {
+	// Means of 1.9167 and 1.9231 both round to 1.92; the higher mean must win
+	// even though the other feature has the larger top-two-box share
+	histograms := make(map[string][]int)
+	for _, name := range featureNames {
+		histograms[name] = make([]int, maxFeatureScore)
+	}
+	histograms["offline"] = []int{4, 6, 1, 1, 0}
+	histograms["workflows"] = []int{4, 6, 3, 0, 0}
+
+	stats, priorities := rankFeatures(histograms)
+	if stats["offline"].Mean != stats["workflows"].Mean {
+		t.Fatalf("rounded means differ: %v and %v", stats["offline"].Mean, stats["workflows"].Mean)
+	}
+	if priorities[0] != "workflows" || priorities[1] != "offline" {
+		t.Errorf("priorities = %v, want workflows ranked above offline", priorities)
+	}
+}
+
+func TestAverageFeatureScoresSkipUnanswered(t *testing.T) {
+	openTestDB(t)
+	scores := [][2]int{{4, 0}, {2, 0}, {0, 3}}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	for i, score := range scores {
+		survey := SurveyResponse{
+			ID:        string(rune('a' + i)),
+			Role:      "developer",
+			CmsUsage:  "wordpress",
+			Features:  Features{Offline: score[0], Workflows: score[1]},
+			CreatedAt: time.Now(),
+		}
+		if err := insertSurveyResponse(tx, &survey); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyMetricsDelta(tx, nil, &survey); err != nil {
+			t.Fatal(err)
+		}
+		if err := recordResponseEvent(tx, streamResponseCreated, nil, &survey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	live, _, err := liveMetrics(responseFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _, err := aggregateMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"offline": 3, "workflows": 3, "collaboration": 0}
+	for name, mean := range want {
+		if live.AverageFeatureScores[name] != mean {
+			t.Errorf("live average for %s = %v, want %v", name, live.AverageFeatureScores[name], mean)
+		}
+		if stored.AverageFeatureScores[name] != mean {
+			t.Errorf("aggregate average for %s = %v, want %v", name, stored.AverageFeatureScores[name], mean)
+		}
+	}
+	if err := checkAggregates(nil); err != nil {
+		t.Errorf("checkAggregates: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseFilter builds a parameterized WHERE clause over survey_responses
+type responseFilter struct {
+	clauses []string
+	args    []interface{}
+}
+
+func (f *responseFilter) add(clause string, args ...interface{}) {
+	f.clauses = append(f.clauses, clause)
+	f.args = append(f.args, args...)
+}
+
+// with returns a copy of the filter with one more condition
+func (f responseFilter) with(clause string, args ...interface{}) responseFilter {
+	copied := responseFilter{
+		clauses: append([]string(nil), f.clauses...),
+		args:    append([]interface{}(nil), f.args...),
+	}
+	copied.add(clause, args...)
+	return copied
+}
+
+func (f responseFilter) where() string {
+	if len(f.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.clauses, " AND ")
+}
+
+// responseFilterParams maps query parameters to the columns they filter on.
+// Each accepts a comma-separated list of values.
+var responseFilterParams = []struct {
+	param  string
+	column string
+}{
+	{"role", "role"},
+	{"teamSize", "team_size"},
+	{"cmsUsage", "cms_usage"},
+	{"language", "language"},
+}
+
+// parseEndParam parses the end of a date range. A plain date includes the
+// whole of that day.
+func parseEndParam(value string, loc *time.Location) (time.Time, error) {
+	t, err := parseTimeParam(value, loc)
+	if err != nil {
+		return t, err
+	}
+	if _, dateErr := time.Parse("2006-01-02", value); dateErr == nil {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// requestLocation returns the time zone named by ?tz=, defaulting to UTC
+func requestLocation(c *gin.Context) (*time.Location, error) {
+	name := c.Query("tz")
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// parseResponseFilter reads the segment filters shared by /results and
+// /metrics: role, teamSize, cmsUsage, language, betaInterest, spam, from and to.
+// Plain dates in from and to are interpreted in the ?tz= time zone.
+func parseResponseFilter(c *gin.Context) (responseFilter, error) {
+	var filter responseFilter
+	loc, err := requestLocation(c)
+	if err != nil {
+		return filter, err
+	}
+
+	for _, p := range responseFilterParams {
+		raw := c.Query(p.param)
+		if raw == "" {
+			continue
+		}
+		var values []interface{}
+		for _, value := range strings.Split(raw, ",") {
+			values = append(values, strings.TrimSpace(value))
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+		filter.add(p.column+" IN ("+placeholders+")", values...)
+	}
+
+	if raw := c.Query("betaInterest"); raw != "" {
+		beta, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, fmt.Errorf("betaInterest must be true or false")
+		}
+		filter.add("beta_interest = ?", beta)
+	}
+	switch c.Query("spam") {
+	case "":
+	case "flagged":
+		filter.add("spam_score >= ?", spamThreshold())
+	case "clean":
+		filter.add("spam_score < ?", spamThreshold())
+	default:
+		return filter, fmt.Errorf("spam must be flagged or clean")
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseTimeParam(from, loc)
+		if err != nil {
+			return filter, err
+		}
+		filter.add("julianday(created_at) >= julianday(?)", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseEndParam(to, loc)
+		if err != nil {
+			return filter, err
+		}
+		filter.add("julianday(created_at) < julianday(?)", t)
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseEndParam(t *testing.T) {
+	day, err := parseEndParam("2026-03-02", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC); !day.Equal(want) {
+		t.Errorf("parseEndParam(date) = %v, want the start of the next day", day)
+	}
+
+	exact, err := parseEndParam("2026-03-02T10:30:00Z", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 3, 2, 10, 30, 0, 0, time.UTC); !exact.Equal(want) {
+		t.Errorf("parseEndParam(timestamp) = %v, want %v", exact, want)
+	}
+
+	if _, err := parseEndParam("yesterday", time.UTC); err == nil {
+		t.Error("parseEndParam accepted an invalid date")
+	}
+}
+
+func TestParseResponseFilter(t *testing.T) {
+	tests := []struct {
+		query   string
+		clauses int
+		args    int
+		wantErr bool
+	}{
+		{"", 0, 0, false},
+		{"role=Developer", 1, 1, false},
+		{"role=Developer,Designer&teamSize=1-5", 2, 3, false},
+		{"betaInterest=true&language=de", 2, 2, false},
+		{"from=2026-03-01&to=2026-03-02", 2, 2, false},
+		{"betaInterest=maybe", 0, 0, true},
+		{"from=last-week", 0, 0, true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/results?"+tt.query, nil)
+		filter, err := parseResponseFilter(c)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseResponseFilter(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (len(filter.clauses) != tt.clauses || len(filter.args) != tt.args) {
+			t.Errorf("parseResponseFilter(%q) = %d clauses and %d args, want %d and %d",
+				tt.query, len(filter.clauses), len(filter.args), tt.clauses, tt.args)
+		}
+	}
+}
+
+// seedSegmentResponses stores responses across roles, languages and days
+func seedSegmentResponses(t *testing.T) {
+	t.Helper()
+	openTestDB(t)
+
+	seeds := []struct {
+		body string
+		day  int
+	}{
+		{`{"role":"Developer","cmsUsage":"Yes","teamSize":"1-5","betaInterest":true,"email":"dev@example.com"}`, 1},
+		{`{"role":"Designer","cmsUsage":"Yes","teamSize":"6-20","language":"de"}`, 2},
+		{`{"role":"Developer","cmsUsage":"No","teamSize":"6-20","language":"de"}`, 3},
+	}
+	for _, seed := range seeds {
+		submitted := submitTestSurvey(t, seed.body)
+		createdAt := time.Date(2026, 3, seed.day, 12, 0, 0, 0, time.UTC)
+		if _, err := db.Exec(`UPDATE survey_responses SET created_at = ? WHERE id = ?`, createdAt, submitted.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetSurveyResultsFilters(t *testing.T) {
+	seedSegmentResponses(t)
+
+	tests := map[string]int{
+		"/results?role=Developer":                2,
+		"/results?role=Developer,Designer":       3,
+		"/results?language=de&teamSize=6-20":     2,
+		"/results?betaInterest=true":             1,
+		"/results?from=2026-03-02&to=2026-03-02": 1,
+		"/results?from=2026-03-02T12:00:00Z":     2,
+		"/results?role=Developer&cmsUsage=No":    1,
+		"/results?role=Manager":                  0,
+	}
+	for target, want := range tests {
+		recorder := callHandler(getSurveyResults, "GET", target, nil)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s returned %d: %s", target, recorder.Code, recorder.Body.String())
+			continue
+		}
+		var responses []SurveyResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &responses); err != nil {
+			t.Fatal(err)
+		}
+		if len(responses) != want {
+			t.Errorf("%s returned %d responses, want %d", target, len(responses), want)
+		}
+	}
+
+	if recorder := callHandler(getSurveyResults, "GET", "/results?betaInterest=perhaps", nil); recorder.Code != http.StatusBadRequest {
+		t.Errorf("invalid filter returned %d, want 400", recorder.Code)
+	}
+}
+
+func TestGetMetricsFiltersAndGroups(t *testing.T) {
+	seedSegmentResponses(t)
+
+	recorder := callHandler(getMetrics, "GET", "/metrics?language=de&groupBy=role", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("getMetrics returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var metrics Metrics
+	if err := json.Unmarshal(recorder.Body.Bytes(), &metrics); err != nil {
+		t.Fatal(err)
+	}
+	if metrics.TotalResponses != 2 {
+		t.Errorf("TotalResponses = %d, want 2", metrics.TotalResponses)
+	}
+	if len(metrics.Groups) != 2 || metrics.Groups["Developer"].TotalResponses != 1 || metrics.Groups["Designer"].TotalResponses != 1 {
+		t.Errorf("groups = %+v, want one de response each for Developer and Designer", metrics.Groups)
+	}
+
+	// A filter that matches nothing still returns empty metrics
+	recorder = callHandler(getMetrics, "GET", "/metrics?role=Manager", nil)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("empty segment returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder := callHandler(getMetrics, "GET", "/metrics?groupBy=email", nil); recorder.Code != http.StatusBadRequest {
+		t.Errorf("unknown groupBy returned %d, want 400", recorder.Code)
+	}
+}
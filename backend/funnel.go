@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var sessionIDRegex = regexp.MustCompile(`^[a-zA-Z0-9-]{8,64}$`)
+
+// ProgressEvent is sent by the survey form whenever a respondent enters or
+// completes a step, before the final submission exists
+type ProgressEvent struct {
+	SessionID string `json:"sessionId"`
+	Step      int    `json:"step"`
+	Event     string `json:"event"`
+	Role      string `json:"role,omitempty"`
+}
+
+type FunnelStep struct {
+	Step           int            `json:"step"`
+	Reached        int            `json:"reached"`
+	Completed      int            `json:"completed"`
+	CompletionRate float64        `json:"completionRate"`
+	MedianSeconds  float64        `json:"medianSeconds"`
+	RateOverTime   []FunnelPeriod `json:"rateOverTime"`
+}
+
+type FunnelPeriod struct {
+	Period         string  `json:"period"`
+	Reached        int     `json:"reached"`
+	Completed      int     `json:"completed"`
+	CompletionRate float64 `json:"completionRate"`
+}
+
+type FunnelReport struct {
+	Sessions int          `json:"sessions"`
+	Interval string       `json:"interval"`
+	Steps    []FunnelStep `json:"steps"`
+}
+
+func createProgressTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS survey_progress (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		step INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		role TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_survey_progress_session
+		ON survey_progress (session_id, step)`)
+	return err
+}
+
+func validateProgressEvent(e *ProgressEvent) error {
+	if !sessionIDRegex.MatchString(e.SessionID) {
+		return fmt.Errorf("valid session id is required")
+	}
+	if e.Step < 0 || e.Step >= surveyStepCount {
+		return fmt.Errorf("step must be between 0 and %d", surveyStepCount-1)
+	}
+	if e.Event != "enter" && e.Event != "complete" {
+		return fmt.Errorf("event must be 'enter' or 'complete'")
+	}
+	return nil
+}
+
+func recordProgress(c *gin.Context) {
+	var event ProgressEvent
+	if err := c.BindJSON(&event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateProgressEvent(&event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := db.Exec(`INSERT INTO survey_progress (session_id, step, event, role, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		event.SessionID, event.Step, event.Event, event.Role, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Progress recorded"})
+}
+
+// parseTimeParam accepts either a plain date, taken as midnight in loc, or a
+// full RFC 3339 timestamp
+func parseTimeParam(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or RFC 3339", value)
+	}
+	return t, nil
+}
+
+// periodKey buckets a timestamp into the day, ISO week or month it falls in
+func periodKey(t time.Time, interval string) string {
+	switch interval {
+	case "week":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "month":
+		return t.Format("2006-01")
+	default:
+		return t.Format("2006-01-02")
+	}
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func ratio(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
+
+func getFunnel(c *gin.Context) {
+	interval := c.DefaultQuery("interval", "day")
+	if interval != "day" && interval != "week" && interval != "month" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be day, week or month"})
+		return
+	}
+
+	// from and to select sessions by when they started, so a session that
+	// crosses the boundary is counted with all of its events
+	var window []string
+	var args []interface{}
+	if from := c.Query("from"); from != "" {
+		t, err := parseTimeParam(from, time.UTC)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		window = append(window, "MIN(julianday(created_at)) >= julianday(?)")
+		args = append(args, t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseEndParam(to, time.UTC)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		window = append(window, "MIN(julianday(created_at)) < julianday(?)")
+		args = append(args, t)
+	}
+
+	var where []string
+	if len(window) > 0 {
+		where = append(where, "session_id IN (SELECT session_id FROM survey_progress GROUP BY session_id HAVING "+
+			strings.Join(window, " AND ")+")")
+	}
+	if role := c.Query("role"); role != "" {
+		// Role is only known once the respondent has answered it, so filter
+		// whole sessions rather than individual events
+		where = append(where, "session_id IN (SELECT session_id FROM survey_progress WHERE role = ?)")
+		args = append(args, role)
+	}
+
+	query := `SELECT session_id, step,
+			MIN(CASE WHEN event = 'enter' THEN strftime('%s', created_at) END),
+			MIN(CASE WHEN event = 'complete' THEN strftime('%s', created_at) END)
+		FROM survey_progress`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " GROUP BY session_id, step"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	steps := make([]FunnelStep, surveyStepCount)
+	durations := make([][]float64, surveyStepCount)
+	periods := make([]map[string]*FunnelPeriod, surveyStepCount)
+	for i := range steps {
+		steps[i].Step = i
+		periods[i] = make(map[string]*FunnelPeriod)
+	}
+	sessions := make(map[string]bool)
+
+	for rows.Next() {
+		var sessionID string
+		var step int
+		var entered, completed *int64
+		if err := rows.Scan(&sessionID, &step, &entered, &completed); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if step < 0 || step >= surveyStepCount {
+			continue
+		}
+		sessions[sessionID] = true
+
+		// A completion implies the step was reached even if the enter event was lost
+		start := entered
+		if start == nil {
+			start = completed
+		}
+		if start == nil {
+			continue
+		}
+
+		key := periodKey(time.Unix(*start, 0).UTC(), interval)
+		period, ok := periods[step][key]
+		if !ok {
+			period = &FunnelPeriod{Period: key}
+			periods[step][key] = period
+		}
+
+		steps[step].Reached++
+		period.Reached++
+		if completed != nil {
+			steps[step].Completed++
+			period.Completed++
+			if entered != nil && *completed >= *entered {
+				durations[step] = append(durations[step], float64(*completed-*entered))
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	for i := range steps {
+		steps[i].CompletionRate = roundTo(ratio(steps[i].Completed, steps[i].Reached), 4)
+		steps[i].MedianSeconds = median(durations[i])
+		steps[i].RateOverTime = make([]FunnelPeriod, 0, len(periods[i]))
+		for _, period := range periods[i] {
+			period.CompletionRate = roundTo(ratio(period.Completed, period.Reached), 4)
+			steps[i].RateOverTime = append(steps[i].RateOverTime, *period)
+		}
+		sort.Slice(steps[i].RateOverTime, func(a, b int) bool {
+			return steps[i].RateOverTime[a].Period < steps[i].RateOverTime[b].Period
+		})
+	}
+
+	c.JSON(http.StatusOK, FunnelReport{
+		Sessions: len(sessions),
+		Interval: interval,
+		Steps:    steps,
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSurveyStepCount(t *testing.T) {
+	// The form shows five questions per step
+	if want := (len(surveyQuestions) + 4) / 5; surveyStepCount != want {
+		t.Errorf("surveyStepCount = %d, want %d for %d questions", surveyStepCount, want, len(surveyQuestions))
+	}
+}
+
+func TestValidateProgressEvent(t *testing.T) {
+	const session = "0b5c7f0e-3a57-4c1e-9d2f-6f0a1e2b3c4d"
+	tests := []struct {
+		name    string
+		event   ProgressEvent
+		wantErr bool
+	}{
+		{"first step", ProgressEvent{SessionID: session, Step: 0, Event: "enter"}, false},
+		{"last step", ProgressEvent{SessionID: session, Step: surveyStepCount - 1, Event: "complete"}, false},
+		{"past the last step", ProgressEvent{SessionID: session, Step: surveyStepCount, Event: "enter"}, true},
+		{"negative step", ProgressEvent{SessionID: session, Step: -1, Event: "enter"}, true},
+		{"short session", ProgressEvent{SessionID: "abc", Step: 0, Event: "enter"}, true},
+		{"unknown event", ProgressEvent{SessionID: session, Step: 0, Event: "leave"}, true},
+	}
+	for _, tt := range tests {
+		err := validateProgressEvent(&tt.event)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateProgressEvent() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func insertProgress(t *testing.T, session string, step int, event, role string, at time.Time) {
+	t.Helper()
+	_, err := db.Exec(`INSERT INTO survey_progress (session_id, step, event, role, created_at) VALUES (?, ?, ?, ?, ?)`,
+		session, step, event, role, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetFunnel(t *testing.T) {
+	openTestDB(t)
+	start := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	// Two sessions finish step 0 in 30 and 90 seconds; one leaves on step 1
+	insertProgress(t, "session-one", 0, "enter", "", start)
+	insertProgress(t, "session-one", 0, "complete", "developer", start.Add(30*time.Second))
+	insertProgress(t, "session-one", 1, "enter", "developer", start.Add(31*time.Second))
+	insertProgress(t, "session-one", 1, "complete", "developer", start.Add(2*time.Minute))
+	insertProgress(t, "session-two", 0, "enter", "", start)
+	insertProgress(t, "session-two", 0, "complete", "designer", start.Add(90*time.Second))
+	insertProgress(t, "session-two", 1, "enter", "designer", start.Add(91*time.Second))
+	insertProgress(t, "session-three", 0, "enter", "", start)
+
+	recorder := callHandler(getFunnel, "GET", "/metrics/funnel", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("getFunnel returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var report FunnelReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Sessions != 3 || len(report.Steps) != surveyStepCount {
+		t.Fatalf("got %d sessions and %d steps", report.Sessions, len(report.Steps))
+	}
+	first, second := report.Steps[0], report.Steps[1]
+	if first.Reached != 3 || first.Completed != 2 || first.CompletionRate != 0.6667 || first.MedianSeconds != 60 {
+		t.Errorf("step 0 = %+v", first)
+	}
+	if second.Reached != 2 || second.Completed != 1 || second.CompletionRate != 0.5 {
+		t.Errorf("step 1 = %+v", second)
+	}
+	if len(first.RateOverTime) != 1 || first.RateOverTime[0].Period != "2026-03-02" {
+		t.Errorf("step 0 rate over time = %+v", first.RateOverTime)
+	}
+
+	// Filtering by role keeps whole sessions, including events sent before
+	// the role was answered
+	recorder = callHandler(getFunnel, "GET", "/metrics/funnel?role=designer", nil)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Sessions != 1 || report.Steps[0].Reached != 1 || report.Steps[0].MedianSeconds != 90 {
+		t.Errorf("designer funnel = %+v", report)
+	}
+
+	// A session that starts before the window closes is counted whole, and
+	// one that only finishes inside it is left out
+	midnight := time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC)
+	insertProgress(t, "session-late", 0, "enter", "", midnight.Add(-time.Minute))
+	insertProgress(t, "session-late", 0, "complete", "developer", midnight.Add(time.Minute))
+	recorder = callHandler(getFunnel, "GET", "/metrics/funnel?from=2026-03-02&to=2026-03-02", nil)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Sessions != 4 || report.Steps[0].Reached != 4 || report.Steps[0].Completed != 3 {
+		t.Errorf("funnel for 2026-03-02 = %+v", report)
+	}
+	recorder = callHandler(getFunnel, "GET", "/metrics/funnel?from=2026-03-03", nil)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Sessions != 0 {
+		t.Errorf("funnel from 2026-03-03 has %d sessions, want none", report.Sessions)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const maxIdempotencyKeyLength = 255
+
+var (
+	errIdempotencyConflict = errors.New("idempotency key was already used with a different payload")
+	errIdempotentDeleted   = errors.New("the response stored under this idempotency key has been deleted")
+)
+
+// idempotencyTTL is how long a key is remembered after the first submission
+func idempotencyTTL() time.Duration {
+	hours, err := strconv.Atoi(getEnvWithFallback("IDEMPOTENCY_KEY_TTL_HOURS", "24"))
+	if err != nil || hours <= 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// Keys only remember which response a submission created; replays are built
+// from the stored response so answers and edit tokens are not kept twice
+func createIdempotencyTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS survey_idempotency_keys (
+		key TEXT PRIMARY KEY,
+		response_id TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	if err := ensureColumn("survey_idempotency_keys", "status", "INTEGER NOT NULL DEFAULT 201"); err != nil {
+		return err
+	}
+	// Earlier versions stored the whole 201 body, email address included
+	var stored int
+	err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('survey_idempotency_keys') WHERE name = 'response_body'`).Scan(&stored)
+	if err != nil || stored == 0 {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE survey_idempotency_keys DROP COLUMN response_body`)
+	return err
+}
+
+// submissionIdempotencyKey reads the Idempotency-Key header, falling back to a
+// client-generated response id, which then becomes the response's id. With
+// the header any id in the body is ignored and the server assigns one.
+func submissionIdempotencyKey(c *gin.Context, survey *SurveyResponse) (string, error) {
+	if key := strings.TrimSpace(c.GetHeader("Idempotency-Key")); key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			return "", fmt.Errorf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength)
+		}
+		survey.ID = ""
+		return key, nil
+	}
+	if survey.ID == "" {
+		return "", nil
+	}
+	id, err := uuid.Parse(survey.ID)
+	if err != nil {
+		return "", fmt.Errorf("id must be a UUID")
+	}
+	survey.ID = id.String()
+	return "id:" + survey.ID, nil
+}
+
+// submissionHash fingerprints what a submission asks to store. The challenge
+// fields are left out since a retry may solve a fresh challenge.
+func submissionHash(submission *surveySubmission, pending []pendingAttachment) (string, error) {
+	files := make([]string, len(pending))
+	for i, attachment := range pending {
+		sum := sha256.Sum256(attachment.data)
+		files[i] = attachment.filename + ":" + hex.EncodeToString(sum[:])
+	}
+	payload, err := json.Marshal(struct {
+		Survey          SurveyResponse `json:"survey"`
+		RequestEditLink bool           `json:"requestEditLink"`
+		InviteCode      string         `json:"inviteCode"`
+		SessionID       string         `json:"sessionId"`
+		Attachments     []string       `json:"attachments"`
+	}{submission.SurveyResponse, submission.RequestEditLink, submission.InviteCode, submission.SessionID, files})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// findIdempotentResult returns the response id and status stored for a key
+// seen before with the same payload, "" for a new key, or errIdempotencyConflict.
+func findIdempotentResult(q queryer, key, hash string, now time.Time) (string, int, error) {
+	var storedHash, responseID string
+	var status int
+	err := q.QueryRow(`SELECT request_hash, response_id, status FROM survey_idempotency_keys
+		WHERE key = ? AND julianday(created_at) >= julianday(?)`,
+		key, now.Add(-idempotencyTTL())).Scan(&storedHash, &responseID, &status)
+	if err == sql.ErrNoRows {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if storedHash != hash {
+		return "", 0, errIdempotencyConflict
+	}
+	return responseID, status, nil
+}
+
+// idempotentResult rebuilds the 201 body of a stored submission. A requested
+// edit link is issued afresh rather than kept from the first answer.
+func idempotentResult(responseID string, editLink bool) (surveySubmissionResult, error) {
+	survey, err := getSurveyResponse(responseID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return surveySubmissionResult{}, errIdempotentDeleted
+	}
+	if err != nil {
+		return surveySubmissionResult{}, err
+	}
+	result := surveySubmissionResult{SurveyResponse: survey}
+	if result.Attachments, err = responseAttachments(db, responseID); err != nil {
+		return result, err
+	}
+	if editLink {
+		token, expiresAt, err := issueEditToken(responseID)
+		if err != nil {
+			return result, err
+		}
+		result.EditToken = token
+		result.EditTokenExpiresAt = &expiresAt
+	}
+	return result, nil
+}
+
+// replayIdempotentResult answers a retried submission and reports whether it
+// did; new keys are left for the caller to store
+func replayIdempotentResult(c *gin.Context, key, hash string, editLink bool, now time.Time) bool {
+	responseID, status, err := findIdempotentResult(db, key, hash, now)
+	if errors.Is(err, errIdempotencyConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return true
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	if responseID == "" || status == 0 {
+		return false
+	}
+	result, err := idempotentResult(responseID, editLink)
+	if errors.Is(err, errIdempotentDeleted) {
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		return true
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.JSON(status, result)
+	return true
+}
+
+// claimIdempotencyKey reserves key for the submission being stored, forgetting
+// expired keys first. It reports false when another request holds the key.
+func claimIdempotencyKey(tx *sql.Tx, key, hash, responseID string, now time.Time) (bool, error) {
+	if _, err := tx.Exec(`DELETE FROM survey_idempotency_keys WHERE julianday(created_at) < julianday(?)`,
+		now.Add(-idempotencyTTL())); err != nil {
+		return false, err
+	}
+	result, err := tx.Exec(`INSERT OR IGNORE INTO survey_idempotency_keys (key, response_id, request_hash, status, created_at)
+		VALUES (?, ?, ?, 0, ?)`, key, responseID, hash, now)
+	if err != nil {
+		return false, err
+	}
+	claimed, _ := result.RowsAffected()
+	return claimed > 0, nil
+}
+
+// saveIdempotentResult records the status retries are answered with
+func saveIdempotentResult(tx *sql.Tx, key string, status int) error {
+	_, err := tx.Exec(`UPDATE survey_idempotency_keys SET status = ? WHERE key = ?`, status, key)
+	return err
+}
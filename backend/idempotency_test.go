@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// submitWithKey posts a submission carrying an Idempotency-Key header
+func submitWithKey(key, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/survey", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		c.Request.Header.Set("Idempotency-Key", key)
+	}
+	submitSurvey(c)
+	return recorder
+}
+
+func countResponses(t *testing.T) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM survey_responses`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestSubmissionIdempotencyKey(t *testing.T) {
+	tests := []struct {
+		name, header, id string
+		wantKey, wantID  string
+		wantErr          bool
+	}{
+		{"none", "", "", "", "", false},
+		{"header wins over id", " key-1 ", "2f1c8a4e-8d1b-4a8e-9a57-3f0f7a1f9b10", "key-1", "", false},
+		{"client id", "", "2F1C8A4E-8D1B-4A8E-9A57-3F0F7A1F9B10",
+			"id:2f1c8a4e-8d1b-4a8e-9a57-3f0f7a1f9b10", "2f1c8a4e-8d1b-4a8e-9a57-3f0f7a1f9b10", false},
+		{"id not a uuid", "", "response-1", "", "", true},
+		{"header too long", strings.Repeat("k", maxIdempotencyKeyLength+1), "", "", "", true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/survey", nil)
+		c.Request.Header.Set("Idempotency-Key", tt.header)
+		survey := SurveyResponse{ID: tt.id}
+		key, err := submissionIdempotencyKey(c, &survey)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (key != tt.wantKey || survey.ID != tt.wantID) {
+			t.Errorf("%s: key %q id %q, want %q and %q", tt.name, key, survey.ID, tt.wantKey, tt.wantID)
+		}
+	}
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	openTestDB(t)
+	body := `{"role":"developer","email":"ada@example.com"}`
+
+	first := submitWithKey("retry-1", body)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first submission returned %d: %s", first.Code, first.Body.String())
+	}
+	retry := submitWithKey("retry-1", body)
+	if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry returned %d, replayed %q", retry.Code, retry.Header().Get("Idempotent-Replayed"))
+	}
+	var original, replayed surveySubmissionResult
+	if err := json.Unmarshal(first.Body.Bytes(), &original); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(retry.Body.Bytes(), &replayed); err != nil {
+		t.Fatal(err)
+	}
+	if replayed.ID != original.ID || replayed.Role != original.Role || replayed.Email != original.Email {
+		t.Errorf("retry body %s, want %s", retry.Body.String(), first.Body.String())
+	}
+	if got := countResponses(t); got != 1 {
+		t.Errorf("stored %d responses, want 1", got)
+	}
+
+	if conflict := submitWithKey("retry-1", `{"role":"designer"}`); conflict.Code != http.StatusConflict {
+		t.Errorf("reused key with another payload returned %d, want 409", conflict.Code)
+	}
+
+	// Expired keys are forgotten, so the same key stores a new response
+	if _, err := db.Exec(`UPDATE survey_idempotency_keys SET created_at = ?`, time.Now().Add(-25*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if late := submitWithKey("retry-1", body); late.Code != http.StatusCreated || late.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("submission after expiry returned %d, replayed %q", late.Code, late.Header().Get("Idempotent-Replayed"))
+	}
+	if got := countResponses(t); got != 2 {
+		t.Errorf("stored %d responses after expiry, want 2", got)
+	}
+}
+
+func TestClientGeneratedID(t *testing.T) {
+	openTestDB(t)
+	id := "2f1c8a4e-8d1b-4a8e-9a57-3f0f7a1f9b10"
+	body := `{"id":"` + id + `","role":"developer"}`
+
+	result := submitTestSurvey(t, body)
+	if result.ID != id {
+		t.Errorf("stored id %q, want the client's %q", result.ID, id)
+	}
+	if retry := submitWithKey("", body); retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry with the same id returned %d", retry.Code)
+	}
+
+	// Once the key expires the id itself still cannot be stored twice
+	if _, err := db.Exec(`DELETE FROM survey_idempotency_keys`); err != nil {
+		t.Fatal(err)
+	}
+	if duplicate := submitWithKey("", body); duplicate.Code != http.StatusConflict {
+		t.Errorf("duplicate id returned %d, want 409", duplicate.Code)
+	}
+	if got := countResponses(t); got != 1 {
+		t.Errorf("stored %d responses, want 1", got)
+	}
+}
+
+func TestIdempotentReplayAfterDelete(t *testing.T) {
+	openTestDB(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	body := `{"role":"developer","email":"ada@example.com","requestEditLink":true}`
+	first := submitWithKey("retry-2", body)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first submission returned %d: %s", first.Code, first.Body.String())
+	}
+	var result surveySubmissionResult
+	if err := json.Unmarshal(first.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	// The replay is rebuilt from the response and gets its own edit link
+	retry := submitWithKey("retry-2", body)
+	var replayed surveySubmissionResult
+	if err := json.Unmarshal(retry.Body.Bytes(), &replayed); err != nil {
+		t.Fatal(err)
+	}
+	if replayed.ID != result.ID || replayed.EditToken == "" {
+		t.Errorf("replay = %+v, want response %s with an edit token", replayed, result.ID)
+	}
+
+	deleteTestResponse(t, result.ID)
+	if gone := submitWithKey("retry-2", body); gone.Code != http.StatusGone || strings.Contains(gone.Body.String(), "ada@") {
+		t.Errorf("retry after delete returned %d: %s, want 410 without the answers", gone.Code, gone.Body.String())
+	}
+	if got := countResponses(t); got != 0 {
+		t.Errorf("stored %d responses, want the deleted one not re-created", got)
+	}
+}
+
+func TestIdempotencyTableDropsStoredBodies(t *testing.T) {
+	openTestDB(t)
+	// The table as earlier versions created it, with a stored 201 body
+	for _, query := range []string{
+		`DROP TABLE survey_idempotency_keys`,
+		`CREATE TABLE survey_idempotency_keys (key TEXT PRIMARY KEY, response_id TEXT NOT NULL,
+			request_hash TEXT NOT NULL, response_body JSON NOT NULL, created_at TIMESTAMP NOT NULL)`,
+		`INSERT INTO survey_idempotency_keys VALUES ('old', 'resp-1', 'hash', '{"email":"ada@example.com"}', CURRENT_TIMESTAMP)`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := createIdempotencyTable(); err != nil {
+		t.Fatal(err)
+	}
+
+	responseID, status, err := findIdempotentResult(db, "old", "hash", time.Now())
+	if err != nil || responseID != "resp-1" || status != http.StatusCreated {
+		t.Errorf("migrated key = %q, %d, %v, want resp-1 answered with 201", responseID, status, err)
+	}
+	var columns int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('survey_idempotency_keys') WHERE name = 'response_body'`).Scan(&columns); err != nil {
+		t.Fatal(err)
+	}
+	if columns != 0 {
+		t.Error("response_body column was kept")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxInviteBatch = 1000
+
+var (
+	errInviteRequired = errors.New("an invite code is required to take this survey")
+	errInviteInvalid  = errors.New("invite code is not valid")
+	errInviteUsed     = errors.New("invite code has already been used")
+	errInviteRevoked  = errors.New("invite code has been revoked")
+	errInviteExpired  = errors.New("invite code has expired")
+)
+
+type Invite struct {
+	Code        string     `json:"code"`
+	Email       string     `json:"email,omitempty"`
+	MaxUses     int        `json:"maxUses"`
+	Uses        int        `json:"uses"`
+	Status      string     `json:"status"`
+	ResponseIDs []string   `json:"responseIds"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+}
+
+type InviteRequest struct {
+	Count     int        `json:"count"`
+	Emails    []string   `json:"emails"`
+	MaxUses   int        `json:"maxUses"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	SendEmail bool       `json:"sendEmail"`
+}
+
+func createInvitesTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS survey_invites (
+		code TEXT PRIMARY KEY,
+		email TEXT,
+		max_uses INTEGER NOT NULL DEFAULT 1,
+		uses INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP,
+		revoked_at TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	return ensureColumn("survey_responses", "invite_code", "TEXT")
+}
+
+// inviteOnly reports whether submissions without a valid invite are rejected
+func inviteOnly() bool {
+	return os.Getenv("SURVEY_INVITE_ONLY") == "true"
+}
+
+// generateInviteCode returns a random 10 character code that is easy to read out or type
+func generateInviteCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+	return code[:10], nil
+}
+
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// redeemInvite consumes one use of an invite code and links it to the response
+func redeemInvite(tx *sql.Tx, code, responseID string) error {
+	code = normalizeInviteCode(code)
+	if code == "" {
+		if inviteOnly() {
+			return errInviteRequired
+		}
+		return nil
+	}
+
+	result, err := tx.Exec(`UPDATE survey_invites SET uses = uses + 1
+		WHERE code = ? AND revoked_at IS NULL AND uses < max_uses
+		AND (expires_at IS NULL OR julianday(expires_at) > julianday(?))`,
+		code, time.Now())
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return inviteRejection(tx, code)
+	}
+
+	_, err = tx.Exec(`UPDATE survey_responses SET invite_code = ? WHERE id = ?`, code, responseID)
+	return err
+}
+
+// inviteRejection explains why a code could not be redeemed
+func inviteRejection(tx *sql.Tx, code string) error {
+	var uses, maxUses int
+	var expiresAt, revokedAt *time.Time
+	err := tx.QueryRow(`SELECT uses, max_uses, expires_at, revoked_at FROM survey_invites WHERE code = ?`,
+		code).Scan(&uses, &maxUses, &expiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInviteInvalid
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case revokedAt != nil:
+		return errInviteRevoked
+	case uses >= maxUses:
+		return errInviteUsed
+	case expiresAt != nil && !expiresAt.After(time.Now()):
+		return errInviteExpired
+	}
+	return errInviteInvalid
+}
+
+// isInviteError reports whether err should be shown to the respondent as a 403
+func isInviteError(err error) bool {
+	return errors.Is(err, errInviteRequired) || errors.Is(err, errInviteInvalid) ||
+		errors.Is(err, errInviteUsed) || errors.Is(err, errInviteRevoked) ||
+		errors.Is(err, errInviteExpired)
+}
+
+func inviteStatus(invite *Invite) string {
+	switch {
+	case invite.RevokedAt != nil:
+		return "revoked"
+	case invite.Uses >= invite.MaxUses:
+		return "redeemed"
+	case invite.ExpiresAt != nil && !invite.ExpiresAt.After(time.Now()):
+		return "expired"
+	case invite.Uses > 0:
+		return "partially-redeemed"
+	}
+	return "unused"
+}
+
+func sendInvite(invite Invite) {
+	base := os.Getenv("INVITE_LINK_BASE_URL")
+	if base == "" || invite.Email == "" {
+		return
+	}
+
+	link := fmt.Sprintf("%s?invite=%s", base, url.QueryEscape(invite.Code))
+	body := fmt.Sprintf("You're invited to take the LocalHaven CMS survey.\r\n\r\n"+
+		"Use this link to get started:\r\n\r\n%s\r\n\r\nor enter the access code %s.\r\n",
+		link, invite.Code)
+	if err := sendMail(invite.Email, "Your invitation to the LocalHaven CMS survey", body); err != nil {
+		log.Printf("Failed to send invite %s: %v", invite.Code, err)
+	}
+}
+
+func createInvites(c *gin.Context) {
+	var req InviteRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.MaxUses < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "maxUses must be positive"})
+		return
+	}
+	for _, email := range req.Emails {
+		if !emailRegex.MatchString(email) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid email: %s", email)})
+			return
+		}
+	}
+
+	// One invite per email, plus any additional anonymous codes requested
+	total := len(req.Emails)
+	if req.Count > total {
+		total = req.Count
+	}
+	if total == 0 || total > maxInviteBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("between 1 and %d invites can be created at once", maxInviteBatch)})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	invites := make([]Invite, 0, total)
+	for i := 0; i < total; i++ {
+		code, err := generateInviteCode()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		invite := Invite{
+			Code:        code,
+			MaxUses:     req.MaxUses,
+			ResponseIDs: []string{},
+			CreatedAt:   now,
+			ExpiresAt:   req.ExpiresAt,
+		}
+		if i < len(req.Emails) {
+			invite.Email = req.Emails[i]
+		}
+		invite.Status = inviteStatus(&invite)
+
+		_, err = tx.Exec(`INSERT INTO survey_invites (code, email, max_uses, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			invite.Code, invite.Email, invite.MaxUses, invite.CreatedAt, invite.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		invites = append(invites, invite)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.SendEmail {
+		go func() {
+			for _, invite := range invites {
+				sendInvite(invite)
+			}
+		}()
+	}
+
+	c.JSON(http.StatusCreated, invites)
+}
+
+func getInvites(c *gin.Context) {
+	rows, err := db.Query(`SELECT i.code, COALESCE(i.email, ''), i.max_uses, i.uses,
+			i.created_at, i.expires_at, i.revoked_at, COALESCE(GROUP_CONCAT(r.id), '')
+		FROM survey_invites i
+		LEFT JOIN survey_responses r ON r.invite_code = i.code
+		GROUP BY i.code
+		ORDER BY i.created_at DESC, i.code`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	statusFilter := c.Query("status")
+	invites := []Invite{}
+	for rows.Next() {
+		var invite Invite
+		var responseIDs string
+		if err := rows.Scan(&invite.Code, &invite.Email, &invite.MaxUses, &invite.Uses,
+			&invite.CreatedAt, &invite.ExpiresAt, &invite.RevokedAt, &responseIDs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		invite.Status = inviteStatus(&invite)
+		if statusFilter != "" && invite.Status != statusFilter {
+			continue
+		}
+		invite.ResponseIDs = []string{}
+		if responseIDs != "" {
+			invite.ResponseIDs = strings.Split(responseIDs, ",")
+		}
+		invites = append(invites, invite)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, invites)
+}
+
+// revokeInvite disables an invite that still has unused redemptions
+func revokeInvite(c *gin.Context) {
+	code := normalizeInviteCode(c.Param("code"))
+	result, err := db.Exec(`UPDATE survey_invites SET revoked_at = ?
+		WHERE code = ? AND revoked_at IS NULL AND uses < max_uses`, time.Now(), code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		var exists bool
+		db.QueryRow(`SELECT COUNT(*) > 0 FROM survey_invites WHERE code = ?`, code).Scan(&exists)
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invite not found"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "invite is already fully redeemed or revoked"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked"})
+}
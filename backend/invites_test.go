@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeInviteCode(t *testing.T) {
+	tests := map[string]string{
+		"ABCDEFGHIJ":     "ABCDEFGHIJ",
+		" abcdefghij\n":  "ABCDEFGHIJ",
+		"":               "",
+		"   ":            "",
+		"MixedCase1234 ": "MIXEDCASE1234",
+	}
+	for input, want := range tests {
+		if got := normalizeInviteCode(input); got != want {
+			t.Errorf("normalizeInviteCode(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+// createTestInvites creates invites through the admin handler
+func createTestInvites(t *testing.T, body string) []Invite {
+	t.Helper()
+	recorder := callHandler(createInvites, "POST", "/invites", strings.NewReader(body))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("createInvites returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var invites []Invite
+	if err := json.Unmarshal(recorder.Body.Bytes(), &invites); err != nil {
+		t.Fatal(err)
+	}
+	return invites
+}
+
+func TestInviteOnlySubmissions(t *testing.T) {
+	openTestDB(t)
+	t.Setenv("SURVEY_INVITE_ONLY", "true")
+
+	invites := createTestInvites(t, `{"count":2}`)
+	if len(invites) != 2 {
+		t.Fatalf("created %d invites, want 2", len(invites))
+	}
+	for _, invite := range invites {
+		if len(invite.Code) != 10 || invite.MaxUses != 1 || invite.Status != "unused" {
+			t.Errorf("unexpected invite %+v", invite)
+		}
+	}
+
+	submit := func(code string) int {
+		body := fmt.Sprintf(`{"role":"developer","cmsUsage":"wordpress","inviteCode":%q}`, code)
+		return callHandler(submitSurvey, "POST", "/survey", strings.NewReader(body)).Code
+	}
+
+	if code := submit(""); code != http.StatusForbidden {
+		t.Errorf("submission without invite returned %d, want 403", code)
+	}
+	if code := submit("NOTACODE00"); code != http.StatusForbidden {
+		t.Errorf("submission with unknown invite returned %d, want 403", code)
+	}
+	// Codes are accepted regardless of case and surrounding whitespace
+	if code := submit(" " + strings.ToLower(invites[0].Code) + " "); code != http.StatusCreated {
+		t.Errorf("submission with valid invite returned %d, want 201", code)
+	}
+	if code := submit(invites[0].Code); code != http.StatusForbidden {
+		t.Errorf("submission with used invite returned %d, want 403", code)
+	}
+
+	revoked := callHandler(revokeInvite, "DELETE", "/invites/"+invites[1].Code, nil,
+		gin.Param{Key: "code", Value: invites[1].Code})
+	if revoked.Code != http.StatusOK {
+		t.Fatalf("revokeInvite returned %d: %s", revoked.Code, revoked.Body.String())
+	}
+	if code := submit(invites[1].Code); code != http.StatusForbidden {
+		t.Errorf("submission with revoked invite returned %d, want 403", code)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM survey_responses`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("stored %d responses, want only the one with a valid invite", count)
+	}
+
+	recorder := callHandler(getInvites, "GET", "/invites?status=redeemed", nil)
+	var redeemed []Invite
+	if err := json.Unmarshal(recorder.Body.Bytes(), &redeemed); err != nil {
+		t.Fatal(err)
+	}
+	if len(redeemed) != 1 || redeemed[0].Code != invites[0].Code || len(redeemed[0].ResponseIDs) != 1 {
+		t.Errorf("redeemed invites = %+v, want only the used one with its response", redeemed)
+	}
+}
+
+func TestRevokeInviteErrors(t *testing.T) {
+	openTestDB(t)
+	invites := createTestInvites(t, `{"count":1}`)
+
+	missing := callHandler(revokeInvite, "DELETE", "/invites/MISSING", nil, gin.Param{Key: "code", Value: "MISSING"})
+	if missing.Code != http.StatusNotFound {
+		t.Errorf("revoking an unknown invite returned %d, want 404", missing.Code)
+	}
+
+	param := gin.Param{Key: "code", Value: invites[0].Code}
+	callHandler(revokeInvite, "DELETE", "/invites/"+invites[0].Code, nil, param)
+	again := callHandler(revokeInvite, "DELETE", "/invites/"+invites[0].Code, nil, param)
+	if again.Code != http.StatusConflict {
+		t.Errorf("revoking twice returned %d, want 409", again.Code)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultKeywordLimit = 20
+	maxKeywordLimit     = 100
+	keywordExamples     = 3
+	excerptLength       = 200
+)
+
+// KeywordExample is a response that mentions a term
+type KeywordExample struct {
+	ResponseID string `json:"responseId"`
+	Excerpt    string `json:"excerpt"`
+}
+
+// KeywordCount is a stemmed term or bigram. Term is the spelling respondents
+// used most often; Count is the total number of mentions and Responses the
+// number of distinct responses mentioning it.
+type KeywordCount struct {
+	Term      string           `json:"term"`
+	Stem      string           `json:"stem"`
+	Count     int              `json:"count"`
+	Responses int              `json:"responses"`
+	Examples  []KeywordExample `json:"examples"`
+}
+
+type FieldKeywords struct {
+	Field     string         `json:"field"`
+	Responses int            `json:"responses"`
+	Terms     []KeywordCount `json:"terms"`
+	Bigrams   []KeywordCount `json:"bigrams"`
+}
+
+// keywordTally accumulates the counts for one stem or stem pair
+type keywordTally struct {
+	count     int
+	responses int
+	lastID    string
+	spellings map[string]int
+	examples  []KeywordExample
+}
+
+type keywordCounter map[string]*keywordTally
+
+func (k keywordCounter) add(stemmed, spelling, responseID, text string) {
+	tally, ok := k[stemmed]
+	if !ok {
+		tally = &keywordTally{spellings: make(map[string]int)}
+		k[stemmed] = tally
+	}
+	tally.count++
+	tally.spellings[spelling]++
+	if tally.lastID != responseID {
+		tally.lastID = responseID
+		tally.responses++
+		if len(tally.examples) < keywordExamples {
+			tally.examples = append(tally.examples, KeywordExample{ResponseID: responseID, Excerpt: excerpt(text)})
+		}
+	}
+}
+
+// top returns the most mentioned entries, ranked by the number of responses
+// and then total mentions. Entries only one response mentions are left out.
+func (k keywordCounter) top(limit int) []KeywordCount {
+	counts := []KeywordCount{}
+	for stemmed, tally := range k {
+		if tally.responses < 2 {
+			continue
+		}
+		counts = append(counts, KeywordCount{
+			Term:      mostFrequent(tally.spellings),
+			Stem:      stemmed,
+			Count:     tally.count,
+			Responses: tally.responses,
+			Examples:  tally.examples,
+		})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Responses != counts[j].Responses {
+			return counts[i].Responses > counts[j].Responses
+		}
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Stem < counts[j].Stem
+	})
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
+
+func mostFrequent(spellings map[string]int) string {
+	var best string
+	for spelling, count := range spellings {
+		if count > spellings[best] || (count == spellings[best] && spelling < best) {
+			best = spelling
+		}
+	}
+	return best
+}
+
+// excerpt shortens an answer for display without cutting a word in half
+func excerpt(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= excerptLength {
+		return text
+	}
+	cut := string(runes[:excerptLength])
+	if i := strings.LastIndex(cut, " "); i > excerptLength/2 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+// countKeywords adds the terms and bigrams of one answer to the counters.
+// Stop words are skipped and also break bigrams, so "merge conflicts" is
+// counted but "merge the conflicts" is not.
+func countKeywords(terms, bigrams keywordCounter, responseID, text string) {
+	for _, phrase := range tokenizeText(text) {
+		var prevStem, prevWord string
+		for _, word := range phrase {
+			if stopWords[word] {
+				prevStem = ""
+				continue
+			}
+			stemmed := stem(word)
+			terms.add(stemmed, word, responseID, text)
+			if prevStem != "" {
+				bigrams.add(prevStem+" "+stemmed, prevWord+" "+word, responseID, text)
+			}
+			prevStem, prevWord = stemmed, word
+		}
+	}
+}
+
+// getKeywords reports the most common terms and bigrams in the free-text
+// answers. ?fields= limits the analysis to some fields and ?limit= sets how
+// many terms are returned per field; the usual segment filters apply.
+func getKeywords(c *gin.Context) {
+	fields := freeTextFields
+	if raw := c.Query("fields"); raw != "" {
+		fields = nil
+		for _, key := range strings.Split(raw, ",") {
+			field, ok := lookupFreeTextField(strings.TrimSpace(key))
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown free-text field %q", key)})
+				return
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	limit := defaultKeywordLimit
+	if raw := c.Query("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxKeywordLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxKeywordLimit)})
+			return
+		}
+		limit = value
+	}
+
+	filter, err := parseResponseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		columns[i] = "COALESCE(" + field.column + ", '')"
+	}
+	rows, err := db.Query(`SELECT id, `+strings.Join(columns, ", ")+` FROM survey_responses`+
+		filter.where()+` ORDER BY created_at DESC`, filter.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	results := make([]FieldKeywords, len(fields))
+	terms := make([]keywordCounter, len(fields))
+	bigrams := make([]keywordCounter, len(fields))
+	for i, field := range fields {
+		results[i].Field = field.key
+		terms[i] = make(keywordCounter)
+		bigrams[i] = make(keywordCounter)
+	}
+
+	total := 0
+	texts := make([]string, len(fields))
+	dest := make([]interface{}, len(fields)+1)
+	var id string
+	dest[0] = &id
+	for i := range texts {
+		dest[i+1] = &texts[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		total++
+		for i, text := range texts {
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			results[i].Responses++
+			countKeywords(terms[i], bigrams[i], id, text)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	for i := range results {
+		results[i].Terms = terms[i].top(limit)
+		results[i].Bigrams = bigrams[i].top(limit)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"totalResponses": total,
+		"fields":         results,
+	})
+}
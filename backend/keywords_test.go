@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeText(t *testing.T) {
+	got := tokenizeText("Merge conflicts, every day! I'd rather use Git-based 2 CMSes (v2).")
+	want := [][]string{
+		{"merge", "conflicts"},
+		{"every", "day"},
+		{"i'd", "rather", "use", "git-based", "cmses"},
+		{"v2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenizeText() = %q, want %q", got, want)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	if got := excerpt("  short\n answer "); got != "short answer" {
+		t.Errorf("excerpt(short) = %q", got)
+	}
+	long := strings.Repeat("word ", 60)
+	got := excerpt(long)
+	if !strings.HasSuffix(got, "word…") || len([]rune(got)) > excerptLength+1 {
+		t.Errorf("excerpt(long) = %q", got)
+	}
+}
+
+func TestCountKeywords(t *testing.T) {
+	terms, bigrams := make(keywordCounter), make(keywordCounter)
+	countKeywords(terms, bigrams, "a", "Merge conflicts again. Merging is painful")
+	countKeywords(terms, bigrams, "b", "merge the conflicts")
+	countKeywords(terms, bigrams, "c", "Merge conflicts everywhere")
+
+	top := terms.top(10)
+	if len(top) != 2 || top[0].Stem != stem("merge") || top[0].Responses != 3 || top[0].Count != 4 || top[0].Term != "merge" {
+		t.Fatalf("terms = %+v", top)
+	}
+	if top[1].Stem != stem("conflicts") || top[1].Responses != 3 || len(top[1].Examples) != keywordExamples {
+		t.Errorf("second term = %+v", top[1])
+	}
+
+	// "merge the conflicts" is broken by a stop word and does not count
+	pairs := bigrams.top(10)
+	if len(pairs) != 1 || pairs[0].Term != "merge conflicts" || pairs[0].Responses != 2 {
+		t.Errorf("bigrams = %+v", pairs)
+	}
+}
+
+func TestGetKeywords(t *testing.T) {
+	openTestDB(t)
+	for _, body := range []string{
+		`{"role":"Developer","biggestFrustrations":"Slow page builds and slow previews","specificProblems":"Offline editing"}`,
+		`{"role":"Developer","biggestFrustrations":"Builds are slow"}`,
+		`{"role":"Designer","biggestFrustrations":"Slow builds, no offline mode"}`,
+	} {
+		submitTestSurvey(t, body)
+	}
+
+	recorder := callHandler(getKeywords, "GET", "/analysis/keywords?fields=biggestFrustrations&role=Developer,Designer&limit=1", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("getKeywords returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var result struct {
+		TotalResponses int             `json:"totalResponses"`
+		Fields         []FieldKeywords `json:"fields"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.TotalResponses != 3 || len(result.Fields) != 1 || result.Fields[0].Responses != 3 {
+		t.Fatalf("result = %+v", result)
+	}
+	terms := result.Fields[0].Terms
+	if len(terms) != 1 || terms[0].Term != "slow" || terms[0].Count != 4 || terms[0].Responses != 3 {
+		t.Errorf("terms = %+v, want slow mentioned 4 times in 3 responses", terms)
+	}
+
+	for _, target := range []string{"/analysis/keywords?fields=email", "/analysis/keywords?limit=0"} {
+		if recorder := callHandler(getKeywords, "GET", target, nil); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, want 400", target, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// mailConfigured reports whether SMTP settings are available for outgoing mail
+func mailConfigured() bool {
+	return os.Getenv("SMTP_HOST") != "" && os.Getenv("SMTP_FROM") != ""
+}
+
+// sendMail delivers a plain-text message through the configured SMTP server
+func sendMail(to, subject, body string) error {
+	if !mailConfigured() {
+		return fmt.Errorf("SMTP is not configured")
+	}
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+
+	host := os.Getenv("SMTP_HOST")
+	addr := host + ":" + getEnvWithFallback("SMTP_PORT", "587")
+	from := os.Getenv("SMTP_FROM")
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	msg := "From: " + from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+
+	return smtp.SendMail(addr, auth, from, []string{to}, []byte(msg))
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
@@ -53,6 +54,7 @@ type SurveyResponse struct {
 	OfflineWorkarounds            string    `json:"offlineWorkarounds"`
 	CurrentChangeConflictHandling string    `json:"currentChangeConflictHandling"`
 	VersionControlChallenges      string    `json:"versionControlChallenges"`
+	Language                      string    `json:"language"`
 }
 
 type Features struct {
@@ -74,14 +76,6 @@ var (
 	mu         sync.Mutex
 )
 
-// Add caching for survey results
-var (
-	resultsCache   []SurveyResponse
-	cacheTimestamp time.Time
-	cacheDuration  = 5 * time.Minute
-	cacheMutex     sync.RWMutex
-)
-
 func init() {
 	// Only try to load .env file in development
 	if os.Getenv("ENVIRONMENT") != "production" {
@@ -112,18 +106,99 @@ func initDB() error {
 
 	if err != nil || !tableExists {
 		// Create new table if it doesn't exist
-		return createInitialTable()
+		if err := createInitialTable(); err != nil {
+			return err
+		}
+	} else {
+		// Check if we need to migrate
+		var columnCount int
+		err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('survey_responses') 
+			WHERE name IN ('biggest_frustrations', 'specific_problems')`).Scan(&columnCount)
+
+		if err != nil || columnCount < 2 {
+			if err := migrateTable(); err != nil {
+				return err
+			}
+		}
 	}
 
-	// Check if we need to migrate
-	var columnCount int
-	err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('survey_responses') 
-		WHERE name IN ('biggest_frustrations', 'specific_problems')`).Scan(&columnCount)
+	return initSupportTables()
+}
 
-	if err != nil || columnCount < 2 {
-		return migrateTable()
+// ensureColumn adds a column to an existing table if it is missing
+func ensureColumn(table, column, definition string) error {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
 	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
 
+// initSupportTables creates the tables used alongside survey_responses
+func initSupportTables() error {
+	if err := ensureColumn("survey_responses", "updated_at", "TIMESTAMP"); err != nil {
+		return fmt.Errorf("error adding updated_at column: %v", err)
+	}
+	if err := ensureColumn("survey_responses", "language", "TEXT NOT NULL DEFAULT 'en'"); err != nil {
+		return fmt.Errorf("error adding language column: %v", err)
+	}
+	if err := createRevisionsTable(); err != nil {
+		return fmt.Errorf("error creating revisions table: %v", err)
+	}
+	if err := createInvitesTable(); err != nil {
+		return fmt.Errorf("error creating invites table: %v", err)
+	}
+	if err := createScheduleTables(); err != nil {
+		return fmt.Errorf("error creating schedule tables: %v", err)
+	}
+	if err := createAttachmentsTable(); err != nil {
+		return fmt.Errorf("error creating attachments table: %v", err)
+	}
+	if err := createProgressTable(); err != nil {
+		return fmt.Errorf("error creating progress table: %v", err)
+	}
+	if err := createSentimentTable(); err != nil {
+		return fmt.Errorf("error creating sentiment table: %v", err)
+	}
+	if err := createThemeTables(); err != nil {
+		return fmt.Errorf("error creating theme tables: %v", err)
+	}
+	if err := createCodingTables(); err != nil {
+		return fmt.Errorf("error creating coding tables: %v", err)
+	}
+	if err := createEventLogTable(); err != nil {
+		return fmt.Errorf("error creating event log table: %v", err)
+	}
+	if err := createWebhookTables(); err != nil {
+		return fmt.Errorf("error creating webhook tables: %v", err)
+	}
+	if err := createIdempotencyTable(); err != nil {
+		return fmt.Errorf("error creating idempotency table: %v", err)
+	}
+	if err := createChallengeTable(); err != nil {
+		return fmt.Errorf("error creating challenge table: %v", err)
+	}
+	if err := createSpamColumns(); err != nil {
+		return fmt.Errorf("error adding spam columns: %v", err)
+	}
+	if err := createSearchIndex(); err != nil {
+		return fmt.Errorf("error creating search index: %v", err)
+	}
+	if err := createWaitlistTable(); err != nil {
+		return fmt.Errorf("error creating waitlist table: %v", err)
+	}
+	if err := createAggregateTables(); err != nil {
+		return fmt.Errorf("error creating metrics aggregates: %v", err)
+	}
+	// Last, as its triggers watch tables created above
+	if err := createCacheVersionTable(); err != nil {
+		return fmt.Errorf("error creating cache version table: %v", err)
+	}
 	return nil
 }
 
@@ -310,21 +385,9 @@ func endpointRateLimiter(limit rate.Limit, burst int) gin.HandlerFunc {
 	}
 }
 
-func submitSurvey(c *gin.Context) {
-	var survey SurveyResponse
-	if err := c.BindJSON(&survey); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	survey.ID = uuid.New().String()
-	survey.CreatedAt = time.Now()
-
-	// Log the survey data
-	log.Printf("Submitting survey: %+v\n", survey)
-	log.Printf("Features: %+v\n", survey.Features)
-
-	stmt, err := db.Prepare(`
+// insertSurveyResponse stores a new response row
+func insertSurveyResponse(tx *sql.Tx, survey *SurveyResponse) error {
+	_, err := tx.Exec(`
 		INSERT INTO survey_responses (
 			id, created_at, role, other_role, cms_usage, other_cms_usage,
 			offline, collaboration, asset_management,
@@ -339,7 +402,8 @@ func submitSurvey(c *gin.Context) {
 			content_types, custom_formats,
 			feedback_suggestions, excitement_factors,
 			collaboration_challenges, offline_work_frequency, offline_workarounds,
-			current_change_conflict_handling, version_control_challenges
+			current_change_conflict_handling, version_control_challenges,
+			language
 		) VALUES (
 			?, ?, ?, ?, ?, ?,
 			?, ?, ?,
@@ -352,16 +416,12 @@ func submitSurvey(c *gin.Context) {
 			?, ?,
 			?, ?,
 			?, ?,
-			 ?, ?, ?, ?
+			?, ?,
+			?, ?, ?,
+			?, ?,
+			?
 		)
-	`)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(
+	`,
 		survey.ID, survey.CreatedAt, survey.Role, survey.OtherRole,
 		survey.CmsUsage, survey.OtherCmsUsage,
 		survey.Features.Offline, survey.Features.Collaboration,
@@ -382,26 +442,181 @@ func submitSurvey(c *gin.Context) {
 		survey.OfflineWorkarounds,
 		survey.CurrentChangeConflictHandling,
 		survey.VersionControlChallenges,
+		survey.Language,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return err
+	}
+	return storeSentiment(tx, survey)
+}
+
+func submitSurvey(c *gin.Context) {
+	var submission surveySubmission
+	pending, err := bindSubmission(c, &submission)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	survey := submission.SurveyResponse
+	now := time.Now()
 
-	c.JSON(http.StatusCreated, survey)
-}
+	// Retries of a submission that already went through get the original answer
+	idempotencyKey, err := submissionIdempotencyKey(c, &survey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var requestHash string
+	if idempotencyKey != "" {
+		requestHash, err = submissionHash(&submission, pending)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if replayIdempotentResult(c, idempotencyKey, requestHash, submission.RequestEditLink, now) {
+			return
+		}
+	}
 
-func getSurveyResults(c *gin.Context) {
-	cacheMutex.RLock()
-	if time.Since(cacheTimestamp) < cacheDuration && resultsCache != nil {
-		defer cacheMutex.RUnlock()
-		c.JSON(http.StatusOK, resultsCache)
+	if survey.ID == "" {
+		survey.ID = uuid.New().String()
+	}
+	survey.CreatedAt = now
+	nonce, err := verifyChallenge(c, &submission, survey.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	// Accept-Language says nothing about the form that was shown, so answers
+	// without a language are taken to be the canonical English ones
+	if !isSupportedLanguage(survey.Language) {
+		survey.Language = defaultLanguage
+	}
+	canonicalizeOptions(&survey, survey.Language)
+
+	// Answers can hold an email address and free text, so only identify the submission
+	log.Printf("Submitting survey %s (role %q)", survey.ID, survey.Role)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if idempotencyKey != "" {
+		claimed, err := claimIdempotencyKey(tx, idempotencyKey, requestHash, survey.ID, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !claimed {
+			// A concurrent retry stored the response first
+			tx.Rollback()
+			if !replayIdempotentResult(c, idempotencyKey, requestHash, submission.RequestEditLink, now) {
+				c.JSON(http.StatusConflict, gin.H{"error": "submission with this idempotency key is in progress"})
+			}
+			return
+		}
+	}
+	if err := consumeChallenge(tx, nonce, survey.CreatedAt); err != nil {
+		if isChallengeError(err) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := insertSurveyResponse(tx, &survey); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: survey_responses.id") {
+			c.JSON(http.StatusConflict, gin.H{"error": "a response with this id already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkSurveyAvailability(tx, &survey); err != nil {
+		if isAvailabilityError(err) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := redeemInvite(tx, submission.InviteCode, survey.ID); err != nil {
+		if isInviteError(err) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordRevision(tx, nil, survey, "respondent", "created"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := scoreSubmission(tx, &survey, submission.SessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := enqueueWebhook(tx, webhookResponseCreated, survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := enqueueBetaSignup(tx, nil, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := syncWaitlist(tx, survey.ID, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyMetricsDelta(tx, nil, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordResponseEvent(tx, streamResponseCreated, nil, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	attachments, storedKeys, err := storeAttachments(tx, survey.ID, pending)
+	if err != nil {
+		deleteStoredFiles(storedKeys)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	cacheMutex.RUnlock()
+	result := surveySubmissionResult{SurveyResponse: survey, Attachments: attachments}
+	if submission.RequestEditLink {
+		token, expiresAt, err := issueEditToken(survey.ID)
+		if err != nil {
+			deleteStoredFiles(storedKeys)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		result.EditToken = token
+		result.EditTokenExpiresAt = &expiresAt
+	}
+	if idempotencyKey != "" {
+		if err := saveIdempotentResult(tx, idempotencyKey, http.StatusCreated); err != nil {
+			deleteStoredFiles(storedKeys)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		deleteStoredFiles(storedKeys)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if result.EditToken != "" {
+		go sendEditLink(survey, result.EditToken, *result.EditTokenExpiresAt)
+	}
 
-	rows, err := db.Query(`
-		SELECT id, role, other_role, cms_usage, other_cms_usage,
+	c.JSON(http.StatusCreated, result)
+}
+
+// surveyResponseColumns lists the columns read by scanSurveyResponse, in order
+const surveyResponseColumns = `id, role, other_role, cms_usage, other_cms_usage,
 		offline, collaboration, asset_management,
 		pdf_handling, version_control, workflows,
 		beta_interest, email, biggest_frustrations, specific_problems,
@@ -411,60 +626,193 @@ func getSurveyResults(c *gin.Context) {
 		content_types, custom_formats, feedback_suggestions, excitement_factors,
 		collaboration_challenges, offline_work_frequency, offline_workarounds,
 		current_change_conflict_handling, version_control_challenges,
-		created_at 
-		FROM survey_responses`)
+		language, created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSurveyResponse(row rowScanner) (SurveyResponse, error) {
+	var response SurveyResponse
+	err := row.Scan(&response.ID, &response.Role, &response.OtherRole,
+		&response.CmsUsage, &response.OtherCmsUsage,
+		&response.Features.Offline, &response.Features.Collaboration,
+		&response.Features.AssetManagement, &response.Features.PdfHandling,
+		&response.Features.VersionControl, &response.Features.Workflows,
+		&response.BetaInterest, &response.Email, &response.BiggestFrustrations,
+		&response.SpecificProblems, &response.UsageFrequency, &response.PrimaryPurpose,
+		&response.Platforms, &response.CmsPreference, &response.WishedFeatures,
+		&response.WorkflowImportance, &response.TeamSize, &response.CollaborationFrequency,
+		&response.PricingSensitivity, &response.PricingModel, &response.Integrations,
+		&response.IntegrationImportance, &response.ContentTypes, &response.CustomFormats,
+		&response.FeedbackSuggestions, &response.ExcitementFactors, &response.CollaborationChallenges,
+		&response.OfflineWorkFrequency, &response.OfflineWorkarounds, &response.CurrentChangeConflictHandling,
+		&response.VersionControlChallenges, &response.Language, &response.CreatedAt)
+	return response, err
+}
+
+// getSurveyResponse loads a single response, returning sql.ErrNoRows if it doesn't exist
+func getSurveyResponse(id string) (SurveyResponse, error) {
+	return scanSurveyResponse(db.QueryRow(`SELECT `+surveyResponseColumns+` FROM survey_responses WHERE id = ?`, id))
+}
+
+// updateSurveyResponse overwrites every answer column of an existing response
+func updateSurveyResponse(tx *sql.Tx, survey *SurveyResponse) error {
+	_, err := tx.Exec(`
+		UPDATE survey_responses SET
+			role = ?, other_role = ?, cms_usage = ?, other_cms_usage = ?,
+			offline = ?, collaboration = ?, asset_management = ?,
+			pdf_handling = ?, version_control = ?, workflows = ?,
+			beta_interest = ?, email = ?,
+			biggest_frustrations = ?, specific_problems = ?,
+			usage_frequency = ?, primary_purpose = ?, platforms = ?,
+			cms_preference = ?, wished_features = ?, workflow_importance = ?,
+			team_size = ?, collaboration_frequency = ?,
+			pricing_sensitivity = ?, pricing_model = ?,
+			integrations = ?, integration_importance = ?,
+			content_types = ?, custom_formats = ?,
+			feedback_suggestions = ?, excitement_factors = ?,
+			collaboration_challenges = ?, offline_work_frequency = ?, offline_workarounds = ?,
+			current_change_conflict_handling = ?, version_control_challenges = ?,
+			updated_at = ?
+		WHERE id = ?`,
+		survey.Role, survey.OtherRole,
+		survey.CmsUsage, survey.OtherCmsUsage,
+		survey.Features.Offline, survey.Features.Collaboration,
+		survey.Features.AssetManagement, survey.Features.PdfHandling,
+		survey.Features.VersionControl, survey.Features.Workflows,
+		survey.BetaInterest, survey.Email,
+		survey.BiggestFrustrations, survey.SpecificProblems,
+		survey.UsageFrequency, survey.PrimaryPurpose,
+		survey.Platforms, survey.CmsPreference,
+		survey.WishedFeatures, survey.WorkflowImportance,
+		survey.TeamSize, survey.CollaborationFrequency,
+		survey.PricingSensitivity, survey.PricingModel,
+		survey.Integrations, survey.IntegrationImportance,
+		survey.ContentTypes, survey.CustomFormats,
+		survey.FeedbackSuggestions, survey.ExcitementFactors,
+		survey.CollaborationChallenges,
+		survey.OfflineWorkFrequency,
+		survey.OfflineWorkarounds,
+		survey.CurrentChangeConflictHandling,
+		survey.VersionControlChallenges,
+		time.Now(),
+		survey.ID,
+	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return err
+	}
+	return storeSentiment(tx, survey)
+}
+
+func getSurveyResults(c *gin.Context) {
+	filter, err := parseResponseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
-
-	var responses []SurveyResponse
-	for rows.Next() {
-		var response SurveyResponse
-		err := rows.Scan(&response.ID, &response.Role, &response.OtherRole,
-			&response.CmsUsage, &response.OtherCmsUsage,
-			&response.Features.Offline, &response.Features.Collaboration,
-			&response.Features.AssetManagement, &response.Features.PdfHandling,
-			&response.Features.VersionControl, &response.Features.Workflows,
-			&response.BetaInterest, &response.Email, &response.BiggestFrustrations,
-			&response.SpecificProblems, &response.UsageFrequency, &response.PrimaryPurpose,
-			&response.Platforms, &response.CmsPreference, &response.WishedFeatures,
-			&response.WorkflowImportance, &response.TeamSize, &response.CollaborationFrequency,
-			&response.PricingSensitivity, &response.PricingModel, &response.Integrations,
-			&response.IntegrationImportance, &response.ContentTypes, &response.CustomFormats,
-			&response.FeedbackSuggestions, &response.ExcitementFactors, &response.CollaborationChallenges,
-			&response.OfflineWorkFrequency, &response.OfflineWorkarounds, &response.CurrentChangeConflictHandling,
-			&response.VersionControlChallenges, &response.CreatedAt)
+
+	serveCached(c, func() (interface{}, error) {
+		rows, err := db.Query(`SELECT `+surveyResponseColumns+` FROM survey_responses`+filter.where(), filter.args...)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return nil, err
 		}
-		responses = append(responses, response)
-	}
+		defer rows.Close()
+
+		var responses []SurveyResponse
+		for rows.Next() {
+			response, err := scanSurveyResponse(rows)
+			if err != nil {
+				return nil, err
+			}
+			responses = append(responses, response)
+		}
+		return responses, rows.Err()
+	})
+}
+
+// derivedTables hold data computed from a response's answers
+var derivedTables = []string{"survey_response_sentiment", "survey_theme_assignments"}
 
-	cacheMutex.Lock()
-	resultsCache = responses
-	cacheTimestamp = time.Now()
-	cacheMutex.Unlock()
+// deleteDerivedData removes everything computed from a deleted response
+func deleteDerivedData(tx *sql.Tx, responseID string) error {
+	for _, table := range derivedTables {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE response_id = ?`, responseID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	c.JSON(http.StatusOK, responses)
+// deleteResponse removes a response with its derived data and records the
+// deletion in its revision history. It returns the keys of attachment files to
+// remove once the transaction commits, and whether the response existed.
+func deleteResponse(tx *sql.Tx, id, by string) ([]string, bool, error) {
+	previous, err := responseInTx(tx, id)
+	if err != nil || previous == nil {
+		return nil, false, err
+	}
+	if _, err := tx.Exec("DELETE FROM survey_responses WHERE id = ?", id); err != nil {
+		return nil, false, err
+	}
+	attachmentKeys, err := detachAttachments(tx, id)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := deleteDerivedData(tx, id); err != nil {
+		return nil, false, err
+	}
+	if err := enqueueWebhook(tx, webhookResponseDeleted, gin.H{"id": id}); err != nil {
+		return nil, false, err
+	}
+	if err := redactWebhookDeliveries(tx, id); err != nil {
+		return nil, false, err
+	}
+	if err := applyMetricsDelta(tx, previous, nil); err != nil {
+		return nil, false, err
+	}
+	if err := recordResponseEvent(tx, streamResponseDeleted, previous, nil); err != nil {
+		return nil, false, err
+	}
+	if err := redactResponseEvents(tx, id); err != nil {
+		return nil, false, err
+	}
+	if err := syncWaitlist(tx, id, nil); err != nil {
+		return nil, false, err
+	}
+	// Revisions are kept as an audit trail of deleted responses, minus the
+	// respondent's contact details
+	if err := redactRevisions(tx, id); err != nil {
+		return nil, false, err
+	}
+	_, err = tx.Exec(`INSERT INTO survey_response_revisions (response_id, revision, action, data, changes, changed_by, changed_at)
+		SELECT response_id, MAX(revision) + 1, 'deleted', 'null', '[]', ?, ?
+		FROM survey_response_revisions WHERE response_id = ? GROUP BY response_id`,
+		by, time.Now(), id)
+	if err != nil {
+		return nil, false, err
+	}
+	return attachmentKeys, true, nil
 }
 
 func deleteResult(c *gin.Context) {
-	id := c.Param("id")
-	stmt, err := db.Prepare("DELETE FROM survey_responses WHERE id = ?")
+	tx, err := db.Begin()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	_, err = stmt.Exec(id)
+	attachmentKeys, _, err := deleteResponse(tx, c.Param("id"), changedBy(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	deleteStoredFiles(attachmentKeys)
 	c.JSON(http.StatusOK, gin.H{"message": "Result deleted"})
 }
 
@@ -577,140 +925,50 @@ func getTrustedProxies() ([]string, error) {
 	}, nil
 }
 
-// Add metrics collection
-type Metrics struct {
-	TotalResponses       int                `json:"totalResponses"`
-	BetaInterestCount    int                `json:"betaInterestCount"`
-	AverageFeatureScores map[string]float64 `json:"averageFeatureScores"`
-	UsageFrequencyStats  map[string]int     `json:"usageFrequencyStats"`
-	TeamSizeDistribution map[string]int     `json:"teamSizeDistribution"`
-	PricingPreferences   map[string]int     `json:"pricingPreferences"`
-}
-
-func getMetrics(c *gin.Context) {
-	metrics := Metrics{
-		AverageFeatureScores: make(map[string]float64),
-		UsageFrequencyStats:  make(map[string]int),
-		TeamSizeDistribution: make(map[string]int),
-		PricingPreferences:   make(map[string]int),
-	}
-
-	var (
-		offlineScore, collaborationScore, assetScore float64
-		pdfScore, vcScore, workflowScore             float64
-	)
-
-	// Get basic counts and feature averages
-	err := db.QueryRow(`
-		SELECT 
-			COUNT(*) as total,
-			SUM(CASE WHEN beta_interest = 1 THEN 1 ELSE 0 END) as beta_count,
-			AVG(offline) as avg_offline,
-			AVG(collaboration) as avg_collab,
-			AVG(asset_management) as avg_asset,
-			AVG(pdf_handling) as avg_pdf,
-			AVG(version_control) as avg_vc,
-			AVG(workflows) as avg_workflow
-		FROM survey_responses
-	`).Scan(
-		&metrics.TotalResponses,
-		&metrics.BetaInterestCount,
-		&offlineScore,
-		&collaborationScore,
-		&assetScore,
-		&pdfScore,
-		&vcScore,
-		&workflowScore,
-	)
-
-	// Then assign to map
-	metrics.AverageFeatureScores["offline"] = offlineScore
-	metrics.AverageFeatureScores["collaboration"] = collaborationScore
-	metrics.AverageFeatureScores["assetManagement"] = assetScore
-	metrics.AverageFeatureScores["pdfHandling"] = pdfScore
-	metrics.AverageFeatureScores["versionControl"] = vcScore
-	metrics.AverageFeatureScores["workflows"] = workflowScore
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Get usage frequency distribution
-	rows, err := db.Query(`
-		SELECT usage_frequency, COUNT(*) as count 
-		FROM survey_responses 
-		GROUP BY usage_frequency
-	`)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var frequency string
-		var count int
-		if err := rows.Scan(&frequency, &count); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		metrics.UsageFrequencyStats[frequency] = count
-	}
+// sensitiveQueryParams are credentials clients may put in the URL; older
+// dashboards sent the admin token as access_token
+var sensitiveQueryParams = []string{"token", "access_token"}
 
-	// Get team size distribution
-	rows, err = db.Query(`
-		SELECT team_size, COUNT(*) as count 
-		FROM survey_responses 
-		GROUP BY team_size
-	`)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// redactQuery hides credentials in a logged request path
+func redactQuery(path string) string {
+	u, err := url.Parse(path)
+	if err != nil || u.RawQuery == "" {
+		return path
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var size string
-		var count int
-		if err := rows.Scan(&size, &count); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	query := u.Query()
+	redacted := false
+	for _, param := range sensitiveQueryParams {
+		if query.Has(param) {
+			query.Set(param, "REDACTED")
+			redacted = true
 		}
-		metrics.TeamSizeDistribution[size] = count
 	}
-
-	// Get pricing preferences
-	rows, err = db.Query(`
-		SELECT pricing_model, COUNT(*) as count 
-		FROM survey_responses 
-		GROUP BY pricing_model
-	`)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var model string
-		var count int
-		if err := rows.Scan(&model, &count); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		metrics.PricingPreferences[model] = count
+	if !redacted {
+		return path
 	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
 
-	// Round feature scores to 2 decimal places
-	for key, value := range metrics.AverageFeatureScores {
-		metrics.AverageFeatureScores[key] = float64(int(value*100)) / 100
+// accessLogFormatter is gin's default access log line without credentials
+func accessLogFormatter(param gin.LogFormatterParams) string {
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
 	}
-
-	c.JSON(http.StatusOK, metrics)
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		redactQuery(param.Path),
+		param.ErrorMessage,
+	)
 }
 
 func setupRouter(env string) *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.LoggerWithFormatter(accessLogFormatter), gin.Recovery())
 
 	// Disable rate limiting for preview environment
 	if os.Getenv("RATE_LIMIT_DISABLED") == "true" {
@@ -724,7 +982,14 @@ func setupRouter(env string) *gin.Engine {
 
 		// Public routes
 		r.POST("/survey", endpointRateLimiter(rate.Every(time.Minute), 5), submitSurvey)
+		r.GET("/survey/:id", endpointRateLimiter(rate.Every(time.Second), 5), getOwnResponse)
+		r.PUT("/survey/:id", endpointRateLimiter(rate.Every(time.Minute), 10), updateOwnResponse)
+		r.GET("/survey/definition", getSurveyDefinition)
+		r.GET("/survey/status", getSurveyStatus)
+		r.GET("/survey/challenge", endpointRateLimiter(rate.Every(time.Second), 10), getChallenge)
+		r.POST("/survey/progress", endpointRateLimiter(rate.Every(time.Second), 20), recordProgress)
 		r.POST("/login", endpointRateLimiter(rate.Every(time.Minute), 3), login)
+		r.POST("/waitlist/redeem", endpointRateLimiter(rate.Every(time.Minute), 10), redeemBetaInvite)
 
 		// Add explicit health check logging
 		r.GET("/health", func(c *gin.Context) {
@@ -735,14 +1000,64 @@ func setupRouter(env string) *gin.Engine {
 			})
 		})
 
+		// EventSource cannot send headers, so the stream takes a short-lived
+		// token from /events/stream/token in its URL instead
+		r.GET("/events/stream", streamTokenAuth(), streamEvents)
+
 		// Protected routes
 		authorized := r.Group("/")
 		authorized.Use(AuthMiddleware())
 		{
 			authorized.GET("/results", getSurveyResults)
+			authorized.GET("/results/search", searchResults)
+			authorized.GET("/results/spam", getSpamFlags)
+			authorized.POST("/results/spam/remove", removeSpam)
+			authorized.POST("/results/:id/spam/clear", clearSpamFlag)
 			authorized.GET("/verify", verifyToken)
 			authorized.DELETE("/results/:id", deleteResult)
+			authorized.PUT("/results/:id", updateResult)
+			authorized.GET("/results/:id/history", getResultHistory)
+			authorized.POST("/results/:id/revert", revertResult)
+			authorized.GET("/results/:id/attachments", getResultAttachments)
+			authorized.GET("/attachments/:id", downloadAttachment)
+			authorized.GET("/schedule", getSchedule)
+			authorized.PUT("/schedule", updateSchedule)
+			authorized.GET("/invites", getInvites)
+			authorized.POST("/invites", createInvites)
+			authorized.DELETE("/invites/:code", revokeInvite)
+			authorized.GET("/waitlist", getWaitlist)
+			authorized.GET("/waitlist/summary", getWaitlistSummary)
+			authorized.POST("/waitlist/invite", inviteWaitlist)
+			authorized.GET("/waitlist/:id", getWaitlistEntry)
+			authorized.PUT("/waitlist/:id/status", updateWaitlistStatus)
 			authorized.GET("/metrics", getMetrics)
+			authorized.GET("/metrics/funnel", getFunnel)
+			authorized.GET("/metrics/timeseries", getTimeseries)
+			authorized.GET("/metrics/crosstab", getCrosstab)
+			authorized.GET("/analysis/keywords", getKeywords)
+			authorized.GET("/analysis/themes", getThemes)
+			authorized.GET("/analysis/themes/:id", getThemeResponses)
+			authorized.PUT("/analysis/themes/:id", renameTheme)
+			authorized.POST("/analysis/themes/:id/merge", mergeTheme)
+			authorized.GET("/cache/stats", getCacheStats)
+			authorized.POST("/events/stream/token", issueStreamToken)
+			authorized.GET("/webhooks", getWebhooks)
+			authorized.POST("/webhooks", createWebhook)
+			authorized.GET("/webhooks/deliveries", getWebhookDeliveries)
+			authorized.GET("/webhooks/deliveries/:id", getWebhookDelivery)
+			authorized.POST("/webhooks/deliveries/:id/replay", replayWebhookDelivery)
+			authorized.PUT("/webhooks/:id", updateWebhook)
+			authorized.DELETE("/webhooks/:id", deleteWebhook)
+			authorized.POST("/webhooks/:id/test", testWebhook)
+			authorized.GET("/codes", getCodebook)
+			authorized.POST("/codes", createCode)
+			authorized.PUT("/codes/:id", updateCode)
+			authorized.DELETE("/codes/:id", deleteCode)
+			authorized.GET("/codes/report", getCodeReport)
+			authorized.GET("/codes/agreement", getCodeAgreement)
+			authorized.GET("/results/:id/codes", getResponseCodes)
+			authorized.POST("/results/:id/codes", applyCode)
+			authorized.DELETE("/results/:id/codes/:applicationId", removeCodeApplication)
 		}
 	}
 
@@ -750,6 +1065,14 @@ func setupRouter(env string) *gin.Engine {
 }
 
 func main() {
+	// Maintenance commands run against the database and exit
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Set Gin mode based on environment
 	if getEnvWithFallback("ENVIRONMENT", "development") == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -766,6 +1089,15 @@ func main() {
 	initDB()
 	defer db.Close()
 
+	store, err := newLocalStorage(getEnvWithFallback("ATTACHMENTS_DIR", "data/attachments"))
+	if err != nil {
+		log.Fatalf("Failed to set up attachment storage: %v", err)
+	}
+	attachmentStore = store
+
+	go runWebhookDispatcher()
+	go runEventBroadcaster()
+
 	// Set trusted proxies with proper error handling
 	trustedProxies, err := getTrustedProxies()
 	if err != nil {
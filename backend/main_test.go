@@ -0,0 +1,57 @@
+package main
+
+import (
+	"container/list"
+	"database/sql"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openTestDB points db at a fresh database with the full schema and empties
+// the query cache, whose entries are only keyed by the data version
+func openTestDB(t *testing.T) {
+	t.Helper()
+	responseCache = &queryCache{entries: make(map[string]*list.Element), order: list.New()}
+	// Tests submit without solving a challenge unless they turn it back on
+	t.Setenv("SURVEY_CHALLENGE_REQUIRED", "false")
+	var err error
+	db, err = sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := createInitialTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := initSupportTables(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// callHandler runs a single handler against a recorded request
+func callHandler(handler gin.HandlerFunc, method, target string, body io.Reader, params ...gin.Param) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, target, body)
+	c.Params = params
+	handler(c)
+	return recorder
+}
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"/events/stream?token=abc.def&lastEventId=4", "/events/stream?lastEventId=4&token=REDACTED"},
+		{"/events/stream?access_token=abc", "/events/stream?access_token=REDACTED"},
+		{"/metrics?groupBy=language", "/metrics?groupBy=language"},
+		{"/results", "/results"},
+	}
+	for _, tt := range tests {
+		if got := redactQuery(tt.path); got != tt.want {
+			t.Errorf("redactQuery(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
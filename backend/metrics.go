@@ -0,0 +1,339 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Add metrics collection. AverageFeatureScores, like FeatureStats, averages
+// the answered (1-5) scores only; skipped questions (0) are left out.
+type Metrics struct {
+	TotalResponses       int                       `json:"totalResponses"`
+	BetaInterestCount    int                       `json:"betaInterestCount"`
+	AverageFeatureScores map[string]float64        `json:"averageFeatureScores"`
+	UsageFrequencyStats  map[string]int            `json:"usageFrequencyStats"`
+	TeamSizeDistribution map[string]int            `json:"teamSizeDistribution"`
+	PricingPreferences   map[string]int            `json:"pricingPreferences"`
+	LanguageDistribution map[string]int            `json:"languageDistribution"`
+	RoleDistribution     map[string]int            `json:"roleDistribution"`
+	CmsUsageDistribution map[string]int            `json:"cmsUsageDistribution"`
+	Distributions        map[string]map[string]int `json:"distributions"`
+	FeatureStats         map[string]FeatureStats   `json:"featureStats"`
+	FeaturePriorities    []string                  `json:"featurePriorities"`
+	Sentiment            map[string]SentimentStats `json:"sentiment"`
+	CodeFrequencies      []CodeFrequency           `json:"codeFrequencies"`
+	Groups               map[string]Metrics        `json:"groups,omitempty"`
+}
+
+// featureNames lists the Features JSON names in display order
+var featureNames = []string{"offline", "collaboration", "assetManagement", "pdfHandling", "versionControl", "workflows"}
+
+// featureColumns maps the Features JSON names to their survey_responses columns
+var featureColumns = map[string]string{
+	"offline":         "offline",
+	"collaboration":   "collaboration",
+	"assetManagement": "asset_management",
+	"pdfHandling":     "pdf_handling",
+	"versionControl":  "version_control",
+	"workflows":       "workflows",
+}
+
+// otherBucket collects free-text "other" answers and values outside a
+// question's option list
+const otherBucket = "other"
+
+// distributionField describes how one column is summarised in
+// Metrics.Distributions. Fields with options fold unknown values into the
+// other bucket; multi-select fields are split into their individual values.
+type distributionField struct {
+	key     string
+	column  string
+	options []string
+	multi   bool
+}
+
+var distributionFields = []distributionField{
+	{key: "roles", column: "role", options: questionOptions("role")},
+	{key: "cmsUsage", column: "cms_usage", options: questionOptions("cmsUsage")},
+	{key: "teamSizes", column: "team_size", options: questionOptions("teamSize")},
+	{key: "pricing", column: "pricing_model", options: questionOptions("pricingModel")},
+	{key: "usageFrequency", column: "usage_frequency", options: questionOptions("usageFrequency")},
+	{key: "offlineWorkFrequency", column: "offline_work_frequency", options: questionOptions("offlineWorkFrequency")},
+	{key: "primaryPurpose", column: "primary_purpose", multi: true},
+	{key: "platforms", column: "platforms", multi: true},
+	{key: "contentTypes", column: "content_types", multi: true},
+	{key: "integrations", column: "integrations", multi: true},
+	{key: "cmsPreference", column: "cms_preference"},
+	{key: "collaborationFrequency", column: "collaboration_frequency"},
+	{key: "pricingSensitivity", column: "pricing_sensitivity"},
+	{key: "workflowImportance", column: "workflow_importance"},
+	{key: "integrationImportance", column: "integration_importance"},
+	{key: "languages", column: "language", options: supportedLanguages},
+}
+
+// questionOptions returns the canonical option values of a survey question
+func questionOptions(id string) []string {
+	for _, spec := range surveyQuestions {
+		if spec.id == id {
+			return spec.options
+		}
+	}
+	return nil
+}
+
+// splitMultiSelect breaks a multi-select answer into its individual values
+func splitMultiSelect(value string) []string {
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n'
+	})
+	values := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// bucketValue maps a raw answer onto the key it is counted under
+func bucketValue(field distributionField, value string) string {
+	if strings.HasPrefix(strings.ToLower(value), otherBucket) {
+		return otherBucket
+	}
+	if field.options == nil {
+		return strings.ToLower(value)
+	}
+	for _, option := range field.options {
+		if strings.EqualFold(value, option) {
+			return option
+		}
+	}
+	return otherBucket
+}
+
+// distribution counts answers for a field. Identical answers are grouped in
+// SQL first so only distinct values are post-processed here.
+func distribution(field distributionField, filter responseFilter) (map[string]int, error) {
+	raw, err := countBy(field.column, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for value, count := range raw {
+		for _, bucket := range answerBuckets(field, value) {
+			counts[bucket] += count
+		}
+	}
+	return counts, nil
+}
+
+// answerBuckets returns the keys one response's answer is counted under,
+// each at most once
+func answerBuckets(field distributionField, value string) []string {
+	values := []string{strings.TrimSpace(value)}
+	if field.multi {
+		values = splitMultiSelect(value)
+	}
+	var buckets []string
+	seen := make(map[string]bool)
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		bucket := bucketValue(field, v)
+		if !seen[bucket] {
+			seen[bucket] = true
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets
+}
+
+// countBy returns the number of responses per distinct value of a column
+func countBy(column string, filter responseFilter) (map[string]int, error) {
+	rows, err := db.Query(`
+		SELECT COALESCE(`+column+`, ''), COUNT(*) as count 
+		FROM survey_responses`+filter.where()+`
+		GROUP BY `+column, filter.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var value string
+		var count int
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, err
+		}
+		counts[value] += count
+	}
+	return counts, rows.Err()
+}
+
+// computeMetrics answers /metrics. Unfiltered requests read the precomputed
+// aggregates; filtered ones query survey_responses directly.
+func computeMetrics(filter responseFilter) (Metrics, error) {
+	var (
+		metrics    Metrics
+		histograms map[string][]int
+		err        error
+	)
+	if len(filter.clauses) == 0 {
+		metrics, histograms, err = aggregateMetrics()
+	} else {
+		metrics, histograms, err = liveMetrics(filter)
+	}
+	if err != nil {
+		return metrics, err
+	}
+
+	metrics.RoleDistribution = metrics.Distributions["roles"]
+	metrics.CmsUsageDistribution = metrics.Distributions["cmsUsage"]
+	metrics.FeatureStats, metrics.FeaturePriorities = rankFeatures(histograms)
+
+	if metrics.Sentiment, err = sentimentByField(filter); err != nil {
+		return metrics, err
+	}
+	if metrics.CodeFrequencies, err = codeFrequencies(filter, "", ""); err != nil {
+		return metrics, err
+	}
+
+	// Round feature scores to 2 decimal places
+	for key, value := range metrics.AverageFeatureScores {
+		metrics.AverageFeatureScores[key] = roundTo(value, 2)
+	}
+
+	return metrics, nil
+}
+
+// liveMetrics computes the counts behind /metrics with queries over
+// survey_responses, along with the feature score histograms
+func liveMetrics(filter responseFilter) (Metrics, map[string][]int, error) {
+	metrics := Metrics{
+		AverageFeatureScores: make(map[string]float64),
+	}
+
+	var (
+		offlineScore, collaborationScore, assetScore float64
+		pdfScore, vcScore, workflowScore             float64
+	)
+
+	// Get basic counts and feature averages. survey_responses always holds the
+	// current revision of each response, so edits are reflected immediately.
+	err := db.QueryRow(`
+		SELECT 
+			COUNT(*) as total,
+			COALESCE(SUM(CASE WHEN beta_interest = 1 THEN 1 ELSE 0 END), 0) as beta_count,
+			COALESCE(AVG(NULLIF(offline, 0)), 0) as avg_offline,
+			COALESCE(AVG(NULLIF(collaboration, 0)), 0) as avg_collab,
+			COALESCE(AVG(NULLIF(asset_management, 0)), 0) as avg_asset,
+			COALESCE(AVG(NULLIF(pdf_handling, 0)), 0) as avg_pdf,
+			COALESCE(AVG(NULLIF(version_control, 0)), 0) as avg_vc,
+			COALESCE(AVG(NULLIF(workflows, 0)), 0) as avg_workflow
+		FROM survey_responses`+filter.where(),
+		filter.args...,
+	).Scan(
+		&metrics.TotalResponses,
+		&metrics.BetaInterestCount,
+		&offlineScore,
+		&collaborationScore,
+		&assetScore,
+		&pdfScore,
+		&vcScore,
+		&workflowScore,
+	)
+	if err != nil {
+		return metrics, nil, err
+	}
+
+	// Then assign to map
+	metrics.AverageFeatureScores["offline"] = offlineScore
+	metrics.AverageFeatureScores["collaboration"] = collaborationScore
+	metrics.AverageFeatureScores["assetManagement"] = assetScore
+	metrics.AverageFeatureScores["pdfHandling"] = pdfScore
+	metrics.AverageFeatureScores["versionControl"] = vcScore
+	metrics.AverageFeatureScores["workflows"] = workflowScore
+
+	// Get usage frequency, team size, pricing and language distributions
+	if metrics.UsageFrequencyStats, err = countBy("usage_frequency", filter); err != nil {
+		return metrics, nil, err
+	}
+	if metrics.TeamSizeDistribution, err = countBy("team_size", filter); err != nil {
+		return metrics, nil, err
+	}
+	if metrics.PricingPreferences, err = countBy("pricing_model", filter); err != nil {
+		return metrics, nil, err
+	}
+	if metrics.LanguageDistribution, err = countBy("language", filter); err != nil {
+		return metrics, nil, err
+	}
+
+	metrics.Distributions = make(map[string]map[string]int)
+	for _, field := range distributionFields {
+		if metrics.Distributions[field.key], err = distribution(field, filter); err != nil {
+			return metrics, nil, err
+		}
+	}
+
+	histograms, err := featureHistograms(filter)
+	return metrics, histograms, err
+}
+
+// roundTo rounds a value half away from zero to the given number of decimals
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
+
+// metricsGroupColumns are the columns /metrics can be broken down by with ?groupBy=
+var metricsGroupColumns = map[string]string{
+	"language": "language",
+	"role":     "role",
+	"teamSize": "team_size",
+	"cmsUsage": "cms_usage",
+}
+
+func getMetrics(c *gin.Context) {
+	filter, err := parseResponseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	groupBy := c.Query("groupBy")
+	column, ok := metricsGroupColumns[groupBy]
+	if groupBy != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "groupBy must be one of language, role, teamSize or cmsUsage"})
+		return
+	}
+
+	serveCached(c, func() (interface{}, error) {
+		metrics, err := computeMetrics(filter)
+		if err != nil {
+			return nil, err
+		}
+		if groupBy == "" {
+			return metrics, nil
+		}
+
+		values, err := countBy(column, filter)
+		if err != nil {
+			return nil, err
+		}
+		metrics.Groups = make(map[string]Metrics)
+		for value := range values {
+			group, err := computeMetrics(filter.with("COALESCE("+column+", '') = ?", value))
+			if err != nil {
+				return nil, err
+			}
+			metrics.Groups[value] = group
+		}
+		return metrics, nil
+	})
+}
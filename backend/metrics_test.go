@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSplitMultiSelect(t *testing.T) {
+	got := splitMultiSelect(" Blog, Docs;Marketing site\n\n,")
+	want := []string{"Blog", "Docs", "Marketing site"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitMultiSelect() = %q, want %q", got, want)
+	}
+}
+
+func TestBucketValue(t *testing.T) {
+	role := distributionField{key: "roles", column: "role", options: questionOptions("role")}
+	platforms := distributionField{key: "platforms", column: "platforms", multi: true}
+
+	tests := []struct {
+		field distributionField
+		value string
+		want  string
+	}{
+		{role, "Developer", "Developer"},
+		{role, "content editor", "Content Editor"},
+		{role, "Other: founder", otherBucket},
+		{role, "Astronaut", otherBucket},
+		{platforms, "Netlify", "netlify"},
+		{platforms, "other - in house", otherBucket},
+	}
+	for _, tt := range tests {
+		if got := bucketValue(tt.field, tt.value); got != tt.want {
+			t.Errorf("bucketValue(%s, %q) = %q, want %q", tt.field.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetMetricsDistributions(t *testing.T) {
+	openTestDB(t)
+	submitTestSurvey(t, `{"role":"Developer","cmsUsage":"Yes","platforms":"Netlify, Vercel","teamSize":"1-5","features":{"offline":4}}`)
+	submitTestSurvey(t, `{"role":"developer","cmsUsage":"No","platforms":"netlify;netlify","teamSize":"1-5","features":{"offline":2}}`)
+	submitTestSurvey(t, `{"role":"Other: founder","cmsUsage":"Yes","platforms":"","teamSize":"6-20","language":"de"}`)
+
+	recorder := callHandler(getMetrics, "GET", "/metrics", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("getMetrics returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var metrics Metrics
+	if err := json.Unmarshal(recorder.Body.Bytes(), &metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics.TotalResponses != 3 {
+		t.Errorf("TotalResponses = %d, want 3", metrics.TotalResponses)
+	}
+	if want := map[string]int{"Developer": 2, otherBucket: 1}; !reflect.DeepEqual(metrics.RoleDistribution, want) {
+		t.Errorf("RoleDistribution = %v, want %v", metrics.RoleDistribution, want)
+	}
+	if want := map[string]int{"Yes": 2, "No": 1}; !reflect.DeepEqual(metrics.CmsUsageDistribution, want) {
+		t.Errorf("CmsUsageDistribution = %v, want %v", metrics.CmsUsageDistribution, want)
+	}
+	// A value repeated within one answer is only counted once for that response
+	if want := map[string]int{"netlify": 2, "vercel": 1}; !reflect.DeepEqual(metrics.Distributions["platforms"], want) {
+		t.Errorf("platforms = %v, want %v", metrics.Distributions["platforms"], want)
+	}
+	if want := map[string]int{"1-5": 2, "6-20": 1}; !reflect.DeepEqual(metrics.TeamSizeDistribution, want) {
+		t.Errorf("TeamSizeDistribution = %v, want %v", metrics.TeamSizeDistribution, want)
+	}
+	if want := map[string]int{"en": 2, "de": 1}; !reflect.DeepEqual(metrics.Distributions["languages"], want) {
+		t.Errorf("languages = %v, want %v", metrics.Distributions["languages"], want)
+	}
+}
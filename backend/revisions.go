@@ -0,0 +1,420 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FieldChange is a single field-level difference between two revisions.
+// Feature scores are reported as "features.<name>".
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// Revision is one entry in the history of a response. Data holds the full
+// response as it was after the change and is nil for deletions.
+type Revision struct {
+	Revision  int             `json:"revision"`
+	Action    string          `json:"action"`
+	Changes   []FieldChange   `json:"changes"`
+	Data      *SurveyResponse `json:"data"`
+	ChangedBy string          `json:"changedBy"`
+	ChangedAt time.Time       `json:"changedAt"`
+}
+
+func createRevisionsTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS survey_response_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		response_id TEXT NOT NULL,
+		revision INTEGER NOT NULL,
+		action TEXT NOT NULL DEFAULT 'updated',
+		data JSON NOT NULL,
+		changes JSON NOT NULL DEFAULT '[]',
+		changed_by TEXT NOT NULL,
+		changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (response_id, revision)
+	)`)
+	if err != nil {
+		return err
+	}
+	if err := ensureColumn("survey_response_revisions", "action", "TEXT NOT NULL DEFAULT 'updated'"); err != nil {
+		return err
+	}
+	return ensureColumn("survey_response_revisions", "changes", "JSON NOT NULL DEFAULT '[]'")
+}
+
+// changedBy identifies who is making a change: the admin username for
+// authenticated requests, otherwise the respondent
+func changedBy(c *gin.Context) string {
+	if username, ok := c.Get("username"); ok {
+		if name, ok := username.(string); ok && name != "" {
+			return name
+		}
+	}
+	return "respondent"
+}
+
+// responseFields flattens a response into comparable field values, leaving
+// out the fields that never change between revisions
+func responseFields(survey *SurveyResponse) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if survey == nil {
+		return fields
+	}
+
+	data, _ := json.Marshal(survey)
+	var raw map[string]interface{}
+	json.Unmarshal(data, &raw)
+
+	for key, value := range raw {
+		switch key {
+		case "id", "createdAt":
+			continue
+		case "features":
+			if features, ok := value.(map[string]interface{}); ok {
+				for name, score := range features {
+					fields["features."+name] = score
+				}
+			}
+		default:
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+func diffResponses(previous, current *SurveyResponse) []FieldChange {
+	before := responseFields(previous)
+	after := responseFields(current)
+
+	keys := make(map[string]bool)
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	changes := []FieldChange{}
+	for key := range keys {
+		if !reflect.DeepEqual(before[key], after[key]) {
+			changes = append(changes, FieldChange{Field: key, From: before[key], To: after[key]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+func insertRevision(tx *sql.Tx, responseID, action string, data *SurveyResponse, changes []FieldChange, by string) error {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO survey_response_revisions (response_id, revision, action, data, changes, changed_by, changed_at)
+		SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?, ?, ?
+		FROM survey_response_revisions WHERE response_id = ?`,
+		responseID, action, string(dataJSON), string(changesJSON), by, time.Now(), responseID)
+	return err
+}
+
+// redactRevisions strips the respondent's email address from every stored
+// revision of a response, so deleting a response purges their contact details
+// while the history of answers stays available for audit and revert
+func redactRevisions(tx *sql.Tx, responseID string) error {
+	rows, err := tx.Query(`SELECT id, data, changes FROM survey_response_revisions WHERE response_id = ?`, responseID)
+	if err != nil {
+		return err
+	}
+	type revisionRow struct {
+		id      int64
+		data    *SurveyResponse
+		changes []FieldChange
+	}
+	var revisions []revisionRow
+	for rows.Next() {
+		var row revisionRow
+		var data, changes string
+		if err := rows.Scan(&row.id, &data, &changes); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := json.Unmarshal([]byte(data), &row.data); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := json.Unmarshal([]byte(changes), &row.changes); err != nil {
+			rows.Close()
+			return err
+		}
+		revisions = append(revisions, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, row := range revisions {
+		if row.data != nil {
+			row.data.Email = ""
+		}
+		for i := range row.changes {
+			if row.changes[i].Field == "email" {
+				row.changes[i].From, row.changes[i].To = nil, nil
+			}
+		}
+		data, err := json.Marshal(row.data)
+		if err != nil {
+			return err
+		}
+		changes, err := json.Marshal(row.changes)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE survey_response_revisions SET data = ?, changes = ? WHERE id = ?`,
+			string(data), string(changes), row.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRevision stores the new state of a response along with its diff
+// against the previous state. Responses submitted before history was kept
+// get their original state recorded first so the diff has something to
+// revert to.
+func recordRevision(tx *sql.Tx, previous *SurveyResponse, current SurveyResponse, by, action string) error {
+	if previous != nil {
+		var count int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM survey_response_revisions WHERE response_id = ?`, current.ID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			if err := insertRevision(tx, current.ID, "original", previous, diffResponses(nil, previous), "system"); err != nil {
+				return err
+			}
+		}
+	}
+	return insertRevision(tx, current.ID, action, &current, diffResponses(previous, &current), by)
+}
+
+func getResponseRevisions(responseID string) ([]Revision, error) {
+	rows, err := db.Query(`SELECT revision, action, data, changes, changed_by, changed_at
+		FROM survey_response_revisions WHERE response_id = ? ORDER BY revision`, responseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		var revision Revision
+		var data, changes string
+		if err := rows.Scan(&revision.Revision, &revision.Action, &data, &changes,
+			&revision.ChangedBy, &revision.ChangedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &revision.Data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(changes), &revision.Changes); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func getResultHistory(c *gin.Context) {
+	revisions, err := getResponseRevisions(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(revisions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no history for this response"})
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+func updateResult(c *gin.Context) {
+	previous, err := getSurveyResponse(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "response not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var survey SurveyResponse
+	if err := c.BindJSON(&survey); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateSurveyResponse(&survey); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	survey.ID = previous.ID
+	survey.CreatedAt = previous.CreatedAt
+	survey.Language = previous.Language
+	canonicalizeOptions(&survey, survey.Language)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := updateSurveyResponse(tx, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := scoreSubmission(tx, &survey, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordRevision(tx, &previous, survey, changedBy(c), "updated"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := enqueueBetaSignup(tx, &previous, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := syncWaitlist(tx, survey.ID, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := applyMetricsDelta(tx, &previous, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordResponseEvent(tx, streamResponseUpdated, &previous, &survey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, survey)
+}
+
+// revertResult restores a response to the state recorded in an earlier
+// revision. Reverting a deleted response re-creates it, without the email
+// address that was purged on deletion.
+func revertResult(c *gin.Context) {
+	id := c.Param("id")
+	var req struct {
+		Revision int `json:"revision"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var data string
+	err := db.QueryRow(`SELECT data FROM survey_response_revisions WHERE response_id = ? AND revision = ?`,
+		id, req.Revision).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "revision not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var target *SurveyResponse
+	if err := json.Unmarshal([]byte(data), &target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if target == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot revert to a deletion"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	previous, err := responseInTx(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if previous != nil {
+		err = updateSurveyResponse(tx, target)
+	} else {
+		err = insertSurveyResponse(tx, target)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// A re-created response goes through the same checks and notifications
+	// as a fresh submission
+	if previous == nil {
+		if err := scoreSubmission(tx, target, ""); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := enqueueWebhook(tx, webhookResponseCreated, *target); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := enqueueBetaSignup(tx, previous, target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := insertRevision(tx, id, "reverted", target, diffResponses(previous, target), changedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	eventType := streamResponseUpdated
+	if previous == nil {
+		eventType = streamResponseCreated
+	}
+	if err := applyMetricsDelta(tx, previous, target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recordResponseEvent(tx, eventType, previous, target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := syncWaitlist(tx, id, target); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, target)
+}
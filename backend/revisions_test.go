@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDiffResponses(t *testing.T) {
+	before := &SurveyResponse{ID: "a", Role: "developer", CmsUsage: "wordpress", Features: Features{Offline: 3}}
+	after := &SurveyResponse{ID: "a", Role: "designer", CmsUsage: "wordpress", Features: Features{Offline: 5}}
+
+	got := diffResponses(before, after)
+	want := []FieldChange{
+		{Field: "features.offline", From: float64(3), To: float64(5)},
+		{Field: "role", From: "developer", To: "designer"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffResponses() = %+v, want %+v", got, want)
+	}
+	if changes := diffResponses(before, before); len(changes) != 0 {
+		t.Errorf("diffResponses(same) = %+v, want no changes", changes)
+	}
+	for _, change := range diffResponses(nil, after) {
+		if change.From != nil {
+			t.Errorf("creation diff has a previous value: %+v", change)
+		}
+	}
+}
+
+func TestRevisionHistoryAndRevert(t *testing.T) {
+	openTestDB(t)
+	submitted := submitTestSurvey(t, `{"role":"developer","cmsUsage":"wordpress"}`)
+	id := gin.Param{Key: "id", Value: submitted.ID}
+
+	recorder := callHandler(updateResult, "PUT", "/results/"+submitted.ID,
+		strings.NewReader(`{"role":"designer","cmsUsage":"wordpress"}`), id)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("updateResult returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = callHandler(deleteResult, "DELETE", "/results/"+submitted.ID, nil, id)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("deleteResult returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = callHandler(getResultHistory, "GET", "/results/"+submitted.ID+"/history", nil, id)
+	var history []Revision
+	if err := json.Unmarshal(recorder.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, revision := range history {
+		actions = append(actions, revision.Action)
+	}
+	if want := []string{"created", "updated", "deleted"}; !reflect.DeepEqual(actions, want) {
+		t.Fatalf("history actions = %v, want %v", actions, want)
+	}
+	if changes := history[1].Changes; len(changes) != 1 || changes[0].Field != "role" {
+		t.Errorf("update changes = %+v, want only role", changes)
+	}
+
+	recorder = callHandler(revertResult, "POST", "/results/"+submitted.ID+"/revert",
+		strings.NewReader(`{"revision":3}`), id)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("reverting to the deletion returned %d, want 400", recorder.Code)
+	}
+	recorder = callHandler(revertResult, "POST", "/results/"+submitted.ID+"/revert",
+		strings.NewReader(`{"revision":1}`), id)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("revertResult returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	restored, err := getSurveyResponse(submitted.ID)
+	if err != nil {
+		t.Fatalf("deleted response was not re-created: %v", err)
+	}
+	if restored.Role != "developer" {
+		t.Errorf("restored role = %q, want the original developer", restored.Role)
+	}
+}
+
+func TestUpdateResultCanonicalizesAndScores(t *testing.T) {
+	openTestDB(t)
+	submitted := submitTestSurvey(t, `{"role":"developer","cmsUsage":"wordpress","language":"fr"}`)
+
+	// Admin edits go through the same option mapping and spam scoring as
+	// respondent edits
+	body := `{"role":"Rédacteur·rice","cmsUsage":"Oui","biggestFrustrations":"asdfgh qwrtzp sdfsdf lkjhgf xcvbnm"}`
+	recorder := callHandler(updateResult, "PUT", "/results/"+submitted.ID, strings.NewReader(body),
+		gin.Param{Key: "id", Value: submitted.ID})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("updateResult returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	stored, err := getSurveyResponse(submitted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Role != "Content Editor" || stored.CmsUsage != "Yes" {
+		t.Errorf("stored role %q cmsUsage %q, want the canonical values", stored.Role, stored.CmsUsage)
+	}
+	if score, reasons := spamScore(t, submitted.ID); score == 0 || len(reasons) == 0 {
+		t.Errorf("edited response scored %v %v, want the gibberish flagged", score, reasons)
+	}
+}
+
+// seedRevisedResponse stores a response with a created and an updated revision
+func seedRevisedResponse(t *testing.T) SurveyResponse {
+	t.Helper()
+	survey := SurveyResponse{
+		ID:                  "resp-1",
+		Role:                "developer",
+		CmsUsage:            "wordpress",
+		BiggestFrustrations: "Publishing drafts offline is painful because the editor loses images and links when the connection drops",
+		BetaInterest:        true,
+		Email:               "ada@example.com",
+		CreatedAt:           time.Now().Add(-time.Hour),
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := insertSurveyResponse(tx, &survey); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordRevision(tx, nil, survey, "respondent", "created"); err != nil {
+		t.Fatal(err)
+	}
+	updated := survey
+	updated.Email = "ada@example.org"
+	if err := updateSurveyResponse(tx, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordRevision(tx, &survey, updated, "admin", "updated"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return updated
+}
+
+func deleteTestResponse(t *testing.T, id string) {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, _, err := deleteResponse(tx, id, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteRedactsRevisions(t *testing.T) {
+	openTestDB(t)
+	survey := seedRevisedResponse(t)
+	deleteTestResponse(t, survey.ID)
+
+	rows, err := db.Query(`SELECT action, data, changes FROM survey_response_revisions WHERE response_id = ?`, survey.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var action, data, changes string
+		if err := rows.Scan(&action, &data, &changes); err != nil {
+			t.Fatal(err)
+		}
+		count++
+		if strings.Contains(data, "ada@") || strings.Contains(changes, "ada@") {
+			t.Errorf("%s revision still holds the email: data=%s changes=%s", action, data, changes)
+		}
+	}
+	if count != 3 {
+		t.Errorf("got %d revisions, want created, updated and deleted", count)
+	}
+}
+
+func TestRevertDeletedResponse(t *testing.T) {
+	openTestDB(t)
+	survey := seedRevisedResponse(t)
+	deleteTestResponse(t, survey.ID)
+	_, err := db.Exec(`INSERT INTO webhook_endpoints (id, url, secret, events, created_at, updated_at)
+		VALUES ('hook', 'http://example.com', 's', '["response.created"]', ?, ?)`, time.Now(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := callHandler(revertResult, "POST", "/results/"+survey.ID+"/revert",
+		strings.NewReader(`{"revision":1}`), gin.Param{Key: "id", Value: survey.ID})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("revert returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	restored, err := getSurveyResponse(survey.ID)
+	if err != nil {
+		t.Fatalf("response was not re-created: %v", err)
+	}
+	if restored.Email != "" {
+		t.Errorf("restored email = %q, want it to stay purged", restored.Email)
+	}
+	var deliveries int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries WHERE event = 'response.created'`).Scan(&deliveries); err != nil {
+		t.Fatal(err)
+	}
+	if deliveries != 1 {
+		t.Errorf("got %d response.created deliveries, want 1", deliveries)
+	}
+	var scored bool
+	if err := db.QueryRow(`SELECT text_simhash IS NOT NULL FROM survey_responses WHERE id = ?`, survey.ID).Scan(&scored); err != nil {
+		t.Fatal(err)
+	}
+	if !scored {
+		t.Error("re-created response was not scored for spam")
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errSurveyNotOpen = errors.New("survey is not open yet")
+	errSurveyClosed  = errors.New("survey closed")
+	errQuotaFull     = errors.New("quota full")
+)
+
+// SurveySchedule controls when the public survey accepts responses. Quotas
+// cap the number of responses per Role segment; a quota on "*" caps the total.
+type SurveySchedule struct {
+	OpensAt  *time.Time     `json:"opensAt"`
+	ClosesAt *time.Time     `json:"closesAt"`
+	Quotas   map[string]int `json:"quotas"`
+}
+
+type QuotaStatus struct {
+	Segment   string `json:"segment"`
+	Limit     int    `json:"limit"`
+	Responses int    `json:"responses"`
+	Full      bool   `json:"full"`
+}
+
+type SurveyStatus struct {
+	Open     bool          `json:"open"`
+	Reason   string        `json:"reason,omitempty"`
+	OpensAt  *time.Time    `json:"opensAt,omitempty"`
+	ClosesAt *time.Time    `json:"closesAt,omitempty"`
+	Quotas   []QuotaStatus `json:"quotas"`
+}
+
+func createScheduleTables() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS survey_schedule (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		opens_at TIMESTAMP,
+		closes_at TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS survey_quotas (
+		segment TEXT PRIMARY KEY,
+		max_responses INTEGER NOT NULL
+	)`)
+	return err
+}
+
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func loadSchedule(q queryer) (SurveySchedule, error) {
+	schedule := SurveySchedule{Quotas: make(map[string]int)}
+	err := q.QueryRow(`SELECT opens_at, closes_at FROM survey_schedule WHERE id = 1`).
+		Scan(&schedule.OpensAt, &schedule.ClosesAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return schedule, err
+	}
+
+	rows, err := q.Query(`SELECT segment, max_responses FROM survey_quotas`)
+	if err != nil {
+		return schedule, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var segment string
+		var limit int
+		if err := rows.Scan(&segment, &limit); err != nil {
+			return schedule, err
+		}
+		schedule.Quotas[segment] = limit
+	}
+	return schedule, rows.Err()
+}
+
+// countSegment returns the number of stored responses in a Role segment,
+// where "*" counts every response
+func countSegment(q queryer, segment string) (int, error) {
+	var count int
+	var err error
+	if segment == "*" {
+		err = q.QueryRow(`SELECT COUNT(*) FROM survey_responses`).Scan(&count)
+	} else {
+		err = q.QueryRow(`SELECT COUNT(*) FROM survey_responses WHERE role = ?`, segment).Scan(&count)
+	}
+	return count, err
+}
+
+func scheduleWindowError(schedule SurveySchedule, now time.Time) error {
+	if schedule.OpensAt != nil && now.Before(*schedule.OpensAt) {
+		return errSurveyNotOpen
+	}
+	if schedule.ClosesAt != nil && !now.Before(*schedule.ClosesAt) {
+		return errSurveyClosed
+	}
+	return nil
+}
+
+// checkSurveyAvailability is run inside the submission transaction after the
+// new row has been inserted, so the counts include it and two concurrent
+// submissions can't both take the last slot
+func checkSurveyAvailability(tx *sql.Tx, survey *SurveyResponse) error {
+	schedule, err := loadSchedule(tx)
+	if err != nil {
+		return err
+	}
+	if err := scheduleWindowError(schedule, time.Now()); err != nil {
+		return err
+	}
+
+	for _, segment := range []string{"*", survey.Role} {
+		limit, ok := schedule.Quotas[segment]
+		if !ok {
+			continue
+		}
+		count, err := countSegment(tx, segment)
+		if err != nil {
+			return err
+		}
+		if count > limit {
+			return errQuotaFull
+		}
+	}
+	return nil
+}
+
+func isAvailabilityError(err error) bool {
+	return errors.Is(err, errSurveyNotOpen) || errors.Is(err, errSurveyClosed) || errors.Is(err, errQuotaFull)
+}
+
+func buildSurveyStatus() (SurveyStatus, error) {
+	schedule, err := loadSchedule(db)
+	if err != nil {
+		return SurveyStatus{}, err
+	}
+
+	status := SurveyStatus{
+		Open:     true,
+		OpensAt:  schedule.OpensAt,
+		ClosesAt: schedule.ClosesAt,
+		Quotas:   []QuotaStatus{},
+	}
+	if err := scheduleWindowError(schedule, time.Now()); err != nil {
+		status.Open = false
+		status.Reason = err.Error()
+	}
+
+	for segment, limit := range schedule.Quotas {
+		count, err := countSegment(db, segment)
+		if err != nil {
+			return SurveyStatus{}, err
+		}
+		quota := QuotaStatus{Segment: segment, Limit: limit, Responses: count, Full: count >= limit}
+		status.Quotas = append(status.Quotas, quota)
+		// Segment quotas only close the survey for that Role, so only the
+		// overall quota closes it for everyone
+		if segment == "*" && quota.Full && status.Open {
+			status.Open = false
+			status.Reason = errQuotaFull.Error()
+		}
+	}
+	sort.Slice(status.Quotas, func(i, j int) bool { return status.Quotas[i].Segment < status.Quotas[j].Segment })
+
+	return status, nil
+}
+
+// getSurveyStatus is polled by the survey page to show a closed message
+func getSurveyStatus(c *gin.Context) {
+	status, err := buildSurveyStatus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func getSchedule(c *gin.Context) {
+	schedule, err := loadSchedule(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// updateSchedule replaces the open/close window and all quota rules
+func updateSchedule(c *gin.Context) {
+	var schedule SurveySchedule
+	if err := c.BindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if schedule.OpensAt != nil && schedule.ClosesAt != nil && !schedule.ClosesAt.After(*schedule.OpensAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "closesAt must be after opensAt"})
+		return
+	}
+	for segment, limit := range schedule.Quotas {
+		if segment == "" || limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quotas need a segment and a non-negative limit"})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO survey_schedule (id, opens_at, closes_at) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET opens_at = excluded.opens_at, closes_at = excluded.closes_at`,
+		schedule.OpensAt, schedule.ClosesAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM survey_quotas`); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for segment, limit := range schedule.Quotas {
+		if _, err := tx.Exec(`INSERT INTO survey_quotas (segment, max_responses) VALUES (?, ?)`, segment, limit); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if schedule.Quotas == nil {
+		schedule.Quotas = make(map[string]int)
+	}
+	c.JSON(http.StatusOK, schedule)
+}
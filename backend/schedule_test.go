@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleWindowError(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	before := now.Add(-time.Hour)
+	after := now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		schedule SurveySchedule
+		want     error
+	}{
+		{"no window", SurveySchedule{}, nil},
+		{"open", SurveySchedule{OpensAt: &before, ClosesAt: &after}, nil},
+		{"not open yet", SurveySchedule{OpensAt: &after}, errSurveyNotOpen},
+		{"closed", SurveySchedule{ClosesAt: &before}, errSurveyClosed},
+		{"closes now", SurveySchedule{ClosesAt: &now}, errSurveyClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scheduleWindowError(tt.schedule, now); got != tt.want {
+				t.Errorf("scheduleWindowError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// putTestSchedule replaces the schedule through the admin handler
+func putTestSchedule(t *testing.T, body string) {
+	t.Helper()
+	recorder := callHandler(updateSchedule, "PUT", "/schedule", strings.NewReader(body))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("updateSchedule returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestUpdateScheduleValidation(t *testing.T) {
+	openTestDB(t)
+	for _, body := range []string{
+		`{"opensAt":"2026-03-02T12:00:00Z","closesAt":"2026-03-01T12:00:00Z"}`,
+		`{"quotas":{"developer":-1}}`,
+		`{"quotas":{"":10}}`,
+	} {
+		recorder := callHandler(updateSchedule, "PUT", "/schedule", strings.NewReader(body))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("updateSchedule(%s) returned %d, want 400", body, recorder.Code)
+		}
+	}
+}
+
+func TestSubmissionQuotas(t *testing.T) {
+	openTestDB(t)
+	putTestSchedule(t, `{"quotas":{"designer":1,"*":2}}`)
+
+	submit := func(role string) int {
+		body := fmt.Sprintf(`{"role":%q,"cmsUsage":"wordpress"}`, role)
+		return callHandler(submitSurvey, "POST", "/survey", strings.NewReader(body)).Code
+	}
+
+	if code := submit("designer"); code != http.StatusCreated {
+		t.Fatalf("first designer returned %d, want 201", code)
+	}
+	if code := submit("designer"); code != http.StatusForbidden {
+		t.Errorf("second designer returned %d, want 403", code)
+	}
+
+	var status SurveyStatus
+	recorder := callHandler(getSurveyStatus, "GET", "/survey/status", nil)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	// A full segment quota only closes the survey for that Role
+	if !status.Open || len(status.Quotas) != 2 || status.Quotas[1].Segment != "designer" || !status.Quotas[1].Full {
+		t.Errorf("status after designer quota = %+v", status)
+	}
+
+	if code := submit("developer"); code != http.StatusCreated {
+		t.Fatalf("developer returned %d, want 201", code)
+	}
+	if code := submit("developer"); code != http.StatusForbidden {
+		t.Errorf("submission over the total quota returned %d, want 403", code)
+	}
+
+	recorder = callHandler(getSurveyStatus, "GET", "/survey/status", nil)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Open || status.Reason != errQuotaFull.Error() {
+		t.Errorf("status after total quota = %+v, want closed with %q", status, errQuotaFull)
+	}
+}
+
+func TestSubmissionOutsideWindow(t *testing.T) {
+	openTestDB(t)
+	closed := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	putTestSchedule(t, fmt.Sprintf(`{"closesAt":%q}`, closed))
+
+	recorder := callHandler(submitSurvey, "POST", "/survey", strings.NewReader(`{"role":"developer","cmsUsage":"wordpress"}`))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("submission after close returned %d, want 403", recorder.Code)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM survey_responses`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("stored %d responses after close, want the insert rolled back", count)
+	}
+}
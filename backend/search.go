@@ -0,0 +1,453 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	snippetTokens      = 12
+	snippetRunes       = 80
+	// Private-use markers around matches, swapped for <mark> after escaping
+	matchStart = "\ue000"
+	matchEnd   = "\ue001"
+)
+
+// searchColumn is a column of the search index. Name is what queries use to
+// scope a term, as in "frustrations:merge"; the field's JSON name works too.
+type searchColumn struct {
+	name   string
+	field  string
+	column string
+}
+
+var searchColumns = []searchColumn{
+	{"frustrations", "biggestFrustrations", "biggest_frustrations"},
+	{"problems", "specificProblems", "specific_problems"},
+	{"wishes", "wishedFeatures", "wished_features"},
+	{"formats", "customFormats", "custom_formats"},
+	{"feedback", "feedbackSuggestions", "feedback_suggestions"},
+	{"excitement", "excitementFactors", "excitement_factors"},
+	{"collaboration", "collaborationChallenges", "collaboration_challenges"},
+	{"workarounds", "offlineWorkarounds", "offline_workarounds"},
+	{"conflicts", "currentChangeConflictHandling", "current_change_conflict_handling"},
+	{"versioncontrol", "versionControlChallenges", "version_control_challenges"},
+	{"purpose", "primaryPurpose", "primary_purpose"},
+	{"platforms", "platforms", "platforms"},
+	{"integrations", "integrations", "integrations"},
+	{"contenttypes", "contentTypes", "content_types"},
+	{"otherrole", "otherRole", "other_role"},
+	{"othercms", "otherCmsUsage", "other_cms_usage"},
+}
+
+// ftsAvailable is false when SQLite was built without FTS5, in which case
+// search falls back to LIKE matching. Build with -tags sqlite_fts5 to enable it.
+var ftsAvailable bool
+
+// createSearchIndex sets up the FTS5 index and the triggers that keep it in
+// step with survey_responses. The index is filled from existing rows the
+// first time it is created.
+func createSearchIndex() error {
+	var exists int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'survey_search'`).Scan(&exists); err != nil {
+		return err
+	}
+
+	names := make([]string, len(searchColumns))
+	newValues := make([]string, len(searchColumns))
+	for i, col := range searchColumns {
+		names[i] = col.name
+		newValues[i] = "COALESCE(new." + col.column + ", '')"
+	}
+
+	_, err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS survey_search USING fts5(
+		response_id UNINDEXED, ` + strings.Join(names, ", ") + `,
+		tokenize = 'porter unicode61 remove_diacritics 2'
+	)`)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			log.Printf("SQLite was built without FTS5; search falls back to LIKE matching")
+			ftsAvailable = false
+			return nil
+		}
+		return err
+	}
+	ftsAvailable = true
+
+	insert := `INSERT INTO survey_search (response_id, ` + strings.Join(names, ", ") + `)
+		VALUES (new.id, ` + strings.Join(newValues, ", ") + `);`
+	triggers := []string{
+		`CREATE TRIGGER IF NOT EXISTS survey_search_insert AFTER INSERT ON survey_responses BEGIN ` +
+			insert + ` END`,
+		`CREATE TRIGGER IF NOT EXISTS survey_search_update AFTER UPDATE ON survey_responses BEGIN
+			DELETE FROM survey_search WHERE response_id = old.id; ` + insert + ` END`,
+		`CREATE TRIGGER IF NOT EXISTS survey_search_delete AFTER DELETE ON survey_responses BEGIN
+			DELETE FROM survey_search WHERE response_id = old.id; END`,
+	}
+	for _, trigger := range triggers {
+		if _, err := db.Exec(trigger); err != nil {
+			return err
+		}
+	}
+
+	if exists == 0 {
+		return fillSearchIndex()
+	}
+	return nil
+}
+
+func fillSearchIndex() error {
+	names := make([]string, len(searchColumns))
+	values := make([]string, len(searchColumns))
+	for i, col := range searchColumns {
+		names[i] = col.name
+		values[i] = "COALESCE(" + col.column + ", '')"
+	}
+	_, err := db.Exec(`INSERT INTO survey_search (response_id, ` + strings.Join(names, ", ") + `)
+		SELECT id, ` + strings.Join(values, ", ") + ` FROM survey_responses`)
+	return err
+}
+
+// rebuildSearchIndex recreates the index contents from survey_responses
+func rebuildSearchIndex(args []string) error {
+	if !ftsAvailable {
+		return fmt.Errorf("SQLite was built without FTS5; rebuild with -tags sqlite_fts5")
+	}
+	if _, err := db.Exec(`DELETE FROM survey_search`); err != nil {
+		return err
+	}
+	if err := fillSearchIndex(); err != nil {
+		return err
+	}
+	log.Printf("Rebuilt the search index")
+	return nil
+}
+
+var scopePrefixRegex = regexp.MustCompile(`(?i)\b([a-z]+):`)
+
+// normalizeSearchQuery lets queries scope terms by JSON field name as well as
+// by the short index column name
+func normalizeSearchQuery(q string) string {
+	return scopePrefixRegex.ReplaceAllStringFunc(q, func(prefix string) string {
+		name := strings.TrimSuffix(prefix, ":")
+		for _, col := range searchColumns {
+			if strings.EqualFold(name, col.field) || strings.EqualFold(name, col.name) {
+				return col.name + ":"
+			}
+		}
+		return prefix
+	})
+}
+
+// markSnippet escapes a snippet for HTML and turns the match markers into <mark> tags
+func markSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, matchStart, "<mark>")
+	return strings.ReplaceAll(escaped, matchEnd, "</mark>")
+}
+
+type SearchMatch struct {
+	Field   string `json:"field"`
+	Snippet string `json:"snippet"`
+}
+
+type SearchResult struct {
+	ResponseID string        `json:"responseId"`
+	Role       string        `json:"role"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	Score      float64       `json:"score"`
+	Matches    []SearchMatch `json:"matches"`
+}
+
+// searchResults handles GET /results/search. Queries use FTS5 syntax:
+// "exact phrases", prefix* matching, AND/OR/NOT and column scoping such as
+// frustrations:merge. Results are ranked by BM25 and carry HTML snippets with
+// the matches in <mark> tags. The usual segment filters apply.
+func searchResults(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	limit, offset, err := pagingParams(c, defaultSearchLimit, maxSearchLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := parseResponseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var results []SearchResult
+	var total int
+	mode := "fts5"
+	if ftsAvailable {
+		results, total, err = ftsSearch(normalizeSearchQuery(q), filter, limit, offset)
+		if err != nil && isSearchSyntaxError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid search query: %v", err)})
+			return
+		}
+	} else {
+		mode = "like"
+		results, total, err = likeSearch(q, filter, limit, offset)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":   q,
+		"mode":    mode,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+		"results": results,
+	})
+}
+
+// isSearchSyntaxError tells malformed MATCH queries apart from database failures
+func isSearchSyntaxError(err error) bool {
+	message := err.Error()
+	for _, fragment := range []string{"fts5", "no such column", "unterminated string", "unknown special query"} {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// pagingParams reads ?limit= and ?offset=
+func pagingParams(c *gin.Context, defaultLimit, maxLimit int) (int, int, error) {
+	limit, offset := defaultLimit, 0
+	if raw := c.Query("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+		limit = value
+	}
+	if raw := c.Query("offset"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative number")
+		}
+		offset = value
+	}
+	return limit, offset, nil
+}
+
+func ftsSearch(q string, filter responseFilter, limit, offset int) ([]SearchResult, int, error) {
+	scope := ` WHERE survey_search MATCH ?
+		AND s.response_id IN (SELECT id FROM survey_responses` + filter.where() + `)`
+	args := append([]interface{}{q}, filter.args...)
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM survey_search s`+scope, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	snippets := make([]string, len(searchColumns))
+	for i := range searchColumns {
+		// Column 0 is response_id
+		snippets[i] = fmt.Sprintf("snippet(survey_search, %d, '%s', '%s', '…', %d)", i+1, matchStart, matchEnd, snippetTokens)
+	}
+	rows, err := db.Query(`SELECT s.response_id, r.role, r.created_at, -bm25(survey_search), `+
+		strings.Join(snippets, ", ")+`
+		FROM survey_search s JOIN survey_responses r ON r.id = s.response_id`+scope+`
+		ORDER BY bm25(survey_search) LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		texts := make([]string, len(searchColumns))
+		dest := []interface{}{&result.ResponseID, &result.Role, &result.CreatedAt, &result.Score}
+		for i := range texts {
+			dest = append(dest, &texts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, 0, err
+		}
+		result.Score = roundTo(result.Score, 4)
+		result.Matches = []SearchMatch{}
+		for i, text := range texts {
+			if strings.Contains(text, matchStart) {
+				result.Matches = append(result.Matches, SearchMatch{Field: searchColumns[i].field, Snippet: markSnippet(text)})
+			}
+		}
+		results = append(results, result)
+	}
+	return results, total, rows.Err()
+}
+
+// searchTerm is one condition of a LIKE fallback query
+type searchTerm struct {
+	text   string
+	column *searchColumn
+}
+
+var searchTermRegex = regexp.MustCompile(`(?:([A-Za-z]+):)?(?:"([^"]*)"|(\S+))`)
+
+// parseLikeQuery approximates the FTS5 syntax for the fallback: phrases,
+// scoped terms and trailing * are understood, operators are ignored and all
+// terms must match
+func parseLikeQuery(q string) []searchTerm {
+	var terms []searchTerm
+	for _, match := range searchTermRegex.FindAllStringSubmatch(q, -1) {
+		text := match[2]
+		if text == "" {
+			text = match[3]
+		}
+		text = strings.TrimSuffix(text, "*")
+		if text == "" || text == "AND" || text == "OR" || text == "NOT" {
+			continue
+		}
+		term := searchTerm{text: strings.ToLower(text)}
+		for i, col := range searchColumns {
+			if match[1] != "" && (strings.EqualFold(match[1], col.name) || strings.EqualFold(match[1], col.field)) {
+				term.column = &searchColumns[i]
+			}
+		}
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// likeSnippet cuts the text around the first match and marks every term
+func likeSnippet(text string, terms []searchTerm) string {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// Offsets must line up between the two; give up on case folding
+		lower = text
+	}
+	first := -1
+	for _, term := range terms {
+		if i := strings.Index(lower, term.text); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	start := first
+	for n := 0; start > 0 && n < snippetRunes/2; n++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	end := first
+	for n := 0; end < len(text) && n < snippetRunes; n++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	window, windowLower := text[start:end], lower[start:end]
+	for i := 0; i < len(window); {
+		matched := 0
+		for _, term := range terms {
+			if strings.HasPrefix(windowLower[i:], term.text) && len(term.text) > matched {
+				matched = len(term.text)
+			}
+		}
+		if matched > 0 {
+			b.WriteString(matchStart + window[i:i+matched] + matchEnd)
+			i += matched
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(window[i:])
+		b.WriteString(window[i : i+size])
+		i += size
+	}
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func likeSearch(q string, filter responseFilter, limit, offset int) ([]SearchResult, int, error) {
+	terms := parseLikeQuery(q)
+	if len(terms) == 0 {
+		return []SearchResult{}, 0, nil
+	}
+
+	scoped := filter
+	for _, term := range terms {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term.text) + "%"
+		if term.column != nil {
+			scoped = scoped.with(term.column.column+` LIKE ? ESCAPE '\'`, pattern)
+			continue
+		}
+		conditions := make([]string, len(searchColumns))
+		args := make([]interface{}, len(searchColumns))
+		for i, col := range searchColumns {
+			conditions[i] = col.column + ` LIKE ? ESCAPE '\'`
+			args[i] = pattern
+		}
+		scoped = scoped.with("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM survey_responses`+scoped.where(), scoped.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	columns := make([]string, len(searchColumns))
+	for i, col := range searchColumns {
+		columns[i] = "COALESCE(" + col.column + ", '')"
+	}
+	rows, err := db.Query(`SELECT id, role, created_at, `+strings.Join(columns, ", ")+`
+		FROM survey_responses`+scoped.where()+` ORDER BY created_at DESC LIMIT ? OFFSET ?`,
+		append(scoped.args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		texts := make([]string, len(searchColumns))
+		dest := []interface{}{&result.ResponseID, &result.Role, &result.CreatedAt}
+		for i := range texts {
+			dest = append(dest, &texts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, 0, err
+		}
+		result.Matches = []SearchMatch{}
+		for i, text := range texts {
+			var columnTerms []searchTerm
+			for _, term := range terms {
+				if term.column == nil || term.column.name == searchColumns[i].name {
+					columnTerms = append(columnTerms, term)
+				}
+			}
+			if snippet := likeSnippet(text, columnTerms); snippet != "" {
+				result.Matches = append(result.Matches, SearchMatch{Field: searchColumns[i].field, Snippet: markSnippet(snippet)})
+			}
+		}
+		results = append(results, result)
+	}
+	return results, total, rows.Err()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeSearchQuery(t *testing.T) {
+	tests := map[string]string{
+		"biggestFrustrations:merge":              "frustrations:merge",
+		"Frustrations:merge OR problems:offline": "frustrations:merge OR problems:offline",
+		`"merge conflicts" unknown:term`:         `"merge conflicts" unknown:term`,
+		"versionControlChallenges:git*":          "versioncontrol:git*",
+	}
+	for q, want := range tests {
+		if got := normalizeSearchQuery(q); got != want {
+			t.Errorf("normalizeSearchQuery(%q) = %q, want %q", q, got, want)
+		}
+	}
+}
+
+func TestParseLikeQuery(t *testing.T) {
+	terms := parseLikeQuery(`"Merge Conflicts" AND frustrations:slow* NOT biggestFrustrations:git other:x`)
+	if len(terms) != 4 {
+		t.Fatalf("got %d terms, want 4: %+v", len(terms), terms)
+	}
+	if terms[0].text != "merge conflicts" || terms[0].column != nil {
+		t.Errorf("phrase term = %+v", terms[0])
+	}
+	if terms[1].text != "slow" || terms[1].column == nil || terms[1].column.name != "frustrations" {
+		t.Errorf("scoped prefix term = %+v", terms[1])
+	}
+	if terms[2].column == nil || terms[2].column.name != "frustrations" {
+		t.Errorf("term scoped by JSON name = %+v", terms[2])
+	}
+	// An unknown scope searches every column
+	if terms[3].text != "x" || terms[3].column != nil {
+		t.Errorf("unknown scope term = %+v", terms[3])
+	}
+}
+
+func TestLikeSnippet(t *testing.T) {
+	terms := []searchTerm{{text: "merge"}, {text: "sync"}}
+	got := markSnippet(likeSnippet("Merge <b>conflicts</b> after sync", terms))
+	want := "<mark>Merge</mark> &lt;b&gt;conflicts&lt;/b&gt; after <mark>sync</mark>"
+	if got != want {
+		t.Errorf("snippet = %q, want %q", got, want)
+	}
+	if likeSnippet("nothing to see", terms) != "" {
+		t.Error("snippet returned for text without a match")
+	}
+
+	long := "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor " +
+		"incididunt ut labore merge et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud " +
+		"exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat."
+	snippet := likeSnippet(long, terms)
+	if snippet[:len("…")] != "…" || snippet[len(snippet)-len("…"):] != "…" {
+		t.Errorf("long snippet %q is not cut on both sides", snippet)
+	}
+}
+
+type searchResponse struct {
+	Mode    string         `json:"mode"`
+	Total   int            `json:"total"`
+	Results []SearchResult `json:"results"`
+}
+
+func runTestSearch(t *testing.T, target string) searchResponse {
+	t.Helper()
+	recorder := callHandler(searchResults, "GET", target, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s returned %d: %s", target, recorder.Code, recorder.Body.String())
+	}
+	var result searchResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func seedSearchResponses(t *testing.T) {
+	t.Helper()
+	openTestDB(t)
+	for _, body := range []string{
+		`{"role":"Developer","biggestFrustrations":"Merge conflicts everywhere","specificProblems":"Offline sync"}`,
+		`{"role":"Designer","biggestFrustrations":"Slow previews","specificProblems":"Merge requests for copy"}`,
+		`{"role":"Developer","biggestFrustrations":"Pricing","wishedFeatures":"100% offline mode"}`,
+	} {
+		submitTestSurvey(t, body)
+	}
+}
+
+// searchModes runs a test against the FTS5 index, when SQLite has it, and
+// against the LIKE fallback
+func searchModes(t *testing.T, run func(t *testing.T, mode string)) {
+	t.Run("like", func(t *testing.T) {
+		previous := ftsAvailable
+		ftsAvailable = false
+		t.Cleanup(func() { ftsAvailable = previous })
+		run(t, "like")
+	})
+	t.Run("fts5", func(t *testing.T) {
+		if !ftsAvailable {
+			t.Skip("SQLite was built without FTS5; run the tests with -tags sqlite_fts5")
+		}
+		run(t, "fts5")
+	})
+}
+
+func TestSearchResults(t *testing.T) {
+	seedSearchResponses(t)
+	searchModes(t, func(t *testing.T, mode string) {
+		all := runTestSearch(t, "/results/search?q=merge")
+		if all.Mode != mode || all.Total != 2 || len(all.Results) != 2 {
+			t.Fatalf("merge = %+v", all)
+		}
+
+		scoped := runTestSearch(t, "/results/search?q=biggestFrustrations:merge")
+		if scoped.Total != 1 || len(scoped.Results[0].Matches) != 1 {
+			t.Fatalf("scoped merge = %+v", scoped)
+		}
+		match := scoped.Results[0].Matches[0]
+		if match.Field != "biggestFrustrations" || match.Snippet != "<mark>Merge</mark> conflicts everywhere" {
+			t.Errorf("match = %+v", match)
+		}
+
+		filtered := runTestSearch(t, "/results/search?q=merge&role=Designer")
+		if filtered.Total != 1 || filtered.Results[0].Role != "Designer" {
+			t.Errorf("merge among designers = %+v", filtered)
+		}
+
+		phrase := runTestSearch(t, `/results/search?q="merge+requests"`)
+		if phrase.Total != 1 {
+			t.Errorf("phrase search = %+v", phrase)
+		}
+
+		paged := runTestSearch(t, "/results/search?q=offline&limit=1&offset=1")
+		if paged.Total != 2 || len(paged.Results) != 1 {
+			t.Errorf("paged search = %+v", paged)
+		}
+	})
+}
+
+func TestSearchResultsErrors(t *testing.T) {
+	openTestDB(t)
+	for _, target := range []string{
+		"/results/search",
+		"/results/search?q=merge&limit=0",
+		"/results/search?q=merge&offset=-1",
+	} {
+		if recorder := callHandler(searchResults, "GET", target, nil); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, want 400", target, recorder.Code)
+		}
+	}
+	if ftsAvailable {
+		if recorder := callHandler(searchResults, "GET", `/results/search?q="unterminated`, nil); recorder.Code != http.StatusBadRequest {
+			t.Errorf("malformed FTS5 query returned %d, want 400", recorder.Code)
+		}
+	}
+}
+
+func TestSearchIndexFollowsEdits(t *testing.T) {
+	seedSearchResponses(t)
+	if !ftsAvailable {
+		if err := rebuildSearchIndex(nil); err == nil {
+			t.Error("rebuildSearchIndex succeeded without FTS5")
+		}
+		t.Skip("SQLite was built without FTS5; run the tests with -tags sqlite_fts5")
+	}
+
+	if _, err := db.Exec(`UPDATE survey_responses SET biggest_frustrations = 'Webhooks' WHERE role = 'Designer'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`DELETE FROM survey_responses WHERE wished_features LIKE '%offline%'`); err != nil {
+		t.Fatal(err)
+	}
+	if got := runTestSearch(t, "/results/search?q=webhooks").Total; got != 1 {
+		t.Errorf("edited answer found %d times, want 1", got)
+	}
+	if got := runTestSearch(t, "/results/search?q=offline").Total; got != 1 {
+		t.Errorf("offline found %d times after a delete, want 1", got)
+	}
+
+	if _, err := db.Exec(`DELETE FROM survey_search`); err != nil {
+		t.Fatal(err)
+	}
+	if err := rebuildSearchIndex(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := runTestSearch(t, "/results/search?q=merge").Total; got != 2 {
+		t.Errorf("merge found %d times after a rebuild, want 2", got)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+)
+
+// Scores between -neutralSentiment and neutralSentiment count as neutral
+const neutralSentiment = 0.05
+
+// sentimentWords rates words from -4 (very negative) to 4 (very positive).
+// The list leans towards how people talk about software. Words are matched as
+// written; sentimentForms lists the inflections that share an entry.
+var sentimentWords = map[string]float64{
+	// Negative
+	"annoying": -2, "awful": -3, "awkward": -1.5, "bad": -2.5, "broken": -2.5,
+	"bug": -1.5, "buggy": -2.5, "bloated": -2, "clunky": -2, "complain": -1.5,
+	"complicated": -1.5, "conflict": -1, "confusing": -2, "crash": -2.5, "cumbersome": -2,
+	"difficult": -1.5, "disappointing": -2, "disaster": -3.5, "expensive": -1.5,
+	"fail": -2, "failure": -2.5, "fragile": -1.5, "frustrating": -2.5,
+	"frustration": -2.5, "hard": -1, "hate": -3, "headache": -2,
+	"horrible": -3, "impossible": -2, "inconsistent": -1.5, "inefficient": -1.5,
+	"lack": -1.5, "lose": -2, "lost": -2, "mess": -2, "messy": -2,
+	"miss": -1, "nightmare": -3, "overwhelming": -1.5, "pain": -2.5,
+	"painful": -2.5, "poor": -2, "problem": -1.5, "problematic": -2,
+	"slow": -2, "sluggish": -2, "struggle": -2, "stuck": -1.5, "suck": -3,
+	"tedious": -2, "terrible": -3, "ugly": -2, "unclear": -1.5,
+	"unreliable": -2.5, "unusable": -3, "useless": -3, "waste": -2,
+	"worse": -2.5, "worst": -3.5, "wrong": -2,
+	// Positive
+	"amazing": 3, "awesome": 3, "beautiful": 2.5, "best": 3, "better": 2,
+	"clean": 1.5, "convenient": 2, "easy": 2, "effective": 2,
+	"efficient": 2, "elegant": 2.5, "enjoy": 2.5, "excellent": 3,
+	"excited": 2.5, "exciting": 2.5, "fantastic": 3, "fast": 2,
+	"flexible": 1.5, "good": 2, "great": 3, "happy": 2.5, "helpful": 2,
+	"improve": 1.5, "intuitive": 2, "love": 3, "nice": 2, "perfect": 3,
+	"pleasant": 2, "powerful": 2, "quick": 1.5, "reliable": 2,
+	"simple": 1.5, "smooth": 2, "solid": 1.5, "stable": 1.5,
+	"useful": 2, "wonderful": 3,
+}
+
+// sentimentNegators flip the words that follow them in the same phrase
+var sentimentNegators = map[string]bool{
+	"not": true, "no": true, "never": true, "nothing": true, "without": true,
+	"hardly": true, "don't": true, "doesn't": true, "didn't": true,
+	"isn't": true, "aren't": true, "wasn't": true, "weren't": true,
+	"can't": true, "cannot": true, "won't": true, "couldn't": true,
+}
+
+// sentimentContrasts end a negation early, as in "not bad but slow"
+var sentimentContrasts = map[string]bool{
+	"but": true, "however": true, "although": true, "though": true, "yet": true,
+}
+
+// sentimentModifiers scale the word directly after them
+var sentimentModifiers = map[string]float64{
+	"very": 1.5, "really": 1.5, "extremely": 1.75, "incredibly": 1.75,
+	"super": 1.5, "totally": 1.5, "so": 1.3, "too": 1.3,
+	"slightly": 0.5, "somewhat": 0.6, "bit": 0.6, "little": 0.6,
+}
+
+// Words after a negator that are still affected by it
+const negationScope = 3
+
+// sentimentForms maps lexicon words to their other inflections. They are
+// listed rather than stemmed, since stems merge unrelated words: "useful" and
+// "use" share the stem "us", and "helpful" and "help" share "help".
+var sentimentForms = map[string][]string{
+	"annoying":      {"annoyed", "annoys"},
+	"bug":           {"bugs"},
+	"complain":      {"complains", "complained", "complaining", "complaint", "complaints"},
+	"conflict":      {"conflicts"},
+	"confusing":     {"confused"},
+	"crash":         {"crashes", "crashed", "crashing"},
+	"disappointing": {"disappointed"},
+	"fail":          {"fails", "failed", "failing"},
+	"failure":       {"failures"},
+	"frustrating":   {"frustrated", "frustrates"},
+	"hate":          {"hates", "hated"},
+	"headache":      {"headaches"},
+	"lack":          {"lacks", "lacked", "lacking"},
+	"lose":          {"loses", "losing"},
+	"mess":          {"messes"},
+	"miss":          {"missed", "missing"},
+	"nightmare":     {"nightmares"},
+	"overwhelming":  {"overwhelmed"},
+	"pain":          {"pains"},
+	"problem":       {"problems"},
+	"slow":          {"slower", "slowly", "slowness"},
+	"struggle":      {"struggles", "struggled", "struggling"},
+	"suck":          {"sucks"},
+	"waste":         {"wastes", "wasted", "wasting"},
+	"clean":         {"cleaner"},
+	"easy":          {"easier", "easiest", "easily"},
+	"efficient":     {"efficiently"},
+	"enjoy":         {"enjoys", "enjoyed", "enjoying"},
+	"fast":          {"faster"},
+	"improve":       {"improves", "improved", "improvement", "improvements"},
+	"intuitive":     {"intuitively"},
+	"love":          {"loves", "loved"},
+	"perfect":       {"perfectly"},
+	"quick":         {"quicker", "quickly"},
+	"reliable":      {"reliably"},
+	"simple":        {"simpler"},
+	"smooth":        {"smoothly"},
+}
+
+// sentimentLexicon holds every surface form that carries a score
+var sentimentLexicon = make(map[string]float64)
+
+func init() {
+	for word, score := range sentimentWords {
+		sentimentLexicon[word] = score
+		for _, form := range sentimentForms[word] {
+			sentimentLexicon[form] = score
+		}
+	}
+}
+
+// scoreSentiment rates a text between -1 (negative) and 1 (positive). The
+// lexicon is English only, so ok is false for other languages and for empty
+// answers, which then have no score rather than a misleading neutral one.
+func scoreSentiment(text, lang string) (score float64, ok bool) {
+	if lang != defaultLanguage || strings.TrimSpace(text) == "" {
+		return 0, false
+	}
+
+	var total float64
+	for _, phrase := range tokenizeText(text) {
+		negated := 0
+		modifier := 1.0
+		for _, word := range phrase {
+			if sentimentNegators[word] {
+				negated = negationScope
+				continue
+			}
+			if sentimentContrasts[word] {
+				negated = 0
+				continue
+			}
+			if scale, ok := sentimentModifiers[word]; ok {
+				modifier = scale
+				continue
+			}
+			if value, ok := sentimentLexicon[word]; ok {
+				value *= modifier
+				if negated > 0 {
+					// "not bad" is mildly positive rather than as good as "good"
+					value *= -0.5
+				}
+				total += value
+			}
+			modifier = 1
+			if negated > 0 {
+				negated--
+			}
+		}
+	}
+
+	// Squash the sum into -1..1 so long answers don't dominate averages
+	return total / math.Sqrt(total*total+15), true
+}
+
+func createSentimentTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS survey_response_sentiment (
+		response_id TEXT NOT NULL,
+		field TEXT NOT NULL,
+		score REAL NOT NULL,
+		PRIMARY KEY (response_id, field)
+	)`)
+	return err
+}
+
+// storeSentiment replaces the sentiment scores of a response's free-text answers
+func storeSentiment(tx *sql.Tx, survey *SurveyResponse) error {
+	if _, err := tx.Exec(`DELETE FROM survey_response_sentiment WHERE response_id = ?`, survey.ID); err != nil {
+		return err
+	}
+	for _, field := range freeTextFields {
+		score, ok := scoreSentiment(*field.answer(survey), survey.Language)
+		if !ok {
+			continue
+		}
+		_, err := tx.Exec(`INSERT INTO survey_response_sentiment (response_id, field, score) VALUES (?, ?, ?)`,
+			survey.ID, field.key, score)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SentimentStats summarises the scored answers to one free-text question
+type SentimentStats struct {
+	Responses int     `json:"responses"`
+	Average   float64 `json:"average"`
+	Positive  int     `json:"positive"`
+	Neutral   int     `json:"neutral"`
+	Negative  int     `json:"negative"`
+}
+
+// sentimentByField averages the stored scores of the filtered responses
+func sentimentByField(filter responseFilter) (map[string]SentimentStats, error) {
+	rows, err := db.Query(`
+		SELECT field, COUNT(*), AVG(score),
+			SUM(CASE WHEN score >= ? THEN 1 ELSE 0 END),
+			SUM(CASE WHEN score <= ? THEN 1 ELSE 0 END)
+		FROM survey_response_sentiment
+		WHERE response_id IN (SELECT id FROM survey_responses`+filter.where()+`)
+		GROUP BY field`,
+		append([]interface{}{neutralSentiment, -neutralSentiment}, filter.args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]SentimentStats)
+	for rows.Next() {
+		var field string
+		var s SentimentStats
+		if err := rows.Scan(&field, &s.Responses, &s.Average, &s.Positive, &s.Negative); err != nil {
+			return nil, err
+		}
+		s.Neutral = s.Responses - s.Positive - s.Negative
+		s.Average = roundTo(s.Average, 3)
+		stats[field] = s
+	}
+	return stats, rows.Err()
+}
+
+// backfillSentiment rescores every stored response, e.g. after the lexicon changes
+func backfillSentiment(args []string) error {
+	columns := make([]string, len(freeTextFields))
+	for i, field := range freeTextFields {
+		columns[i] = "COALESCE(" + field.column + ", '')"
+	}
+	rows, err := db.Query(`SELECT id, COALESCE(language, ''), ` + strings.Join(columns, ", ") + ` FROM survey_responses`)
+	if err != nil {
+		return err
+	}
+	var responses []SurveyResponse
+	for rows.Next() {
+		// Only the columns scoring needs are loaded
+		var response SurveyResponse
+		texts := make([]string, len(freeTextFields))
+		dest := []interface{}{&response.ID, &response.Language}
+		for i := range texts {
+			dest = append(dest, &texts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return err
+		}
+		for i, field := range freeTextFields {
+			*field.answer(&response) = texts[i]
+		}
+		responses = append(responses, response)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Scores of responses that no longer exist are dropped as well
+	if _, err := tx.Exec(`DELETE FROM survey_response_sentiment`); err != nil {
+		return err
+	}
+	for i := range responses {
+		if err := storeSentiment(tx, &responses[i]); err != nil {
+			return fmt.Errorf("error scoring response %s: %v", responses[i].ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Scored sentiment for %d responses", len(responses))
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestScoreSentiment(t *testing.T) {
+	tests := []struct {
+		text string
+		want int // sign of the score
+	}{
+		{"The editor is great", 1},
+		{"Slow, buggy and frustrating", -1},
+		{"Publishing is a page and a form", 0},
+		// Neutral words that share a stem with lexicon words
+		{"I use WordPress and we used it a lot", 0},
+		{"I need help", 0},
+		{"We are using it for power users", 0},
+		// Inflections listed in sentimentForms
+		{"It was easily set up", 1},
+		{"The editor crashed twice", -1},
+		{"Really useful and helpful", 1},
+		{"I'm frustrated with merge conflicts", -1},
+		// Negation and contrast
+		{"not bad", 1},
+		{"not good", -1},
+		{"not bad but slow", -1},
+		// A sentence break ends the negation
+		{"No. It is good", 1},
+	}
+	for _, tt := range tests {
+		score, ok := scoreSentiment(tt.text, defaultLanguage)
+		if !ok {
+			t.Errorf("scoreSentiment(%q) was not scored", tt.text)
+			continue
+		}
+		got := 0
+		if score > neutralSentiment {
+			got = 1
+		} else if score < -neutralSentiment {
+			got = -1
+		}
+		if got != tt.want {
+			t.Errorf("scoreSentiment(%q) = %.2f, want sign %d", tt.text, score, tt.want)
+		}
+	}
+}
+
+func TestScoreSentimentModifiers(t *testing.T) {
+	plain, _ := scoreSentiment("slow", defaultLanguage)
+	very, _ := scoreSentiment("very slow", defaultLanguage)
+	slightly, _ := scoreSentiment("slightly slow", defaultLanguage)
+	if !(very < plain && plain < slightly && slightly < 0) {
+		t.Errorf("very slow %.3f, slow %.3f, slightly slow %.3f are not ordered", very, plain, slightly)
+	}
+
+	long, _ := scoreSentiment("terrible awful horrible nightmare disaster worst useless", defaultLanguage)
+	if long <= -1 {
+		t.Errorf("long negative answer scored %.3f, want it kept above -1", long)
+	}
+}
+
+func TestScoreSentimentSkipsOtherLanguages(t *testing.T) {
+	if _, ok := scoreSentiment("Das ist gut", "de"); ok {
+		t.Error("German text was scored with the English lexicon")
+	}
+	if _, ok := scoreSentiment("   ", defaultLanguage); ok {
+		t.Error("blank text was scored")
+	}
+}
+
+func TestSentimentMetricsAndBackfill(t *testing.T) {
+	openTestDB(t)
+	submitTestSurvey(t, `{"role":"Developer","biggestFrustrations":"Everything is slow and buggy"}`)
+	submitTestSurvey(t, `{"role":"Developer","biggestFrustrations":"Honestly it is great"}`)
+	submitTestSurvey(t, `{"role":"Developer","biggestFrustrations":"Alles ist langsam","language":"de"}`)
+
+	readSentiment := func() SentimentStats {
+		t.Helper()
+		recorder := callHandler(getMetrics, "GET", "/metrics", nil)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("getMetrics returned %d: %s", recorder.Code, recorder.Body.String())
+		}
+		var metrics Metrics
+		if err := json.Unmarshal(recorder.Body.Bytes(), &metrics); err != nil {
+			t.Fatal(err)
+		}
+		return metrics.Sentiment["biggestFrustrations"]
+	}
+
+	// The German answer is left unscored
+	stats := readSentiment()
+	if stats.Responses != 2 || stats.Positive != 1 || stats.Negative != 1 || stats.Neutral != 0 {
+		t.Errorf("sentiment = %+v, want one positive and one negative answer", stats)
+	}
+
+	if _, err := db.Exec(`DELETE FROM survey_response_sentiment`); err != nil {
+		t.Fatal(err)
+	}
+	if err := backfillSentiment(nil); err != nil {
+		t.Fatal(err)
+	}
+	if backfilled := readSentiment(); backfilled != stats {
+		t.Errorf("backfilled sentiment = %+v, want %+v", backfilled, stats)
+	}
+}
+
+func TestRunCommandRejectsUnknownCommands(t *testing.T) {
+	if err := runCommand("drop-everything", nil); err == nil {
+		t.Error("runCommand accepted an unknown command")
+	}
+}
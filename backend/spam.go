@@ -0,0 +1,489 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Answers are compared for near-duplicates against this many recent responses
+	spamRecentResponses = 500
+	// Simhashes differing in at most this many of 64 bits count as near-duplicates
+	nearDuplicateBits = 3
+	// Texts shorter than this many words are too generic to call duplicates
+	minDuplicateWords = 8
+	maxSpamBulkDelete = 1000
+)
+
+var (
+	linkRegex   = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+	markupRegex = regexp.MustCompile(`(?i)(<a\s+href|\[url[=\]])`)
+)
+
+// Vowels of the Latin-script languages the survey is offered in, accented
+// forms included
+const latinVowels = "aeiouyàáâãäåæèéêëìíîïòóôõöøœùúûüýÿ"
+
+// Consonant runs this long are rare in real words; German manages five
+// ("Deutschland") and occasionally more
+const maxConsonantRun = 6
+
+// spamThreshold is the score at which a response counts as flagged
+func spamThreshold() float64 {
+	threshold, err := strconv.ParseFloat(getEnvWithFallback("SPAM_THRESHOLD", "0.5"), 64)
+	if err != nil || threshold <= 0 {
+		return 0.5
+	}
+	return threshold
+}
+
+// minCompletionTime is the quickest a person can plausibly fill in the survey
+func minCompletionTime() time.Duration {
+	seconds, err := strconv.Atoi(getEnvWithFallback("SPAM_MIN_COMPLETION_SECONDS", "20"))
+	if err != nil || seconds < 0 {
+		seconds = 20
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func createSpamColumns() error {
+	if err := ensureColumn("survey_responses", "spam_score", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn("survey_responses", "spam_reasons", "JSON NOT NULL DEFAULT '[]'"); err != nil {
+		return err
+	}
+	return ensureColumn("survey_responses", "text_simhash", "INTEGER")
+}
+
+// responseText joins all free-text answers of a response
+func responseText(survey *SurveyResponse) string {
+	var parts []string
+	for _, field := range freeTextFields {
+		if text := strings.TrimSpace(*field.answer(survey)); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// simhash fingerprints text from its overlapping three-word shingles, so
+// texts that differ by a few words get hashes that differ in a few bits
+func simhash(words []string) uint64 {
+	var weights [64]int
+	shingle := 3
+	if len(words) < shingle {
+		shingle = len(words)
+	}
+	for i := 0; i+shingle <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingle], " ")))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	var hash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash
+}
+
+func flattenTokens(text string) []string {
+	var words []string
+	for _, phrase := range tokenizeText(text) {
+		words = append(words, phrase...)
+	}
+	return words
+}
+
+// isGibberish spots keyboard mashing: most longer words have no vowels, long
+// consonant runs or the same character repeated. It takes the original text
+// because all-caps acronyms (HTML, SFTP) are skipped, as are words outside the
+// Latin script, where vowels can't be told apart this way.
+func isGibberish(text string) bool {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var checked, suspicious int
+	for _, word := range words {
+		if utf8.RuneCountInString(word) < 4 || isAcronym(word) || !isLatinWord(word) {
+			continue
+		}
+		word = strings.ToLower(word)
+		checked++
+		if !strings.ContainsAny(word, latinVowels) || consonantRun(word) >= maxConsonantRun || hasRepeatedRun(word, 4) {
+			suspicious++
+		}
+	}
+	return checked >= 3 && suspicious*2 >= checked
+}
+
+// isAcronym reports whether every letter in a word is upper case
+func isAcronym(word string) bool {
+	for _, r := range word {
+		if unicode.IsLetter(r) && !unicode.IsUpper(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isLatinWord(word string) bool {
+	for _, r := range word {
+		if unicode.IsLetter(r) && !unicode.Is(unicode.Latin, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// consonantRun returns the longest run of consonants in a lower-case word
+func consonantRun(word string) int {
+	longest, run := 0, 0
+	for _, r := range word {
+		if unicode.IsLetter(r) && !strings.ContainsRune(latinVowels, r) {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
+
+// hasRepeatedRun reports whether a character repeats n or more times in a row
+func hasRepeatedRun(word string, n int) bool {
+	var last rune
+	run := 0
+	for _, r := range word {
+		if r == last {
+			run++
+		} else {
+			last, run = r, 1
+		}
+		if run >= n {
+			return true
+		}
+	}
+	return false
+}
+
+// spamAssessment is the outcome of the spam checks for one submission
+type spamAssessment struct {
+	score   float64
+	reasons []string
+	simhash *int64
+}
+
+func (a *spamAssessment) add(weight float64, reason string) {
+	a.score += weight
+	a.reasons = append(a.reasons, reason)
+}
+
+// assessSpam scores a new submission from 0 (clean) to 1. sessionID links the
+// submission to the progress events of the form, which tell how long the
+// respondent took; without one the completion time is not checked.
+func assessSpam(tx *sql.Tx, survey *SurveyResponse, sessionID string, now time.Time) (spamAssessment, error) {
+	assessment := spamAssessment{reasons: []string{}}
+	text := responseText(survey)
+	// Links are counted separately; their fragments would read as gibberish
+	words := flattenTokens(linkRegex.ReplaceAllString(text, " "))
+
+	if len(words) >= minDuplicateWords {
+		hash := int64(simhash(words))
+		assessment.simhash = &hash
+
+		rows, err := tx.Query(`SELECT id, text_simhash FROM survey_responses
+			WHERE text_simhash IS NOT NULL AND id != ?
+			ORDER BY created_at DESC LIMIT ?`, survey.ID, spamRecentResponses)
+		if err != nil {
+			return assessment, err
+		}
+		var duplicateOf string
+		for rows.Next() {
+			var id string
+			var other int64
+			if err := rows.Scan(&id, &other); err != nil {
+				rows.Close()
+				return assessment, err
+			}
+			if bits.OnesCount64(uint64(hash^other)) <= nearDuplicateBits {
+				duplicateOf = id
+				break
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return assessment, err
+		}
+		if duplicateOf != "" {
+			assessment.add(0.5, fmt.Sprintf("near-duplicate of response %s", duplicateOf))
+		}
+	}
+
+	if email := strings.TrimSpace(survey.Email); email != "" {
+		var others int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM survey_responses WHERE LOWER(TRIM(email)) = LOWER(?) AND id != ?`,
+			email, survey.ID).Scan(&others)
+		if err != nil {
+			return assessment, err
+		}
+		if others > 0 {
+			assessment.add(0.4, fmt.Sprintf("email already used by %d other responses", others))
+		}
+	}
+
+	if isGibberish(linkRegex.ReplaceAllString(text, " ")) {
+		assessment.add(0.5, "free-text answers look like gibberish")
+	}
+
+	if sessionID != "" {
+		var elapsed sql.NullFloat64
+		err := tx.QueryRow(`SELECT (julianday(?) - MIN(julianday(created_at))) * 86400
+			FROM survey_progress WHERE session_id = ?`, now, sessionID).Scan(&elapsed)
+		if err != nil {
+			return assessment, err
+		}
+		if elapsed.Valid && elapsed.Float64 < minCompletionTime().Seconds() {
+			assessment.add(0.4, fmt.Sprintf("completed in %.0f seconds", elapsed.Float64))
+		}
+	}
+
+	links := len(linkRegex.FindAllString(text, -1))
+	switch {
+	case links >= 5:
+		assessment.add(0.6, fmt.Sprintf("contains %d links", links))
+	case links >= 2:
+		assessment.add(0.3, fmt.Sprintf("contains %d links", links))
+	}
+	if markupRegex.MatchString(text) {
+		assessment.add(0.3, "contains link markup")
+	}
+
+	if assessment.score > 1 {
+		assessment.score = 1
+	}
+	assessment.score = roundTo(assessment.score, 2)
+	return assessment, nil
+}
+
+// scoreSubmission runs the spam checks and stores the result with the response
+func scoreSubmission(tx *sql.Tx, survey *SurveyResponse, sessionID string) error {
+	assessment, err := assessSpam(tx, survey, sessionID, time.Now())
+	if err != nil {
+		return err
+	}
+	reasons, err := json.Marshal(assessment.reasons)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE survey_responses SET spam_score = ?, spam_reasons = ?, text_simhash = ? WHERE id = ?`,
+		assessment.score, string(reasons), assessment.simhash, survey.ID)
+	return err
+}
+
+type SpamFlag struct {
+	ResponseID string    `json:"responseId"`
+	Role       string    `json:"role"`
+	Email      string    `json:"email,omitempty"`
+	Score      float64   `json:"score"`
+	Reasons    []string  `json:"reasons"`
+	Excerpt    string    `json:"excerpt"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// getSpamFlags lists responses scoring at least ?minScore= (the configured
+// threshold by default), highest first
+func getSpamFlags(c *gin.Context) {
+	minScore := spamThreshold()
+	if raw := c.Query("minScore"); raw != "" {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value < 0 || value > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "minScore must be between 0 and 1"})
+			return
+		}
+		minScore = value
+	}
+	filter, err := parseResponseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter = filter.with("spam_score >= ?", minScore)
+
+	columns := make([]string, len(freeTextFields))
+	for i, field := range freeTextFields {
+		columns[i] = "COALESCE(" + field.column + ", '')"
+	}
+	rows, err := db.Query(`SELECT id, role, COALESCE(email, ''), spam_score, spam_reasons, created_at, `+
+		strings.Join(columns, ", ")+` FROM survey_responses`+filter.where()+`
+		ORDER BY spam_score DESC, created_at DESC`, filter.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	flags := []SpamFlag{}
+	for rows.Next() {
+		var flag SpamFlag
+		var reasons string
+		var response SurveyResponse
+		dest := []interface{}{&flag.ResponseID, &flag.Role, &flag.Email, &flag.Score, &reasons, &flag.CreatedAt}
+		for _, field := range freeTextFields {
+			dest = append(dest, field.answer(&response))
+		}
+		if err := rows.Scan(dest...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := json.Unmarshal([]byte(reasons), &flag.Reasons); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		flag.Excerpt = excerpt(responseText(&response))
+		flags = append(flags, flag)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, flags)
+}
+
+// clearSpamFlag marks a response as reviewed and genuine
+func clearSpamFlag(c *gin.Context) {
+	result, err := db.Exec(`UPDATE survey_responses SET spam_score = 0, spam_reasons = '[]' WHERE id = ?`, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "response not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Spam flag cleared"})
+}
+
+// queryIDs reads a single column of ids
+func queryIDs(q queryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// removeSpam deletes flagged responses in bulk: either the listed ids, all of
+// which must be flagged, or every response scoring at least minScore
+func removeSpam(c *gin.Context) {
+	var req struct {
+		IDs      []string `json:"ids"`
+		MinScore *float64 `json:"minScore"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (len(req.IDs) == 0) == (req.MinScore == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provide either ids or minScore"})
+		return
+	}
+	if req.MinScore != nil && (*req.MinScore <= 0 || *req.MinScore > 1) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "minScore must be above 0 and at most 1"})
+		return
+	}
+	if len(req.IDs) > maxSpamBulkDelete {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d responses can be removed at once", maxSpamBulkDelete)})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	// Selecting in the transaction keeps a response rescored in the meantime
+	// from being removed on a stale score
+	ids := req.IDs
+	if req.MinScore != nil {
+		ids, err = queryIDs(tx, `SELECT id FROM survey_responses WHERE spam_score >= ?`, *req.MinScore)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(ids) > maxSpamBulkDelete {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d responses can be removed at once", maxSpamBulkDelete)})
+			return
+		}
+	} else {
+		args := []interface{}{spamThreshold()}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		unflagged, err := queryIDs(tx, `SELECT id FROM survey_responses WHERE spam_score < ? AND id IN (?`+
+			strings.Repeat(", ?", len(ids)-1)+`)`, args...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(unflagged) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "responses are not flagged as spam: " + strings.Join(unflagged, ", ")})
+			return
+		}
+	}
+
+	removed := []string{}
+	var attachmentKeys []string
+	for _, id := range ids {
+		keys, deleted, err := deleteResponse(tx, id, changedBy(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if deleted {
+			removed = append(removed, id)
+			attachmentKeys = append(attachmentKeys, keys...)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	deleteStoredFiles(attachmentKeys)
+
+	c.JSON(http.StatusOK, gin.H{"removed": removed})
+}
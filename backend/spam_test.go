@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIsGibberish(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want bool
+	}{
+		{"english answer", "The editor keeps crashing when I upload large images, and syncing drafts between laptops is slow", false},
+		{"english acronyms", "We publish HTML and JSON over HTTPS from our CI; PDFs go through SFTP", false},
+		{"german acronyms", "HTML und SFTP über FTPS", false},
+		{"german umlauts", "Rechtschreibprüfung für Stück und Glück", false},
+		{"german compounds", "Die Rechtschreibprüfung funktioniert offline nicht, und in Deutschland braucht man strengere Datenschutzeinstellungen", false},
+		{"french answer", "Le gestionnaire de contenu plante souvent quand j'exporte des fichiers PDF volumineux vers l'équipe", false},
+		{"french accents", "Très énervé: être obligé d'écrire hors ligne sans aperçu", false},
+		{"cyrillic answer", "Редактор часто зависает при загрузке больших изображений", false},
+		{"too few long words", "sdfghjk ok", false},
+		{"keyboard mashing", "asdfgh qwrtzp sdfsdf lkjhgf xcvbnm", true},
+		{"repeated letters", "aaaaaa ok bbbbbbb fine cccccccc", true},
+		{"mashing with a real word", "sdfghjk please wrtzpl bcdfgh", true},
+	}
+	for _, tt := range tests {
+		if got := isGibberish(tt.text); got != tt.want {
+			t.Errorf("%s: isGibberish(%q) = %v, want %v", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestConsonantRun(t *testing.T) {
+	tests := []struct {
+		word string
+		want int
+	}{
+		{"deutschland", 5},
+		{"rechtschreibprüfung", 7},
+		{"glück", 2},
+		{"équipe", 1},
+		{"sdfghjk", 7},
+	}
+	for _, tt := range tests {
+		if got := consonantRun(tt.word); got != tt.want {
+			t.Errorf("consonantRun(%q) = %d, want %d", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestSimhash(t *testing.T) {
+	words := func(text string) []string { return flattenTokens(text) }
+	original := words("The editor keeps crashing whenever I upload large images, and syncing drafts between my laptop and desktop takes forever")
+	nearCopy := words("The editor keeps crashing whenever I upload large images, and syncing drafts between my laptop and desktop takes ages")
+	unrelated := words("Our agency needs per-client billing, granular roles for freelancers and a way to schedule posts across several sites")
+
+	if simhash(original) != simhash(append([]string(nil), original...)) {
+		t.Error("identical texts hash differently")
+	}
+	if d := bits.OnesCount64(simhash(original) ^ simhash(nearCopy)); d > nearDuplicateBits {
+		t.Errorf("near-copy differs in %d bits, want at most %d", d, nearDuplicateBits)
+	}
+	if d := bits.OnesCount64(simhash(original) ^ simhash(unrelated)); d <= nearDuplicateBits {
+		t.Errorf("unrelated text differs in only %d bits, would count as a duplicate", d)
+	}
+}
+
+// spamScore reads the stored score and reasons of a response
+func spamScore(t *testing.T, id string) (float64, []string) {
+	t.Helper()
+	var score float64
+	var raw string
+	if err := db.QueryRow(`SELECT spam_score, spam_reasons FROM survey_responses WHERE id = ?`, id).Scan(&score, &raw); err != nil {
+		t.Fatal(err)
+	}
+	var reasons []string
+	if err := json.Unmarshal([]byte(raw), &reasons); err != nil {
+		t.Fatal(err)
+	}
+	return score, reasons
+}
+
+const spamTestAnswer = "The editor keeps crashing whenever I upload large images, and syncing drafts between my laptop and desktop takes forever"
+
+func TestSubmissionSpamScores(t *testing.T) {
+	openTestDB(t)
+
+	first := submitTestSurvey(t, fmt.Sprintf(`{"role":"developer","email":"ada@example.com","biggestFrustrations":%q}`, spamTestAnswer))
+	if score, reasons := spamScore(t, first.ID); score != 0 || len(reasons) != 0 {
+		t.Errorf("first submission scored %v %v, want clean", score, reasons)
+	}
+
+	copied := strings.Replace(spamTestAnswer, "forever", "ages", 1)
+	duplicate := submitTestSurvey(t, fmt.Sprintf(`{"role":"developer","email":" ADA@example.com","biggestFrustrations":%q}`, copied))
+	score, reasons := spamScore(t, duplicate.ID)
+	if score != 0.9 || len(reasons) != 2 || !strings.Contains(reasons[0], first.ID) {
+		t.Errorf("duplicate scored %v %v, want a near-duplicate and reused email", score, reasons)
+	}
+
+	// The progress events of the session started seconds before submitting
+	if _, err := db.Exec(`INSERT INTO survey_progress (session_id, step, event, created_at) VALUES (?, 0, 'enter', ?)`,
+		"spam-session-1", time.Now().Add(-5*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	links := strings.Repeat("see https://spam.example.com/buy ", 5)
+	rushed := submitTestSurvey(t, fmt.Sprintf(`{"role":"developer","sessionId":"spam-session-1","wishedFeatures":%q}`, links))
+	score, reasons = spamScore(t, rushed.ID)
+	if score != 1 || len(reasons) != 2 || !strings.HasPrefix(reasons[0], "completed in") {
+		t.Errorf("rushed submission with links scored %v %v", score, reasons)
+	}
+
+	mashed := submitTestSurvey(t, `{"role":"designer","specificProblems":"asdfgh qwrtzp sdfsdf lkjhgf"}`)
+	if score, reasons := spamScore(t, mashed.ID); score != 0.5 || len(reasons) != 1 {
+		t.Errorf("gibberish scored %v %v, want 0.5", score, reasons)
+	}
+}
+
+// getTestSpamFlags lists flagged responses through the admin handler
+func getTestSpamFlags(t *testing.T, target string) []SpamFlag {
+	t.Helper()
+	recorder := callHandler(getSpamFlags, "GET", target, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s returned %d: %s", target, recorder.Code, recorder.Body.String())
+	}
+	var flags []SpamFlag
+	if err := json.Unmarshal(recorder.Body.Bytes(), &flags); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func TestReviewSpamFlags(t *testing.T) {
+	openTestDB(t)
+	clean := submitTestSurvey(t, fmt.Sprintf(`{"role":"developer","biggestFrustrations":%q}`, spamTestAnswer))
+	mashed := submitTestSurvey(t, `{"role":"designer","specificProblems":"asdfgh qwrtzp sdfsdf lkjhgf"}`)
+	reused := submitTestSurvey(t, `{"role":"designer","email":"bot@example.com"}`)
+	again := submitTestSurvey(t, `{"role":"designer","email":"bot@example.com"}`)
+
+	flags := getTestSpamFlags(t, "/results/spam")
+	if len(flags) != 1 || flags[0].ResponseID != mashed.ID || flags[0].Excerpt == "" {
+		t.Fatalf("flags at the default threshold = %+v", flags)
+	}
+	if flags := getTestSpamFlags(t, "/results/spam?minScore=0.4"); len(flags) != 2 {
+		t.Errorf("got %d flags at 0.4, want 2", len(flags))
+	}
+	if recorder := callHandler(getSpamFlags, "GET", "/results/spam?minScore=2", nil); recorder.Code != http.StatusBadRequest {
+		t.Errorf("minScore=2 returned %d, want 400", recorder.Code)
+	}
+
+	cleared := callHandler(clearSpamFlag, "POST", "/results/"+mashed.ID+"/spam/clear", nil, gin.Param{Key: "id", Value: mashed.ID})
+	if cleared.Code != http.StatusOK {
+		t.Fatalf("clearSpamFlag returned %d: %s", cleared.Code, cleared.Body.String())
+	}
+	if flags := getTestSpamFlags(t, "/results/spam"); len(flags) != 0 {
+		t.Errorf("cleared response still flagged: %+v", flags)
+	}
+	missing := callHandler(clearSpamFlag, "POST", "/results/missing/spam/clear", nil, gin.Param{Key: "id", Value: "missing"})
+	if missing.Code != http.StatusNotFound {
+		t.Errorf("clearing an unknown response returned %d, want 404", missing.Code)
+	}
+
+	for _, body := range []string{`{}`, `{"ids":["x"],"minScore":0.5}`, `{"minScore":0}`} {
+		if recorder := callHandler(removeSpam, "POST", "/results/spam/remove", strings.NewReader(body)); recorder.Code != http.StatusBadRequest {
+			t.Errorf("removeSpam(%s) returned %d, want 400", body, recorder.Code)
+		}
+	}
+
+	recorder := callHandler(removeSpam, "POST", "/results/spam/remove", strings.NewReader(`{"minScore":0.4}`))
+	var removed struct {
+		Removed []string `json:"removed"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &removed); err != nil {
+		t.Fatal(err)
+	}
+	if len(removed.Removed) != 1 || removed.Removed[0] != again.ID {
+		t.Errorf("removed %v, want only the response reusing an email", removed.Removed)
+	}
+
+	// Listed ids must all be flagged, otherwise nothing is removed
+	remashed := submitTestSurvey(t, `{"role":"designer","specificProblems":"qwrtzp lkjhgf xcvbnm sdfsdf"}`)
+	body := fmt.Sprintf(`{"ids":[%q,%q]}`, remashed.ID, reused.ID)
+	recorder = callHandler(removeSpam, "POST", "/results/spam/remove", strings.NewReader(body))
+	if recorder.Code != http.StatusConflict || !strings.Contains(recorder.Body.String(), reused.ID) {
+		t.Errorf("removing an unflagged response returned %d: %s, want 409 naming it", recorder.Code, recorder.Body.String())
+	}
+
+	body = fmt.Sprintf(`{"ids":[%q,"missing"]}`, remashed.ID)
+	recorder = callHandler(removeSpam, "POST", "/results/spam/remove", strings.NewReader(body))
+	if err := json.Unmarshal(recorder.Body.Bytes(), &removed); err != nil {
+		t.Fatal(err)
+	}
+	if len(removed.Removed) != 1 || removed.Removed[0] != remashed.ID {
+		t.Errorf("removed %v, want only the existing response", removed.Removed)
+	}
+
+	var left int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM survey_responses`).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getSurveyResponse(clean.ID); err != nil || left != 3 {
+		t.Errorf("%d responses left (%v), want the clean, cleared and unflagged ones", left, err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import "math"
+
+// chiSquarePValue returns P(X > x) for a chi-square distribution with df
+// degrees of freedom
+func chiSquarePValue(x float64, df int) float64 {
+	if df <= 0 || x <= 0 {
+		return 1
+	}
+	return regularizedGammaQ(float64(df)/2, x/2)
+}
+
+// regularizedGammaQ is the upper regularized incomplete gamma function Q(a, x),
+// using the series expansion below a+1 and a continued fraction above it
+func regularizedGammaQ(a, x float64) float64 {
+	if x < a+1 {
+		return 1 - gammaSeries(a, x)
+	}
+	return gammaContinuedFraction(a, x)
+}
+
+const (
+	gammaMaxIterations = 500
+	gammaEpsilon       = 1e-14
+)
+
+func gammaSeries(a, x float64) float64 {
+	lgamma, _ := math.Lgamma(a)
+	sum := 1 / a
+	term := sum
+	for n := 1; n < gammaMaxIterations; n++ {
+		term *= x / (a + float64(n))
+		sum += term
+		if math.Abs(term) < math.Abs(sum)*gammaEpsilon {
+			break
+		}
+	}
+	return sum * math.Exp(-x+a*math.Log(x)-lgamma)
+}
+
+// gammaContinuedFraction evaluates Q(a, x) with the modified Lentz method
+func gammaContinuedFraction(a, x float64) float64 {
+	const tiny = 1e-300
+	lgamma, _ := math.Lgamma(a)
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1; i < gammaMaxIterations; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < gammaEpsilon {
+			break
+		}
+	}
+	return math.Exp(-x+a*math.Log(x)-lgamma) * h
+}
+
+// tCritical95 holds two-sided 95% critical values of Student's t for 1-30
+// degrees of freedom
+var tCritical95 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// tCritical returns the two-sided 95% critical value for df degrees of freedom
+func tCritical(df int) float64 {
+	switch {
+	case df < 1:
+		return math.NaN()
+	case df <= len(tCritical95):
+		return tCritical95[df-1]
+	case df <= 40:
+		return 2.021
+	case df <= 60:
+		return 2.000
+	case df <= 120:
+		return 1.980
+	}
+	return 1.960
+}
+
+// histogramMedian returns the median of values 1..len(counts) given how
+// often each occurs
+func histogramMedian(counts []int, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	// valueAt returns the k-th smallest value (0-based)
+	valueAt := func(k int) float64 {
+		seen := 0
+		for i, count := range counts {
+			seen += count
+			if k < seen {
+				return float64(i + 1)
+			}
+		}
+		return float64(len(counts))
+	}
+	if n%2 == 1 {
+		return valueAt(n / 2)
+	}
+	return (valueAt(n/2-1) + valueAt(n/2)) / 2
+}
+
+// cohensKappa measures agreement between two raters on a yes/no judgement
+// beyond what chance would produce. ok is false when chance agreement is
+// already perfect, e.g. both raters never applied the code.
+func cohensKappa(both, onlyFirst, onlySecond, neither int) (kappa float64, ok bool) {
+	n := float64(both + onlyFirst + onlySecond + neither)
+	if n == 0 {
+		return 0, false
+	}
+	observed := float64(both+neither) / n
+	firstYes := float64(both+onlyFirst) / n
+	secondYes := float64(both+onlySecond) / n
+	expected := firstYes*secondYes + (1-firstYes)*(1-secondYes)
+	if expected == 1 {
+		return 0, false
+	}
+	return (observed - expected) / (1 - expected), true
+}
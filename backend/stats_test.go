@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestChiSquarePValue(t *testing.T) {
+	tests := []struct {
+		x    float64
+		df   int
+		want float64
+	}{
+		// Critical values from the standard chi-square table
+		{3.841, 1, 0.05},
+		{6.635, 1, 0.01},
+		{5.991, 2, 0.05},
+		{7.815, 3, 0.05},
+		{11.070, 5, 0.05},
+		{18.307, 10, 0.05},
+		// With two degrees of freedom the tail is exp(-x/2)
+		{2, 2, math.Exp(-1)},
+		{0, 1, 1},
+		{3, 0, 1},
+	}
+	for _, tt := range tests {
+		if got := chiSquarePValue(tt.x, tt.df); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("chiSquarePValue(%v, %d) = %v, want %v", tt.x, tt.df, got, tt.want)
+		}
+	}
+}
+
+func TestRegularizedGammaQ(t *testing.T) {
+	tests := []struct {
+		a, x float64
+		want float64
+	}{
+		// Q(1, x) = exp(-x), on both sides of the series/continued fraction split
+		{1, 0.5, math.Exp(-0.5)},
+		{1, 3, math.Exp(-3)},
+		// Q(1/2, x) = erfc(sqrt(x))
+		{0.5, 0.5, math.Erfc(math.Sqrt(0.5))},
+		{0.5, 2, math.Erfc(math.Sqrt(2))},
+		// Q(2, x) = (1 + x) exp(-x)
+		{2, 1, 2 * math.Exp(-1)},
+		{2, 10, 11 * math.Exp(-10)},
+	}
+	for _, tt := range tests {
+		if got := regularizedGammaQ(tt.a, tt.x); math.Abs(got-tt.want) > 1e-10 {
+			t.Errorf("regularizedGammaQ(%v, %v) = %v, want %v", tt.a, tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestChiSquareTest(t *testing.T) {
+	tests := []struct {
+		name      string
+		counts    [][]int
+		statistic float64
+		df        int
+		pValue    float64
+		cramersV  float64
+	}{
+		{"weak 2x2", [][]int{{10, 20}, {30, 40}}, 0.7937, 1, 0.373, 0.0891},
+		{"perfect 2x2", [][]int{{20, 0}, {0, 30}}, 50, 1, 0, 1},
+		{"2x3", [][]int{{10, 20, 30}, {20, 20, 20}}, 5.3333, 2, 0.069483, 0.2108},
+	}
+	for _, tt := range tests {
+		rowTotals := make([]int, len(tt.counts))
+		columnTotals := make([]int, len(tt.counts[0]))
+		total := 0
+		for i, row := range tt.counts {
+			for j, count := range row {
+				rowTotals[i] += count
+				columnTotals[j] += count
+				total += count
+			}
+		}
+		test := chiSquareTest(tt.counts, rowTotals, columnTotals, total)
+		if test.Statistic != tt.statistic || test.DegreesOfFreedom != tt.df || test.CramersV != tt.cramersV {
+			t.Errorf("%s: got statistic %v, df %d, Cramér's V %v; want %v, %d, %v",
+				tt.name, test.Statistic, test.DegreesOfFreedom, test.CramersV, tt.statistic, tt.df, tt.cramersV)
+		}
+		if math.Abs(test.PValue-tt.pValue) > 1e-3 {
+			t.Errorf("%s: p-value = %v, want %v", tt.name, test.PValue, tt.pValue)
+		}
+	}
+}
+
+func TestChiSquareTestNeedsTwoByTwo(t *testing.T) {
+	test := chiSquareTest([][]int{{5, 7}}, []int{12}, []int{5, 7}, 12)
+	if test.PValue != 1 || test.Warning == "" {
+		t.Errorf("single-row table: got p-value %v, warning %q", test.PValue, test.Warning)
+	}
+}
+
+func TestTCritical(t *testing.T) {
+	tests := []struct {
+		df   int
+		want float64
+	}{
+		{1, 12.706},
+		{2, 4.303},
+		{10, 2.228},
+		{30, 2.042},
+		{35, 2.021},
+		{60, 2.000},
+		{100, 1.980},
+		{1000, 1.960},
+	}
+	for _, tt := range tests {
+		if got := tCritical(tt.df); got != tt.want {
+			t.Errorf("tCritical(%d) = %v, want %v", tt.df, got, tt.want)
+		}
+	}
+	if got := tCritical(0); !math.IsNaN(got) {
+		t.Errorf("tCritical(0) = %v, want NaN", got)
+	}
+}
+
+func TestHistogramMedian(t *testing.T) {
+	tests := []struct {
+		counts []int
+		want   float64
+	}{
+		{[]int{1, 1, 1, 1, 1}, 3},
+		{[]int{0, 2, 0, 2, 0}, 3},
+		{[]int{0, 0, 0, 0, 4}, 5},
+		{[]int{0, 0, 0, 0, 0}, 0},
+	}
+	for _, tt := range tests {
+		n := 0
+		for _, count := range tt.counts {
+			n += count
+		}
+		if got := histogramMedian(tt.counts, n); got != tt.want {
+			t.Errorf("histogramMedian(%v) = %v, want %v", tt.counts, got, tt.want)
+		}
+	}
+}
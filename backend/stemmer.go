@@ -0,0 +1,225 @@
+package main
+
+import "strings"
+
+// stem reduces an English word to its Porter stem, so "merging", "merged"
+// and "merges" all count as the same term. Words of two letters or fewer are
+// returned unchanged.
+func stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	w := []byte(word)
+	w = porterStep1a(w)
+	w = porterStep1b(w)
+	w = porterStep1c(w)
+	w = porterStep2(w)
+	w = porterStep3(w)
+	w = porterStep4(w)
+	w = porterStep5(w)
+	return string(w)
+}
+
+func isConsonant(w []byte, i int) bool {
+	switch w[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !isConsonant(w, i-1)
+	}
+	return true
+}
+
+// measure counts the vowel-consonant sequences in w, Porter's m
+func measure(w []byte) int {
+	m := 0
+	i := 0
+	n := len(w)
+	for i < n && isConsonant(w, i) {
+		i++
+	}
+	for i < n {
+		for i < n && !isConsonant(w, i) {
+			i++
+		}
+		if i >= n {
+			break
+		}
+		for i < n && isConsonant(w, i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+func containsVowel(w []byte) bool {
+	for i := range w {
+		if !isConsonant(w, i) {
+			return true
+		}
+	}
+	return false
+}
+
+func endsDoubleConsonant(w []byte) bool {
+	n := len(w)
+	return n >= 2 && w[n-1] == w[n-2] && isConsonant(w, n-1)
+}
+
+// endsCVC reports a consonant-vowel-consonant ending where the last
+// consonant is not w, x or y
+func endsCVC(w []byte) bool {
+	n := len(w)
+	if n < 3 || !isConsonant(w, n-1) || isConsonant(w, n-2) || !isConsonant(w, n-3) {
+		return false
+	}
+	switch w[n-1] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+func hasSuffix(w []byte, suffix string) bool {
+	return strings.HasSuffix(string(w), suffix)
+}
+
+// replaceSuffix swaps suffix for replacement when the remaining stem has a
+// measure above minMeasure. It reports whether the suffix matched at all.
+func replaceSuffix(w *[]byte, suffix, replacement string, minMeasure int) bool {
+	if !hasSuffix(*w, suffix) {
+		return false
+	}
+	base := (*w)[:len(*w)-len(suffix)]
+	if measure(base) > minMeasure {
+		*w = append(base[:len(base):len(base)], replacement...)
+	}
+	return true
+}
+
+func porterStep1a(w []byte) []byte {
+	switch {
+	case hasSuffix(w, "sses"), hasSuffix(w, "ies"):
+		return w[:len(w)-2]
+	case hasSuffix(w, "ss"):
+		return w
+	case hasSuffix(w, "s"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+func porterStep1b(w []byte) []byte {
+	if hasSuffix(w, "eed") {
+		if measure(w[:len(w)-3]) > 0 {
+			return w[:len(w)-1]
+		}
+		return w
+	}
+
+	var base []byte
+	switch {
+	case hasSuffix(w, "ed") && containsVowel(w[:len(w)-2]):
+		base = w[:len(w)-2]
+	case hasSuffix(w, "ing") && containsVowel(w[:len(w)-3]):
+		base = w[:len(w)-3]
+	default:
+		return w
+	}
+
+	switch {
+	case hasSuffix(base, "at"), hasSuffix(base, "bl"), hasSuffix(base, "iz"):
+		return append(base[:len(base):len(base)], 'e')
+	case endsDoubleConsonant(base):
+		switch base[len(base)-1] {
+		case 'l', 's', 'z':
+			return base
+		}
+		return base[:len(base)-1]
+	case measure(base) == 1 && endsCVC(base):
+		return append(base[:len(base):len(base)], 'e')
+	}
+	return base
+}
+
+func porterStep1c(w []byte) []byte {
+	if hasSuffix(w, "y") && containsVowel(w[:len(w)-1]) {
+		return append(w[:len(w)-1:len(w)-1], 'i')
+	}
+	return w
+}
+
+var porterStep2Suffixes = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"},
+	{"izer", "ize"}, {"abli", "able"}, {"alli", "al"}, {"entli", "ent"},
+	{"eli", "e"}, {"ousli", "ous"}, {"ization", "ize"}, {"ation", "ate"},
+	{"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"},
+	{"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+}
+
+func porterStep2(w []byte) []byte {
+	for _, rule := range porterStep2Suffixes {
+		if replaceSuffix(&w, rule[0], rule[1], 0) {
+			break
+		}
+	}
+	return w
+}
+
+var porterStep3Suffixes = [][2]string{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"},
+	{"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+func porterStep3(w []byte) []byte {
+	for _, rule := range porterStep3Suffixes {
+		if replaceSuffix(&w, rule[0], rule[1], 0) {
+			break
+		}
+	}
+	return w
+}
+
+var porterStep4Suffixes = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement",
+	"ment", "ent", "ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+func porterStep4(w []byte) []byte {
+	// Longest suffixes first so "ement" wins over "ment" and "ent"
+	var match string
+	for _, suffix := range porterStep4Suffixes {
+		if hasSuffix(w, suffix) && len(suffix) > len(match) {
+			match = suffix
+		}
+	}
+	if match == "" {
+		return w
+	}
+
+	base := w[:len(w)-len(match)]
+	if match == "ion" {
+		if len(base) == 0 || (base[len(base)-1] != 's' && base[len(base)-1] != 't') {
+			return w
+		}
+	}
+	if measure(base) > 1 {
+		return base
+	}
+	return w
+}
+
+func porterStep5(w []byte) []byte {
+	if hasSuffix(w, "e") {
+		base := w[:len(w)-1]
+		m := measure(base)
+		if m > 1 || (m == 1 && !endsCVC(base)) {
+			w = base
+		}
+	}
+	if measure(w) > 1 && endsDoubleConsonant(w) && hasSuffix(w, "l") {
+		w = w[:len(w)-1]
+	}
+	return w
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	streamResponseCreated = "response.created"
+	streamResponseUpdated = "response.updated"
+	streamResponseDeleted = "response.deleted"
+	// streamReset tells a client it missed events and must reload everything
+	streamReset = "reset"
+
+	streamHeartbeat    = 15 * time.Second
+	streamPollInterval = time.Second
+	// Clients further behind than this reload instead of replaying the log
+	streamBacklogLimit = 1000
+	// Events buffered per client; a client that falls further behind is
+	// disconnected and catches up from the log when it reconnects
+	streamBuffer = 64
+
+	streamTokenPurpose = "event-stream"
+	streamTokenTTL     = 5 * time.Minute
+)
+
+// eventLogRetention is how long events are kept for clients to resume from
+func eventLogRetention() time.Duration {
+	hours, err := strconv.Atoi(getEnvWithFallback("EVENT_LOG_RETENTION_HOURS", "72"))
+	if err != nil || hours <= 0 {
+		hours = 72
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func createEventLogTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS survey_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		response_id TEXT NOT NULL,
+		data JSON NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`)
+	return err
+}
+
+// MetricsDelta is the change one event makes to the /metrics numbers. Feature
+// scores are sent as sums: the new average is (average * total + sum) divided
+// by the new total.
+type MetricsDelta struct {
+	TotalResponses       int                       `json:"totalResponses"`
+	BetaInterestCount    int                       `json:"betaInterestCount"`
+	FeatureScoreSums     map[string]int            `json:"featureScoreSums"`
+	UsageFrequencyStats  map[string]int            `json:"usageFrequencyStats"`
+	TeamSizeDistribution map[string]int            `json:"teamSizeDistribution"`
+	PricingPreferences   map[string]int            `json:"pricingPreferences"`
+	LanguageDistribution map[string]int            `json:"languageDistribution"`
+	Distributions        map[string]map[string]int `json:"distributions"`
+}
+
+// StreamEvent is one entry of the event log as sent to stream clients
+type StreamEvent struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	ResponseID string          `json:"responseId"`
+	Response   *SurveyResponse `json:"response,omitempty"`
+	Delta      MetricsDelta    `json:"delta"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+func newMetricsDelta() MetricsDelta {
+	return MetricsDelta{
+		FeatureScoreSums:     make(map[string]int),
+		UsageFrequencyStats:  make(map[string]int),
+		TeamSizeDistribution: make(map[string]int),
+		PricingPreferences:   make(map[string]int),
+		LanguageDistribution: make(map[string]int),
+		Distributions:        make(map[string]map[string]int),
+	}
+}
+
+// snakeToCamel turns a column name into the matching JSON field name
+func snakeToCamel(column string) string {
+	parts := strings.Split(column, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func addToCount(counts map[string]int, key string, sign int) {
+	counts[key] += sign
+	if counts[key] == 0 {
+		delete(counts, key)
+	}
+}
+
+// addResponse adds (sign 1) or removes (sign -1) one response's share of the
+// metrics, counting values the same way computeMetrics does
+func (d *MetricsDelta) addResponse(survey *SurveyResponse, sign int) {
+	if survey == nil {
+		return
+	}
+	d.TotalResponses += sign
+	if survey.BetaInterest {
+		d.BetaInterestCount += sign
+	}
+	for name, score := range featureScores(survey) {
+		if score != 0 {
+			addToCount(d.FeatureScoreSums, name, sign*score)
+		}
+	}
+	addToCount(d.UsageFrequencyStats, survey.UsageFrequency, sign)
+	addToCount(d.TeamSizeDistribution, survey.TeamSize, sign)
+	addToCount(d.PricingPreferences, survey.PricingModel, sign)
+	addToCount(d.LanguageDistribution, survey.Language, sign)
+
+	answers := responseFields(survey)
+	for _, field := range distributionFields {
+		value, _ := answers[snakeToCamel(field.column)].(string)
+		for _, bucket := range answerBuckets(field, value) {
+			if d.Distributions[field.key] == nil {
+				d.Distributions[field.key] = make(map[string]int)
+			}
+			addToCount(d.Distributions[field.key], bucket, sign)
+			if len(d.Distributions[field.key]) == 0 {
+				delete(d.Distributions, field.key)
+			}
+		}
+	}
+}
+
+// responseDelta is what a change to one response does to the metrics.
+// previous is nil for new responses and current nil for deleted ones.
+func responseDelta(previous, current *SurveyResponse) MetricsDelta {
+	delta := newMetricsDelta()
+	delta.addResponse(previous, -1)
+	delta.addResponse(current, 1)
+	return delta
+}
+
+// recordResponseEvent appends a change to the event log in the caller's
+// transaction. previous is nil for new responses and current nil for deleted ones.
+func recordResponseEvent(tx *sql.Tx, eventType string, previous, current *SurveyResponse) error {
+	delta := responseDelta(previous, current)
+	responseID := ""
+	if current != nil {
+		responseID = current.ID
+	} else if previous != nil {
+		responseID = previous.ID
+	}
+	data, err := json.Marshal(struct {
+		Response *SurveyResponse `json:"response,omitempty"`
+		Delta    MetricsDelta    `json:"delta"`
+	}{current, delta})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO survey_events (type, response_id, data, created_at) VALUES (?, ?, ?, ?)`,
+		eventType, responseID, string(data), time.Now())
+	return err
+}
+
+// redactResponseEvents strips the respondent's email address from the logged
+// events of a deleted response; reconnecting clients still get the deltas
+func redactResponseEvents(tx *sql.Tx, responseID string) error {
+	_, err := tx.Exec(`UPDATE survey_events SET data = json_remove(data, '$.response.email')
+		WHERE response_id = ? AND json_extract(data, '$.response.email') IS NOT NULL`, responseID)
+	return err
+}
+
+func queryStreamEvents(query string, args ...interface{}) ([]StreamEvent, error) {
+	rows, err := db.Query(`SELECT id, type, response_id, data, created_at FROM survey_events `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []StreamEvent
+	for rows.Next() {
+		var event StreamEvent
+		var data string
+		if err := rows.Scan(&event.ID, &event.Type, &event.ResponseID, &data, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// streamHub fans new log entries out to connected stream clients
+type streamHub struct {
+	mu          sync.Mutex
+	subscribers map[chan StreamEvent]struct{}
+	lastID      int64
+}
+
+var eventStream = &streamHub{subscribers: make(map[chan StreamEvent]struct{})}
+
+// subscribe registers a client. Events after the returned id will arrive on
+// the channel; earlier ones have to be read from the log.
+func (h *streamHub) subscribe() (chan StreamEvent, int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan StreamEvent, streamBuffer)
+	h.subscribers[ch] = struct{}{}
+	return ch, h.lastID
+}
+
+func (h *streamHub) unsubscribe(ch chan StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (h *streamHub) broadcast(events []StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, event := range events {
+		for ch := range h.subscribers {
+			select {
+			case ch <- event:
+			default:
+				delete(h.subscribers, ch)
+				close(ch)
+			}
+		}
+		h.lastID = event.ID
+	}
+}
+
+// runEventBroadcaster polls the event log, which every write path appends to
+// inside its transaction, so only committed changes reach clients
+func runEventBroadcaster() {
+	if err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM survey_events`).Scan(&eventStream.lastID); err != nil {
+		log.Printf("Event stream failed to start: %v", err)
+		return
+	}
+
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+	for now := range ticker.C {
+		eventStream.mu.Lock()
+		lastID := eventStream.lastID
+		eventStream.mu.Unlock()
+
+		events, err := queryStreamEvents(`WHERE id > ? ORDER BY id`, lastID)
+		if err != nil {
+			log.Printf("Event stream poll failed: %v", err)
+			continue
+		}
+		if len(events) > 0 {
+			eventStream.broadcast(events)
+		}
+
+		if now.Sub(lastPrune) > time.Hour {
+			if _, err := db.Exec(`DELETE FROM survey_events WHERE julianday(created_at) < julianday(?)`,
+				now.Add(-eventLogRetention())); err != nil {
+				log.Printf("Event log pruning failed: %v", err)
+			}
+			lastPrune = now
+		}
+	}
+}
+
+func writeStreamEvent(c *gin.Context, event StreamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// resumeEvents returns the logged events after lastEventID, or false when the
+// client is too far behind and has to reload instead
+func resumeEvents(lastEventID, upTo int64) ([]StreamEvent, bool, error) {
+	var oldest sql.NullInt64
+	if err := db.QueryRow(`SELECT MIN(id) FROM survey_events`).Scan(&oldest); err != nil {
+		return nil, false, err
+	}
+	// Events after lastEventID have been pruned
+	if oldest.Valid && oldest.Int64 > lastEventID+1 {
+		return nil, false, nil
+	}
+	events, err := queryStreamEvents(`WHERE id > ? AND id <= ? ORDER BY id LIMIT ?`,
+		lastEventID, upTo, streamBacklogLimit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(events) > streamBacklogLimit {
+		return nil, false, nil
+	}
+	return events, true, nil
+}
+
+// streamEvents sends new, updated and deleted responses as Server-Sent
+// Events, each with the change it makes to the metrics. Clients resume with
+// the Last-Event-ID header (or ?lastEventId=) and get a reset event when the
+// events they missed are no longer in the log.
+func streamEvents(c *gin.Context) {
+	var lastEventID int64 = -1
+	raw := c.GetHeader("Last-Event-ID")
+	if raw == "" {
+		raw = c.Query("lastEventId")
+	}
+	if raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID must be a non-negative number"})
+			return
+		}
+		lastEventID = id
+	}
+
+	ch, subscribedAt := eventStream.subscribe()
+	defer eventStream.unsubscribe(ch)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: 3000\n\n")
+	c.Writer.Flush()
+
+	sent := subscribedAt
+	if lastEventID >= 0 && lastEventID < subscribedAt {
+		backlog, ok, err := resumeEvents(lastEventID, subscribedAt)
+		if err != nil {
+			log.Printf("Event stream resume failed: %v", err)
+			return
+		}
+		if !ok {
+			backlog = []StreamEvent{{ID: subscribedAt, Type: streamReset, CreatedAt: time.Now()}}
+		}
+		for _, event := range backlog {
+			if err := writeStreamEvent(c, event); err != nil {
+				return
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				// Too slow to keep up; the client reconnects and resumes from the log
+				return
+			}
+			if event.ID <= sent {
+				continue
+			}
+			if err := writeStreamEvent(c, event); err != nil {
+				return
+			}
+			sent = event.ID
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// streamTokenKey derives a key from JWT_SECRET so stream tokens are never
+// accepted as admin tokens, nor admin tokens in stream URLs
+func streamTokenKey() []byte {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte(streamTokenPurpose))
+	return mac.Sum(nil)
+}
+
+// issueStreamToken hands out a short-lived token for opening the event
+// stream. Browsers' EventSource cannot set headers, so it goes in the URL,
+// and only this token, never the admin one, should end up there.
+func issueStreamToken(c *gin.Context) {
+	expiresAt := time.Now().Add(streamTokenTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"purpose":  streamTokenPurpose,
+		"username": changedBy(c),
+		"exp":      expiresAt.Unix(),
+	})
+	signed, err := token.SignedString(streamTokenKey())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": signed, "expiresAt": expiresAt.UTC()})
+}
+
+// streamTokenAuth admits stream clients with a ?token= from issueStreamToken,
+// or with the admin token in the Authorization header. The token is only
+// checked when connecting; open streams outlive it.
+func streamTokenAuth() gin.HandlerFunc {
+	auth := AuthMiddleware()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			auth(c)
+			return
+		}
+		token, err := jwt.Parse(c.Query("token"), func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return streamTokenKey(), nil
+		})
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "stream token is invalid or expired"})
+			return
+		}
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok || !token.Valid || claims["purpose"] != streamTokenPurpose {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "stream token is invalid or expired"})
+			return
+		}
+		c.Set("username", claims["username"])
+		c.Next()
+	}
+}
+
+// responseInTx reads a response as the transaction sees it
+func responseInTx(tx *sql.Tx, id string) (*SurveyResponse, error) {
+	survey, err := scanSurveyResponse(tx.QueryRow(`SELECT `+surveyResponseColumns+` FROM survey_responses WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &survey, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSnakeToCamel(t *testing.T) {
+	tests := map[string]string{
+		"role":                             "role",
+		"usage_frequency":                  "usageFrequency",
+		"current_change_conflict_handling": "currentChangeConflictHandling",
+	}
+	for column, want := range tests {
+		if got := snakeToCamel(column); got != want {
+			t.Errorf("snakeToCamel(%q) = %q, want %q", column, got, want)
+		}
+	}
+}
+
+func TestMetricsDeltaAddResponse(t *testing.T) {
+	before := &SurveyResponse{Role: "developer", TeamSize: "1-5", UsageFrequency: "daily", Language: "en",
+		Platforms: "web, desktop", Features: Features{Offline: 4, Workflows: 2}}
+	after := *before
+	after.TeamSize = "6-20"
+	after.BetaInterest = true
+	after.Features.Offline = 5
+
+	delta := newMetricsDelta()
+	delta.addResponse(before, -1)
+	delta.addResponse(&after, 1)
+	if delta.TotalResponses != 0 || delta.BetaInterestCount != 1 {
+		t.Errorf("totals = %d responses, %d beta", delta.TotalResponses, delta.BetaInterestCount)
+	}
+	// Unchanged answers cancel out and leave no keys behind
+	if len(delta.FeatureScoreSums) != 1 || delta.FeatureScoreSums["offline"] != 1 {
+		t.Errorf("feature sums = %v", delta.FeatureScoreSums)
+	}
+	if len(delta.TeamSizeDistribution) != 2 || delta.TeamSizeDistribution["1-5"] != -1 || delta.TeamSizeDistribution["6-20"] != 1 {
+		t.Errorf("team sizes = %v", delta.TeamSizeDistribution)
+	}
+	if len(delta.UsageFrequencyStats) != 0 || len(delta.LanguageDistribution) != 0 || len(delta.Distributions) != 1 {
+		t.Errorf("unchanged answers left %v %v %v", delta.UsageFrequencyStats, delta.LanguageDistribution, delta.Distributions)
+	}
+
+	created := newMetricsDelta()
+	created.addResponse(before, 1)
+	if created.TotalResponses != 1 || created.Distributions["platforms"]["web"] != 1 || created.Distributions["platforms"]["desktop"] != 1 {
+		t.Errorf("new response delta = %+v", created)
+	}
+}
+
+func TestRecordResponseEvents(t *testing.T) {
+	openTestDB(t)
+	response := submitTestSurvey(t, `{"role":"developer","teamSize":"1-5","features":{"offline":4},"email":"ada@example.com"}`)
+	param := gin.Param{Key: "id", Value: response.ID}
+	updated := callHandler(updateResult, "PUT", "/results/"+response.ID,
+		strings.NewReader(`{"role":"developer","cmsUsage":"wordpress","teamSize":"6-20","features":{"offline":4},"email":"ada@example.com"}`), param)
+	if updated.Code != http.StatusOK {
+		t.Fatalf("updateResult returned %d: %s", updated.Code, updated.Body.String())
+	}
+	if deleted := callHandler(deleteResult, "DELETE", "/results/"+response.ID, nil, param); deleted.Code != http.StatusOK {
+		t.Fatalf("deleteResult returned %d", deleted.Code)
+	}
+
+	events, err := queryStreamEvents(`ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("logged %d events, want 3", len(events))
+	}
+	for i, want := range []string{streamResponseCreated, streamResponseUpdated, streamResponseDeleted} {
+		if events[i].Type != want || events[i].ResponseID != response.ID {
+			t.Errorf("event %d = %s for %s, want %s", i, events[i].Type, events[i].ResponseID, want)
+		}
+	}
+	if events[0].Delta.TotalResponses != 1 || events[0].Delta.FeatureScoreSums["offline"] != 4 {
+		t.Errorf("created delta = %+v", events[0].Delta)
+	}
+	if d := events[1].Delta; d.TotalResponses != 0 || d.TeamSizeDistribution["1-5"] != -1 || d.TeamSizeDistribution["6-20"] != 1 {
+		t.Errorf("updated delta = %+v", d)
+	}
+	if d := events[2].Delta; d.TotalResponses != -1 || d.FeatureScoreSums["offline"] != -4 || events[2].Response != nil {
+		t.Errorf("deleted event = %+v", events[2])
+	}
+	// Deleting the response purges the email from the events logged before
+	for _, event := range events[:2] {
+		if event.Response == nil || event.Response.Role != "developer" || event.Response.Email != "" {
+			t.Errorf("%s event after delete = %+v, want the answers without the email", event.Type, event.Response)
+		}
+	}
+}
+
+func TestResumeEvents(t *testing.T) {
+	openTestDB(t)
+	for i := 0; i < 3; i++ {
+		submitTestSurvey(t, `{"role":"developer"}`)
+	}
+
+	events, ok, err := resumeEvents(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(events) != 2 || events[0].ID != 2 || events[1].ID != 3 {
+		t.Errorf("resume after 1 = %v %+v", ok, events)
+	}
+
+	// Once missed events are pruned the client has to reload
+	if _, err := db.Exec(`DELETE FROM survey_events WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := resumeEvents(0, 3); err != nil || ok {
+		t.Errorf("resume past pruned events = %v, %v; want a reset", ok, err)
+	}
+	if events, ok, err := resumeEvents(1, 3); err != nil || !ok || len(events) != 2 {
+		t.Errorf("resume right after the pruned event = %v %+v, %v", ok, events, err)
+	}
+}
+
+// readStreamEvent reads the next event from a Server-Sent Events stream,
+// skipping comments and the retry hint
+func readStreamEvent(t *testing.T, reader *bufio.Reader) (string, StreamEvent) {
+	t.Helper()
+	var name string
+	var event StreamEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatal(err)
+			}
+		case line == "" && name != "":
+			return name, event
+		}
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	openTestDB(t)
+	first := submitTestSurvey(t, `{"role":"developer"}`)
+	second := submitTestSurvey(t, `{"role":"designer"}`)
+
+	previous := eventStream
+	eventStream = &streamHub{subscribers: make(map[chan StreamEvent]struct{}), lastID: 2}
+	t.Cleanup(func() { eventStream = previous })
+
+	router := gin.New()
+	router.GET("/events/stream", streamEvents)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/events/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+
+	// Events missed since Last-Event-ID are replayed from the log
+	for _, want := range []string{first.ID, second.ID} {
+		name, event := readStreamEvent(t, reader)
+		if name != streamResponseCreated || event.ResponseID != want || event.Response == nil {
+			t.Errorf("replayed %s %+v, want the creation of %s", name, event, want)
+		}
+	}
+
+	// New events arrive as the broadcaster picks them up
+	third := submitTestSurvey(t, `{"role":"marketer"}`)
+	events, err := queryStreamEvents(`WHERE id > 2 ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventStream.broadcast(events)
+	name, event := readStreamEvent(t, reader)
+	if name != streamResponseCreated || event.ID != 3 || event.ResponseID != third.ID || event.Delta.TotalResponses != 1 {
+		t.Errorf("live event %s %+v", name, event)
+	}
+}
+
+func TestStreamEventsRejectsBadLastEventID(t *testing.T) {
+	recorder := callHandler(streamEvents, "GET", "/events/stream?lastEventId=-1", nil)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("negative lastEventId returned %d, want 400", recorder.Code)
+	}
+}
+
+func TestStreamTokenAuth(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/events/stream/token", nil)
+	c.Set("username", "admin")
+	issueStreamToken(c)
+	var issued struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &issued); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"username": "admin", "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/events/stream", streamTokenAuth(), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("username"))
+	})
+	tests := []struct {
+		name, target, header string
+		want                 int
+	}{
+		{"stream token", "/events/stream?token=" + issued.Token, "", http.StatusOK},
+		{"no token", "/events/stream", "", http.StatusUnauthorized},
+		// Admin tokens never belong in a URL
+		{"admin token in the URL", "/events/stream?token=" + admin, "", http.StatusUnauthorized},
+		{"admin token in the header", "/events/stream", "Bearer " + admin, http.StatusOK},
+		{"stream token in the header", "/events/stream", "Bearer " + issued.Token, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", tt.target, nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, recorder.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && recorder.Body.String() != "admin" {
+			t.Errorf("%s: username %q, want admin", tt.name, recorder.Body.String())
+		}
+	}
+}
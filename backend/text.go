@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// freeTextField is an open-ended survey answer
+type freeTextField struct {
+	key    string
+	column string
+	answer func(*SurveyResponse) *string
+}
+
+// freeTextFields lists the open-ended answers in survey order, keyed by their
+// SurveyResponse JSON names
+var freeTextFields = []freeTextField{
+	{"biggestFrustrations", "biggest_frustrations", func(s *SurveyResponse) *string { return &s.BiggestFrustrations }},
+	{"specificProblems", "specific_problems", func(s *SurveyResponse) *string { return &s.SpecificProblems }},
+	{"wishedFeatures", "wished_features", func(s *SurveyResponse) *string { return &s.WishedFeatures }},
+	{"customFormats", "custom_formats", func(s *SurveyResponse) *string { return &s.CustomFormats }},
+	{"feedbackSuggestions", "feedback_suggestions", func(s *SurveyResponse) *string { return &s.FeedbackSuggestions }},
+	{"excitementFactors", "excitement_factors", func(s *SurveyResponse) *string { return &s.ExcitementFactors }},
+	{"collaborationChallenges", "collaboration_challenges", func(s *SurveyResponse) *string { return &s.CollaborationChallenges }},
+	{"offlineWorkarounds", "offline_workarounds", func(s *SurveyResponse) *string { return &s.OfflineWorkarounds }},
+	{"currentChangeConflictHandling", "current_change_conflict_handling", func(s *SurveyResponse) *string { return &s.CurrentChangeConflictHandling }},
+	{"versionControlChallenges", "version_control_challenges", func(s *SurveyResponse) *string { return &s.VersionControlChallenges }},
+}
+
+// lookupFreeTextField finds a free-text field by its JSON name
+func lookupFreeTextField(key string) (freeTextField, bool) {
+	for _, field := range freeTextFields {
+		if field.key == key {
+			return field, true
+		}
+	}
+	return freeTextField{}, false
+}
+
+// stopWords are common English words that carry no meaning on their own
+var stopWords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		a about above after again against all also am an and any are aren't as at
+		be because been before being below between both but by
+		can can't cannot could couldn't did didn't do does doesn't doing don't down during
+		each etc even ever every few for from further get gets getting got
+		had hadn't has hasn't have haven't having he her here hers herself him himself his how
+		i i'd i'll i'm i've if in into is isn't it it's its itself just
+		let's like lot lots make makes many me more most much must mustn't my myself
+		need needs no nor not now of off often on once one only or other ought our ours ourselves out over own
+		quite rather really same she should shouldn't so some something still such
+		than that that's the their theirs them themselves then there there's these they they'd they'll they're they've
+		thing things this those through to too under until up us use used using very
+		want was wasn't way we we'd we'll we're we've well were weren't what when where which while who whom why will
+		with won't would wouldn't yes yet you you'd you'll you're you've your yours yourself yourselves`) {
+		stopWords[word] = true
+	}
+}
+
+// tokenizeText splits text into phrases at sentence punctuation and each
+// phrase into lower-cased words, so bigrams never span a sentence break.
+// Pure numbers and single characters are dropped.
+func tokenizeText(text string) [][]string {
+	phrases := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return strings.ContainsRune(".,;:!?()[]{}\"\n\r", r)
+	})
+
+	var result [][]string
+	for _, phrase := range phrases {
+		words := strings.FieldsFunc(phrase, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '-'
+		})
+		var tokens []string
+		for _, word := range words {
+			word = strings.Trim(word, "'-")
+			if len([]rune(word)) < 2 || strings.IndexFunc(word, unicode.IsLetter) < 0 {
+				continue
+			}
+			tokens = append(tokens, word)
+		}
+		if len(tokens) > 0 {
+			result = append(result, tokens)
+		}
+	}
+	return result
+}
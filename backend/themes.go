@@ -0,0 +1,515 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	themeLabelTerms   = 3
+	themeTopTerms     = 10
+	kMeansIterations  = 50
+	minThemeDocuments = 5
+)
+
+// Theme is a group of similar answers to one free-text question. Size counts
+// the assigned responses that match the request's segment filters.
+type Theme struct {
+	ID        int64     `json:"id"`
+	Field     string    `json:"field"`
+	Label     string    `json:"label"`
+	Renamed   bool      `json:"renamed"`
+	TopTerms  []string  `json:"topTerms"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ThemeResponse struct {
+	ResponseID string    `json:"responseId"`
+	Text       string    `json:"text"`
+	Similarity float64   `json:"similarity"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func createThemeTables() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS survey_themes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		field TEXT NOT NULL,
+		label TEXT NOT NULL,
+		renamed BOOLEAN NOT NULL DEFAULT 0,
+		top_terms JSON NOT NULL DEFAULT '[]',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS survey_theme_assignments (
+		response_id TEXT NOT NULL,
+		field TEXT NOT NULL,
+		theme_id INTEGER NOT NULL,
+		similarity REAL NOT NULL,
+		PRIMARY KEY (response_id, field)
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_survey_theme_assignments_theme
+		ON survey_theme_assignments (theme_id)`)
+	return err
+}
+
+// renamedTheme is a theme an admin relabelled, kept so the label can survive
+// a re-run of the clustering job
+type renamedTheme struct {
+	label     string
+	responses map[string]bool
+}
+
+func loadRenamedThemes(tx *sql.Tx, field string) ([]renamedTheme, error) {
+	rows, err := tx.Query(`SELECT t.label, a.response_id
+		FROM survey_themes t JOIN survey_theme_assignments a ON a.theme_id = t.id
+		WHERE t.field = ? AND t.renamed = 1 ORDER BY t.id`, field)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var themes []renamedTheme
+	byLabel := make(map[string]int)
+	for rows.Next() {
+		var label, responseID string
+		if err := rows.Scan(&label, &responseID); err != nil {
+			return nil, err
+		}
+		i, ok := byLabel[label]
+		if !ok {
+			i = len(themes)
+			byLabel[label] = i
+			themes = append(themes, renamedTheme{label: label, responses: make(map[string]bool)})
+		}
+		themes[i].responses[responseID] = true
+	}
+	return themes, rows.Err()
+}
+
+// inheritLabel finds the renamed theme that holds most of a new cluster's
+// responses. Each label is handed on at most once.
+func inheritLabel(members []string, renamed []renamedTheme, used map[int]bool) (string, bool) {
+	best, bestOverlap := -1, 0
+	for i, theme := range renamed {
+		if used[i] {
+			continue
+		}
+		overlap := 0
+		for _, id := range members {
+			if theme.responses[id] {
+				overlap++
+			}
+		}
+		if overlap > bestOverlap {
+			best, bestOverlap = i, overlap
+		}
+	}
+	if best < 0 || bestOverlap*2 <= len(members) {
+		return "", false
+	}
+	used[best] = true
+	return renamed[best].label, true
+}
+
+// clusterField groups the answers to one question and replaces its stored themes
+func clusterField(field freeTextField, k int) (int, error) {
+	rows, err := db.Query(`SELECT id, ` + field.column + ` FROM survey_responses
+		WHERE TRIM(COALESCE(` + field.column + `, '')) != '' ORDER BY created_at, id`)
+	if err != nil {
+		return 0, err
+	}
+	var docs []textDocument
+	for rows.Next() {
+		var doc textDocument
+		if err := rows.Scan(&doc.responseID, &doc.text); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		docs = append(docs, doc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	docs, vocab := prepareDocuments(docs)
+	docs = vectorize(docs, vocab)
+	if len(docs) < minThemeDocuments {
+		log.Printf("Skipping %s: only %d answers with shared terms", field.key, len(docs))
+		return 0, nil
+	}
+	if k <= 0 {
+		k = defaultClusterCount(len(docs))
+	}
+	assignments, similarities, centroids := kMeans(docs, len(vocab.stems), k, kMeansIterations)
+
+	members := make([][]int, len(centroids))
+	for i, cluster := range assignments {
+		members[cluster] = append(members[cluster], i)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	renamed, err := loadRenamedThemes(tx, field.key)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM survey_theme_assignments WHERE field = ?`, field.key); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM survey_themes WHERE field = ?`, field.key); err != nil {
+		return 0, err
+	}
+
+	created := 0
+	used := make(map[int]bool)
+	for cluster, docIndexes := range members {
+		if len(docIndexes) == 0 {
+			continue
+		}
+		ids := make([]string, len(docIndexes))
+		for i, d := range docIndexes {
+			ids[i] = docs[d].responseID
+		}
+
+		topTerms := topCentroidTerms(centroids[cluster], vocab, themeTopTerms)
+		label, inherited := inheritLabel(ids, renamed, used)
+		if !inherited {
+			labelTerms := topTerms
+			if len(labelTerms) > themeLabelTerms {
+				labelTerms = labelTerms[:themeLabelTerms]
+			}
+			label = strings.Join(labelTerms, " / ")
+		}
+		termsJSON, err := json.Marshal(topTerms)
+		if err != nil {
+			return 0, err
+		}
+
+		result, err := tx.Exec(`INSERT INTO survey_themes (field, label, renamed, top_terms, created_at)
+			VALUES (?, ?, ?, ?, ?)`, field.key, label, inherited, string(termsJSON), time.Now())
+		if err != nil {
+			return 0, err
+		}
+		themeID, err := result.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		for _, d := range docIndexes {
+			_, err := tx.Exec(`INSERT INTO survey_theme_assignments (response_id, field, theme_id, similarity)
+				VALUES (?, ?, ?, ?)`, docs[d].responseID, field.key, themeID, similarities[d])
+			if err != nil {
+				return 0, err
+			}
+		}
+		created++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return created, nil
+}
+
+// clusterThemes is the offline job that rebuilds the themes of the free-text
+// questions. Themes an admin renamed keep their label when most of their
+// responses end up together again.
+func clusterThemes(args []string) error {
+	flags := flag.NewFlagSet("cluster-themes", flag.ContinueOnError)
+	fieldList := flags.String("fields", "", "comma-separated free-text fields to cluster (default all)")
+	k := flags.Int("k", 0, "number of themes per field (default chosen from the number of answers)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fields := freeTextFields
+	if *fieldList != "" {
+		fields = nil
+		for _, key := range strings.Split(*fieldList, ",") {
+			field, ok := lookupFreeTextField(strings.TrimSpace(key))
+			if !ok {
+				return fmt.Errorf("unknown free-text field %q", key)
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	for _, field := range fields {
+		count, err := clusterField(field, *k)
+		if err != nil {
+			return fmt.Errorf("error clustering %s: %v", field.key, err)
+		}
+		if count > 0 {
+			log.Printf("Grouped %s into %d themes", field.key, count)
+		}
+	}
+	return nil
+}
+
+// queryThemes loads themes with their sizes among the filtered responses
+func queryThemes(filter responseFilter, condition string, args ...interface{}) ([]Theme, error) {
+	query := `SELECT t.id, t.field, t.label, t.renamed, t.top_terms, t.created_at, COALESCE(s.size, 0)
+		FROM survey_themes t
+		LEFT JOIN (
+			SELECT theme_id, COUNT(*) AS size FROM survey_theme_assignments
+			WHERE response_id IN (SELECT id FROM survey_responses` + filter.where() + `)
+			GROUP BY theme_id
+		) s ON s.theme_id = t.id`
+	if condition != "" {
+		query += " WHERE " + condition
+	}
+	query += " ORDER BY t.field, COALESCE(s.size, 0) DESC, t.id"
+
+	rows, err := db.Query(query, append(append([]interface{}(nil), filter.args...), args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	themes := []Theme{}
+	for rows.Next() {
+		var theme Theme
+		var topTerms string
+		if err := rows.Scan(&theme.ID, &theme.Field, &theme.Label, &theme.Renamed, &topTerms,
+			&theme.CreatedAt, &theme.Size); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(topTerms), &theme.TopTerms); err != nil {
+			return nil, err
+		}
+		themes = append(themes, theme)
+	}
+	return themes, rows.Err()
+}
+
+func getTheme(id int64) (Theme, error) {
+	themes, err := queryThemes(responseFilter{}, "t.id = ?", id)
+	if err != nil {
+		return Theme{}, err
+	}
+	if len(themes) == 0 {
+		return Theme{}, sql.ErrNoRows
+	}
+	return themes[0], nil
+}
+
+func themeIDParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid theme id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// getThemes lists the themes, optionally of a single ?field=, sized by the
+// usual segment filters
+func getThemes(c *gin.Context) {
+	filter, err := parseResponseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var themes []Theme
+	if field := c.Query("field"); field != "" {
+		if _, ok := lookupFreeTextField(field); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown free-text field %q", field)})
+			return
+		}
+		themes, err = queryThemes(filter, "t.field = ?", field)
+	} else {
+		themes, err = queryThemes(filter, "")
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, themes)
+}
+
+// getThemeResponses returns a theme with its answers, most typical first
+func getThemeResponses(c *gin.Context) {
+	id, ok := themeIDParam(c)
+	if !ok {
+		return
+	}
+	filter, err := parseResponseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	themes, err := queryThemes(filter, "t.id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(themes) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "theme not found"})
+		return
+	}
+	theme := themes[0]
+	field, _ := lookupFreeTextField(theme.Field)
+
+	scoped := filter.with("a.theme_id = ?", id)
+	rows, err := db.Query(`SELECT id, COALESCE(`+field.column+`, ''), created_at, a.similarity
+		FROM survey_responses JOIN survey_theme_assignments a ON a.response_id = id AND a.field = ?`+
+		scoped.where()+` ORDER BY a.similarity DESC`, append([]interface{}{theme.Field}, scoped.args...)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	responses := []ThemeResponse{}
+	for rows.Next() {
+		var r ThemeResponse
+		if err := rows.Scan(&r.ResponseID, &r.Text, &r.CreatedAt, &r.Similarity); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		r.Similarity = roundTo(r.Similarity, 3)
+		responses = append(responses, r)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"theme":     theme,
+		"responses": responses,
+	})
+}
+
+func renameTheme(c *gin.Context) {
+	id, ok := themeIDParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Label string `json:"label"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Label = strings.TrimSpace(req.Label)
+	if req.Label == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "label is required"})
+		return
+	}
+
+	result, err := db.Exec(`UPDATE survey_themes SET label = ?, renamed = 1 WHERE id = ?`, req.Label, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "theme not found"})
+		return
+	}
+
+	theme, err := getTheme(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, theme)
+}
+
+// mergeTheme moves every response of a theme into another theme of the same
+// question and removes the emptied theme
+func mergeTheme(c *gin.Context) {
+	id, ok := themeIDParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Into int64 `json:"into"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Into == id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot merge a theme into itself"})
+		return
+	}
+
+	source, err := getTheme(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "theme not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	target, err := getTheme(req.Into)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "target theme not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if source.Field != target.Field {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "themes belong to different questions"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE survey_theme_assignments SET theme_id = ? WHERE theme_id = ?`, target.ID, source.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// A merged theme is a manual grouping, so its label is kept on re-runs
+	if _, err := tx.Exec(`UPDATE survey_themes SET renamed = 1 WHERE id = ?`, target.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM survey_themes WHERE id = ?`, source.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	merged, err := getTheme(target.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, merged)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestInheritLabel(t *testing.T) {
+	renamed := []renamedTheme{
+		{label: "Sync", responses: map[string]bool{"a": true, "b": true, "c": true}},
+		{label: "Export", responses: map[string]bool{"d": true}},
+	}
+	used := make(map[int]bool)
+
+	if label, ok := inheritLabel([]string{"a", "b", "x"}, renamed, used); !ok || label != "Sync" {
+		t.Errorf("majority overlap inherited %q, %v; want Sync", label, ok)
+	}
+	// Each label is handed on only once
+	if _, ok := inheritLabel([]string{"a", "b", "c"}, renamed, used); ok {
+		t.Error("Sync was inherited twice")
+	}
+	// Half of the members is not a majority
+	if _, ok := inheritLabel([]string{"d", "y"}, renamed, used); ok {
+		t.Error("Export was inherited without a majority")
+	}
+}
+
+// seedThemeAnswers submits the clustering answers as specificProblems, with
+// the pricing answers coming from designers
+func seedThemeAnswers(t *testing.T) {
+	t.Helper()
+	openTestDB(t)
+	for group, answers := range clusteringAnswers {
+		role := "Developer"
+		if group == 2 {
+			role = "Designer"
+		}
+		for _, text := range answers {
+			submitTestSurvey(t, fmt.Sprintf(`{"role":%q,"specificProblems":%q}`, role, text))
+		}
+	}
+}
+
+func listTestThemes(t *testing.T, target string) []Theme {
+	t.Helper()
+	recorder := callHandler(getThemes, "GET", target, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s returned %d: %s", target, recorder.Code, recorder.Body.String())
+	}
+	var themes []Theme
+	if err := json.Unmarshal(recorder.Body.Bytes(), &themes); err != nil {
+		t.Fatal(err)
+	}
+	return themes
+}
+
+// themeWithTerm finds the theme whose top terms include the given stem
+func themeWithTerm(themes []Theme, term string) (Theme, bool) {
+	for _, theme := range themes {
+		for _, top := range theme.TopTerms {
+			if top == term {
+				return theme, true
+			}
+		}
+	}
+	return Theme{}, false
+}
+
+func TestClusterThemes(t *testing.T) {
+	seedThemeAnswers(t)
+	if err := clusterThemes([]string{"-fields", "specificProblems", "-k", "3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	themes := listTestThemes(t, "/analysis/themes?field=specificProblems")
+	if len(themes) != 3 {
+		t.Fatalf("got %d themes, want 3: %+v", len(themes), themes)
+	}
+	for _, theme := range themes {
+		if theme.Size != 4 || theme.Renamed || len(strings.Split(theme.Label, " / ")) != themeLabelTerms {
+			t.Errorf("unexpected theme %+v", theme)
+		}
+	}
+
+	// Sizes follow the segment filters
+	designers := listTestThemes(t, "/analysis/themes?role=Designer")
+	pdf, ok := themeWithTerm(designers, "pdf")
+	if !ok || pdf.Size != 0 {
+		t.Errorf("PDF theme among designers = %+v, want size 0", pdf)
+	}
+
+	id := strconv.FormatInt(themes[0].ID, 10)
+	recorder := callHandler(getThemeResponses, "GET", "/analysis/themes/"+id, nil, gin.Param{Key: "id", Value: id})
+	var detail struct {
+		Theme     Theme           `json:"theme"`
+		Responses []ThemeResponse `json:"responses"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.Responses) != 4 || detail.Responses[0].Similarity < detail.Responses[3].Similarity {
+		t.Errorf("theme responses = %+v, want 4 ordered by similarity", detail.Responses)
+	}
+
+	if err := clusterThemes([]string{"-fields", "email"}); err == nil {
+		t.Error("clusterThemes accepted an unknown field")
+	}
+}
+
+func TestRenamedThemesSurviveReclustering(t *testing.T) {
+	seedThemeAnswers(t)
+	if err := clusterThemes([]string{"-fields", "specificProblems", "-k", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	pdf, ok := themeWithTerm(listTestThemes(t, "/analysis/themes"), "pdf")
+	if !ok {
+		t.Fatal("no PDF theme")
+	}
+
+	id := strconv.FormatInt(pdf.ID, 10)
+	recorder := callHandler(renameTheme, "PUT", "/analysis/themes/"+id, strings.NewReader(`{"label":"PDF export"}`),
+		gin.Param{Key: "id", Value: id})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("renameTheme returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if err := clusterThemes([]string{"-fields", "specificProblems", "-k", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	pdf, _ = themeWithTerm(listTestThemes(t, "/analysis/themes"), "pdf")
+	if pdf.Label != "PDF export" || !pdf.Renamed {
+		t.Errorf("PDF theme after re-run = %+v, want the admin label kept", pdf)
+	}
+}
+
+func TestMergeTheme(t *testing.T) {
+	seedThemeAnswers(t)
+	if err := clusterThemes([]string{"-fields", "specificProblems", "-k", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	themes := listTestThemes(t, "/analysis/themes")
+	source, target := strconv.FormatInt(themes[0].ID, 10), themes[1].ID
+
+	merge := func(id, body string) *http.Response {
+		recorder := callHandler(mergeTheme, "POST", "/analysis/themes/"+id+"/merge", strings.NewReader(body),
+			gin.Param{Key: "id", Value: id})
+		return recorder.Result()
+	}
+
+	if code := merge(source, `{"into":`+source+`}`).StatusCode; code != http.StatusBadRequest {
+		t.Errorf("merging into itself returned %d, want 400", code)
+	}
+	if code := merge(source, `{"into":9999}`).StatusCode; code != http.StatusNotFound {
+		t.Errorf("merging into a missing theme returned %d, want 404", code)
+	}
+	if code := merge(source, fmt.Sprintf(`{"into":%d}`, target)).StatusCode; code != http.StatusOK {
+		t.Fatalf("merge returned %d", code)
+	}
+
+	themes = listTestThemes(t, "/analysis/themes")
+	if len(themes) != 2 || themes[0].ID != target || themes[0].Size != 8 || !themes[0].Renamed {
+		t.Errorf("themes after merge = %+v", themes)
+	}
+}
+
+func TestDeleteResultRemovesThemeAssignments(t *testing.T) {
+	seedThemeAnswers(t)
+	if err := clusterThemes([]string{"-fields", "specificProblems", "-k", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	var id string
+	if err := db.QueryRow(`SELECT response_id FROM survey_theme_assignments LIMIT 1`).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if recorder := callHandler(deleteResult, "DELETE", "/results/"+id, nil, gin.Param{Key: "id", Value: id}); recorder.Code != http.StatusOK {
+		t.Fatalf("deleteResult returned %d", recorder.Code)
+	}
+
+	var remaining int
+	db.QueryRow(`SELECT COUNT(*) FROM survey_theme_assignments WHERE response_id = ?`, id).Scan(&remaining)
+	if remaining != 0 {
+		t.Errorf("%d theme assignments left for the deleted response", remaining)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Submissions are pre-aggregated in SQL into 15 minute UTC slots, which line
+// up with the bucket boundaries of every real-world time zone offset
+const timeseriesSlotSeconds = 900
+
+const maxTimeseriesPoints = 5000
+
+type TimeseriesPoint struct {
+	Period    string    `json:"period"`
+	Start     time.Time `json:"start"`
+	Value     float64   `json:"value"`
+	Responses int       `json:"responses"`
+}
+
+type Timeseries struct {
+	Metric   string            `json:"metric"`
+	Interval string            `json:"interval"`
+	TimeZone string            `json:"timeZone"`
+	Points   []TimeseriesPoint `json:"points"`
+}
+
+type timeseriesBucket struct {
+	responses int
+	sum       float64
+	n         int
+}
+
+// timeseriesExpressions returns the SQL sum and count for a metric; the point
+// value is sum/count for averages and sum otherwise
+func timeseriesExpressions(metric string) (sum, count string, average bool, err error) {
+	switch {
+	case metric == "count":
+		return "COUNT(*)", "COUNT(*)", false, nil
+	case metric == "betaInterest":
+		return "COALESCE(SUM(CASE WHEN beta_interest = 1 THEN 1 ELSE 0 END), 0)", "COUNT(*)", false, nil
+	case strings.HasPrefix(metric, "featureAvg."):
+		column, ok := featureColumns[strings.TrimPrefix(metric, "featureAvg.")]
+		if !ok {
+			return "", "", false, fmt.Errorf("unknown feature in metric %q", metric)
+		}
+		// Skipped questions are stored as 0 and left out of the average
+		return "COALESCE(SUM(NULLIF(" + column + ", 0)), 0)", "COUNT(NULLIF(" + column + ", 0))", true, nil
+	}
+	return "", "", false, fmt.Errorf("metric must be count, betaInterest or featureAvg.<feature>")
+}
+
+// slotColumns returns the metrics_time_slots columns holding a metric's sum
+// and count. Feature averages divide by the responses that answered the
+// feature, every other metric by whole responses.
+func slotColumns(metric string) (sum, count string) {
+	switch {
+	case metric == "betaInterest":
+		return "beta_interest", "responses"
+	case strings.HasPrefix(metric, "featureAvg."):
+		name := strings.TrimPrefix(metric, "featureAvg.")
+		return featureColumns[name], answeredColumn(name)
+	}
+	return "responses", "responses"
+}
+
+// bucketStart returns the start of the day, ISO week or month containing t, in t's location
+func bucketStart(t time.Time, interval string) time.Time {
+	year, month, day := t.Date()
+	switch interval {
+	case "week":
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	}
+}
+
+func nextBucket(start time.Time, interval string) time.Time {
+	year, month, day := start.Date()
+	switch interval {
+	case "week":
+		return time.Date(year, month, day+7, 0, 0, 0, 0, start.Location())
+	case "month":
+		return time.Date(year, month+1, 1, 0, 0, 0, 0, start.Location())
+	default:
+		return time.Date(year, month, day+1, 0, 0, 0, 0, start.Location())
+	}
+}
+
+func getTimeseries(c *gin.Context) {
+	interval := c.DefaultQuery("interval", "day")
+	if interval != "day" && interval != "week" && interval != "month" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be day, week or month"})
+		return
+	}
+	metric := c.DefaultQuery("metric", "count")
+	sumExpr, countExpr, average, err := timeseriesExpressions(metric)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	loc, err := requestLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := parseResponseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := fmt.Sprintf(`
+		SELECT CAST(strftime('%%s', created_at) AS INTEGER) / %d AS slot,
+			COUNT(*), %s, %s
+		FROM survey_responses%s
+		GROUP BY slot`, timeseriesSlotSeconds, sumExpr, countExpr, filter.where())
+	if len(filter.clauses) == 0 {
+		sumColumn, countColumn := slotColumns(metric)
+		query = `SELECT slot, responses, ` + sumColumn + `, ` + countColumn + `
+			FROM metrics_time_slots WHERE responses > 0`
+	}
+	rows, err := db.Query(query, filter.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	buckets := make(map[time.Time]*timeseriesBucket)
+	var first, last time.Time
+	for rows.Next() {
+		var slot int64
+		var responses, n int
+		var sum float64
+		if err := rows.Scan(&slot, &responses, &sum, &n); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		start := bucketStart(time.Unix(slot*timeseriesSlotSeconds, 0).In(loc), interval)
+		bucket, ok := buckets[start]
+		if !ok {
+			bucket = &timeseriesBucket{}
+			buckets[start] = bucket
+		}
+		bucket.responses += responses
+		bucket.sum += sum
+		bucket.n += n
+
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if last.IsZero() || start.After(last) {
+			last = start
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// An explicit range is zero-filled end to end, otherwise the series spans the data
+	if from := c.Query("from"); from != "" {
+		t, _ := parseTimeParam(from, loc)
+		first = bucketStart(t.In(loc), interval)
+	}
+	if to := c.Query("to"); to != "" {
+		t, _ := parseEndParam(to, loc)
+		last = bucketStart(t.Add(-time.Nanosecond).In(loc), interval)
+	}
+
+	series := Timeseries{Metric: metric, Interval: interval, TimeZone: loc.String(), Points: []TimeseriesPoint{}}
+	if !first.IsZero() && !last.IsZero() {
+		for start := first; !start.After(last); start = nextBucket(start, interval) {
+			if len(series.Points) >= maxTimeseriesPoints {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("range covers more than %d %ss", maxTimeseriesPoints, interval)})
+				return
+			}
+			point := TimeseriesPoint{Period: periodKey(start, interval), Start: start}
+			if bucket, ok := buckets[start]; ok {
+				point.Responses = bucket.responses
+				point.Value = bucket.sum
+				if average && bucket.n > 0 {
+					point.Value = roundTo(bucket.sum/float64(bucket.n), 2)
+				}
+			}
+			series.Points = append(series.Points, point)
+		}
+	}
+
+	c.JSON(http.StatusOK, series)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBucketStart(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Wednesday 4 March 2026, shortly after midnight in Berlin
+	at := time.Date(2026, 3, 4, 0, 30, 0, 0, berlin)
+
+	tests := []struct {
+		interval string
+		want     time.Time
+		next     time.Time
+	}{
+		{"day", time.Date(2026, 3, 4, 0, 0, 0, 0, berlin), time.Date(2026, 3, 5, 0, 0, 0, 0, berlin)},
+		{"week", time.Date(2026, 3, 2, 0, 0, 0, 0, berlin), time.Date(2026, 3, 9, 0, 0, 0, 0, berlin)},
+		{"month", time.Date(2026, 3, 1, 0, 0, 0, 0, berlin), time.Date(2026, 4, 1, 0, 0, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		start := bucketStart(at, tt.interval)
+		if !start.Equal(tt.want) {
+			t.Errorf("bucketStart(%s) = %v, want %v", tt.interval, start, tt.want)
+		}
+		if next := nextBucket(start, tt.interval); !next.Equal(tt.next) {
+			t.Errorf("nextBucket(%s) = %v, want %v", tt.interval, next, tt.next)
+		}
+	}
+
+	// The day containing the switch to summer time is only 23 hours long
+	dst := bucketStart(time.Date(2026, 3, 29, 12, 0, 0, 0, berlin), "day")
+	if hours := nextBucket(dst, "day").Sub(dst).Hours(); hours != 23 {
+		t.Errorf("DST day lasts %v hours, want 23", hours)
+	}
+}
+
+func TestTimeseriesExpressions(t *testing.T) {
+	for _, metric := range []string{"count", "betaInterest", "featureAvg.offline", "featureAvg.pdfHandling"} {
+		if _, _, _, err := timeseriesExpressions(metric); err != nil {
+			t.Errorf("timeseriesExpressions(%q) = %v", metric, err)
+		}
+	}
+	for _, metric := range []string{"", "sum", "featureAvg.", "featureAvg.offline; DROP TABLE survey_responses"} {
+		if _, _, _, err := timeseriesExpressions(metric); err == nil {
+			t.Errorf("timeseriesExpressions(%q) accepted an unknown metric", metric)
+		}
+	}
+}
+
+// seedTimeseriesResponses stores responses at fixed UTC times
+func seedTimeseriesResponses(t *testing.T, responses map[string]string) {
+	t.Helper()
+	openTestDB(t)
+	for at, body := range responses {
+		submitted := submitTestSurvey(t, body)
+		createdAt, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`UPDATE survey_responses SET created_at = ? WHERE id = ?`, createdAt, submitted.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Backdating bypasses the aggregates the unfiltered series reads
+	if err := rebuildAggregates(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getTestTimeseries(t *testing.T, target string) Timeseries {
+	t.Helper()
+	recorder := callHandler(getTimeseries, "GET", target, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s returned %d: %s", target, recorder.Code, recorder.Body.String())
+	}
+	var series Timeseries
+	if err := json.Unmarshal(recorder.Body.Bytes(), &series); err != nil {
+		t.Fatal(err)
+	}
+	return series
+}
+
+func TestGetTimeseries(t *testing.T) {
+	seedTimeseriesResponses(t, map[string]string{
+		"2026-03-01T10:00:00Z": `{"role":"Developer","features":{"offline":4},"betaInterest":true,"email":"a@example.com"}`,
+		"2026-03-01T23:30:00Z": `{"role":"Developer","features":{"offline":2}}`,
+		"2026-03-03T09:00:00Z": `{"role":"Designer","features":{"offline":5}}`,
+	})
+
+	series := getTestTimeseries(t, "/metrics/timeseries")
+	want := []float64{2, 0, 1}
+	if len(series.Points) != len(want) {
+		t.Fatalf("got %d points, want %d: %+v", len(series.Points), len(want), series.Points)
+	}
+	for i, point := range series.Points {
+		if point.Value != want[i] {
+			t.Errorf("point %s = %v, want %v", point.Period, point.Value, want[i])
+		}
+	}
+	if series.Points[1].Period != "2026-03-02" {
+		t.Errorf("gap day period = %q, want 2026-03-02", series.Points[1].Period)
+	}
+
+	// 23:30 UTC is already 2 March in Berlin
+	berlin := getTestTimeseries(t, "/metrics/timeseries?tz=Europe/Berlin")
+	if berlin.TimeZone != "Europe/Berlin" || len(berlin.Points) != 3 || berlin.Points[0].Value != 1 || berlin.Points[1].Value != 1 {
+		t.Errorf("Berlin series = %+v", berlin)
+	}
+
+	average := getTestTimeseries(t, "/metrics/timeseries?metric=featureAvg.offline&interval=month")
+	if len(average.Points) != 1 || average.Points[0].Value != 3.67 || average.Points[0].Responses != 3 {
+		t.Errorf("monthly offline average = %+v, want 3.67 over 3 responses", average.Points)
+	}
+
+	beta := getTestTimeseries(t, "/metrics/timeseries?metric=betaInterest&role=Developer")
+	if len(beta.Points) != 1 || beta.Points[0].Value != 1 || beta.Points[0].Responses != 2 {
+		t.Errorf("beta interest for developers = %+v", beta.Points)
+	}
+
+	// An explicit range is zero-filled at both ends
+	ranged := getTestTimeseries(t, "/metrics/timeseries?from=2026-02-28&to=2026-03-04")
+	if len(ranged.Points) != 5 || ranged.Points[0].Value != 0 || ranged.Points[4].Value != 0 {
+		t.Errorf("ranged series = %+v, want 5 zero-filled days", ranged.Points)
+	}
+}
+
+func TestTimeseriesAverageSkipsUnanswered(t *testing.T) {
+	seedTimeseriesResponses(t, map[string]string{
+		"2026-03-01T10:00:00Z": `{"role":"Developer","features":{"offline":4}}`,
+		"2026-03-01T11:00:00Z": `{"role":"Developer","features":{"offline":2}}`,
+		"2026-03-01T12:00:00Z": `{"role":"Developer","features":{"workflows":3}}`,
+	})
+
+	// The aggregate and the live path both divide by the answered count
+	for _, target := range []string{
+		"/metrics/timeseries?metric=featureAvg.offline",
+		"/metrics/timeseries?metric=featureAvg.offline&role=Developer",
+	} {
+		series := getTestTimeseries(t, target)
+		if len(series.Points) != 1 || series.Points[0].Value != 3 || series.Points[0].Responses != 3 {
+			t.Errorf("%s = %+v, want 3 over 3 responses", target, series.Points)
+		}
+	}
+	if err := checkAggregates(nil); err != nil {
+		t.Errorf("checkAggregates: %v", err)
+	}
+}
+
+func TestGetTimeseriesErrors(t *testing.T) {
+	openTestDB(t)
+	for _, target := range []string{
+		"/metrics/timeseries?interval=hour",
+		"/metrics/timeseries?metric=featureAvg.unknown",
+		"/metrics/timeseries?tz=Mars/Olympus",
+		"/metrics/timeseries?from=2000-01-01&to=2026-01-01",
+	} {
+		if recorder := callHandler(getTimeseries, "GET", target, nil); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, want 400", target, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultLanguage = "en"
+
+var supportedLanguages = []string{"en", "de", "fr"}
+
+// translations holds question and option labels keyed by "question.<id>" and
+// "option.<id>.<value>". English is the canonical text in surveyQuestions.
+var translations = map[string]map[string]string{
+	"de": {
+		"question.teamSize":                "Wie groß ist Ihr Team?",
+		"question.role":                    "Welche Rolle beschreibt Sie am besten?",
+		"question.cmsUsage":                "Nutzen Sie derzeit ein CMS?",
+		"question.features":                "Wie wichtig sind Ihnen diese Funktionen (1-5)?",
+		"question.pricingModel":            "Welches Preismodell bevorzugen Sie?",
+		"question.betaInterest":            "Interesse am Betatest?",
+		"question.email":                   "E-Mail (bei Interesse am Betatest)",
+		"question.biggestFrustrations":     "Was frustriert Sie an aktuellen CMS-Lösungen am meisten?",
+		"question.specificProblems":        "Welche konkreten Probleme möchten Sie lösen?",
+		"question.usageFrequency":          "Wie oft nutzen Sie Ihr CMS?",
+		"question.primaryPurpose":          "Wofür nutzen Sie ein CMS hauptsächlich?",
+		"question.platforms":               "Mit welchen Plattformen arbeiten Sie hauptsächlich?",
+		"question.collaborationChallenges": "Welche Herausforderungen haben Sie bei der Zusammenarbeit im Team?",
+		"question.offlineWorkFrequency":    "Wie oft müssen Sie offline arbeiten?",
+
+		"option.role.Developer":                 "Entwickler:in",
+		"option.role.Content Editor":            "Redakteur:in",
+		"option.role.Designer":                  "Designer:in",
+		"option.role.Manager":                   "Manager:in",
+		"option.role.Other":                     "Andere",
+		"option.cmsUsage.Yes":                   "Ja",
+		"option.cmsUsage.No":                    "Nein",
+		"option.pricingModel.Per User":          "Pro Nutzer",
+		"option.pricingModel.Per Project":       "Pro Projekt",
+		"option.pricingModel.Usage Based":       "Nutzungsbasiert",
+		"option.pricingModel.Flat Rate":         "Pauschalpreis",
+		"option.betaInterest.Yes":               "Ja",
+		"option.betaInterest.No":                "Nein",
+		"option.usageFrequency.Daily":           "Täglich",
+		"option.usageFrequency.Weekly":          "Wöchentlich",
+		"option.usageFrequency.Monthly":         "Monatlich",
+		"option.usageFrequency.Rarely":          "Selten",
+		"option.offlineWorkFrequency.Never":     "Nie",
+		"option.offlineWorkFrequency.Rarely":    "Selten",
+		"option.offlineWorkFrequency.Sometimes": "Manchmal",
+		"option.offlineWorkFrequency.Often":     "Oft",
+		"option.offlineWorkFrequency.Always":    "Immer",
+	},
+	"fr": {
+		"question.teamSize":                "Quelle est la taille de votre équipe ?",
+		"question.role":                    "Quel rôle vous décrit le mieux ?",
+		"question.cmsUsage":                "Utilisez-vous actuellement un CMS ?",
+		"question.features":                "Évaluez l'importance de ces fonctionnalités (1-5)",
+		"question.pricingModel":            "Quel modèle de tarification préférez-vous ?",
+		"question.betaInterest":            "Intéressé·e par le test de la bêta ?",
+		"question.email":                   "E-mail (si vous êtes intéressé·e par la bêta)",
+		"question.biggestFrustrations":     "Quelles sont vos plus grandes frustrations avec les CMS actuels ?",
+		"question.specificProblems":        "Quels problèmes précis cherchez-vous à résoudre ?",
+		"question.usageFrequency":          "À quelle fréquence utilisez-vous votre CMS ?",
+		"question.primaryPurpose":          "Quel est votre usage principal d'un CMS ?",
+		"question.platforms":               "Avec quelles plateformes travaillez-vous principalement ?",
+		"question.collaborationChallenges": "Quelles difficultés rencontrez-vous pour collaborer en équipe ?",
+		"question.offlineWorkFrequency":    "À quelle fréquence devez-vous travailler hors ligne ?",
+
+		"option.role.Developer":                 "Développeur·se",
+		"option.role.Content Editor":            "Rédacteur·rice",
+		"option.role.Designer":                  "Designer",
+		"option.role.Manager":                   "Manager",
+		"option.role.Other":                     "Autre",
+		"option.cmsUsage.Yes":                   "Oui",
+		"option.cmsUsage.No":                    "Non",
+		"option.pricingModel.Per User":          "Par utilisateur",
+		"option.pricingModel.Per Project":       "Par projet",
+		"option.pricingModel.Usage Based":       "À l'usage",
+		"option.pricingModel.Flat Rate":         "Forfait",
+		"option.betaInterest.Yes":               "Oui",
+		"option.betaInterest.No":                "Non",
+		"option.usageFrequency.Daily":           "Tous les jours",
+		"option.usageFrequency.Weekly":          "Toutes les semaines",
+		"option.usageFrequency.Monthly":         "Tous les mois",
+		"option.usageFrequency.Rarely":          "Rarement",
+		"option.offlineWorkFrequency.Never":     "Jamais",
+		"option.offlineWorkFrequency.Rarely":    "Rarement",
+		"option.offlineWorkFrequency.Sometimes": "Parfois",
+		"option.offlineWorkFrequency.Often":     "Souvent",
+		"option.offlineWorkFrequency.Always":    "Toujours",
+	},
+}
+
+// translate looks up a label, falling back to the canonical English text
+func translate(lang, key, fallback string) string {
+	if text, ok := translations[lang][key]; ok {
+		return text
+	}
+	return fallback
+}
+
+func isSupportedLanguage(lang string) bool {
+	for _, supported := range supportedLanguages {
+		if lang == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateLanguage picks the best supported language from an
+// Accept-Language header, honouring q-values and ignoring region subtags
+func negotiateLanguage(header string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{lang: strings.SplitN(tag, "-", 2)[0], q: q})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	for _, c := range candidates {
+		if isSupportedLanguage(c.lang) {
+			return c.lang
+		}
+	}
+	return defaultLanguage
+}
+
+// requestLanguage prefers an explicit ?lang= over the Accept-Language header
+func requestLanguage(c *gin.Context) string {
+	if lang := strings.ToLower(c.Query("lang")); isSupportedLanguage(lang) {
+		return lang
+	}
+	return negotiateLanguage(c.GetHeader("Accept-Language"))
+}
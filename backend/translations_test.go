@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestNegotiateLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", "en"},
+		{"de", "de"},
+		{"de-AT,de;q=0.9,en;q=0.8", "de"},
+		{"FR-ca", "fr"},
+		{"es,fr;q=0.5", "fr"},
+		{"en;q=0.4,fr;q=0.7", "fr"},
+		{"de;q=0,fr;q=0.1", "fr"},
+		{"es,it", "en"},
+		{"*", "en"},
+	}
+	for _, tt := range tests {
+		if got := negotiateLanguage(tt.header); got != tt.want {
+			t.Errorf("negotiateLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if got := translate("de", "option.role.Other", "Other"); got != "Andere" {
+		t.Errorf("translate(de) = %q, want Andere", got)
+	}
+	if got := translate("de", "option.teamSize.1-5", "1-5"); got != "1-5" {
+		t.Errorf("untranslated key = %q, want the fallback", got)
+	}
+	if got := translate("xx", "option.role.Other", "Other"); got != "Other" {
+		t.Errorf("unknown language = %q, want the fallback", got)
+	}
+}
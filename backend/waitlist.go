@@ -0,0 +1,647 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	waitlistPending  = "pending"
+	waitlistInvited  = "invited"
+	waitlistActive   = "active"
+	waitlistDeclined = "declined"
+
+	maxWaitlistBatch = 500
+
+	betaInviteTokenPurpose = "beta-invite"
+)
+
+// waitlistTransitions lists the statuses an entry may move to from each status
+var waitlistTransitions = map[string][]string{
+	waitlistPending:  {waitlistInvited, waitlistDeclined},
+	waitlistInvited:  {waitlistActive, waitlistDeclined, waitlistPending},
+	waitlistActive:   {waitlistDeclined},
+	waitlistDeclined: {waitlistPending},
+}
+
+// Priority is a 0-100 score: up to 70 points for how much the respondent
+// cares about the features the beta is built around, weighted below, and up
+// to 30 for how often they use a CMS and need to work offline.
+var (
+	waitlistFeatureWeights = map[string]float64{
+		"offline":         3,
+		"collaboration":   3,
+		"versionControl":  1,
+		"workflows":       1,
+		"assetManagement": 1,
+		"pdfHandling":     1,
+	}
+	waitlistUsagePoints   = map[string]float64{"Daily": 15, "Weekly": 10, "Monthly": 5}
+	waitlistOfflinePoints = map[string]float64{"Always": 15, "Often": 10, "Sometimes": 5}
+)
+
+type WaitlistEntry struct {
+	ID     string `json:"id"`
+	Email  string `json:"email"`
+	Status string `json:"status"`
+	// Position is the place in the queue of pending entries, starting at 1
+	Position    *int            `json:"position,omitempty"`
+	Priority    float64         `json:"priority"`
+	ResponseID  string          `json:"responseId,omitempty"`
+	JoinedAt    time.Time       `json:"joinedAt"`
+	InvitedAt   *time.Time      `json:"invitedAt,omitempty"`
+	ActivatedAt *time.Time      `json:"activatedAt,omitempty"`
+	DeclinedAt  *time.Time      `json:"declinedAt,omitempty"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+	Response    *SurveyResponse `json:"response,omitempty"`
+}
+
+type WaitlistInviteRequest struct {
+	Count     int      `json:"count"`
+	IDs       []string `json:"ids"`
+	SendEmail bool     `json:"sendEmail"`
+}
+
+func createWaitlistTable() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS beta_waitlist (
+		id TEXT PRIMARY KEY,
+		email TEXT NOT NULL UNIQUE,
+		response_id TEXT,
+		status TEXT NOT NULL DEFAULT 'pending',
+		priority REAL NOT NULL DEFAULT 0,
+		joined_at TIMESTAMP NOT NULL,
+		invited_at TIMESTAMP,
+		activated_at TIMESTAMP,
+		declined_at TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_beta_waitlist_response ON beta_waitlist (response_id)`); err != nil {
+		return err
+	}
+	// invite_token_id is the jti of the one invite token that may still be
+	// redeemed for the entry; redeeming clears it
+	if err := ensureColumn("beta_waitlist", "invite_token_id", "TEXT"); err != nil {
+		return err
+	}
+
+	var entries int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM beta_waitlist`).Scan(&entries); err != nil {
+		return err
+	}
+	if entries > 0 {
+		return nil
+	}
+	return backfillWaitlist()
+}
+
+// backfillWaitlist adds the beta signups made before the waitlist existed,
+// in the order they signed up
+func backfillWaitlist() error {
+	rows, err := db.Query(`SELECT ` + surveyResponseColumns + ` FROM survey_responses
+		WHERE beta_interest = 1 AND COALESCE(email, '') != '' ORDER BY created_at`)
+	if err != nil {
+		return err
+	}
+	var signups []SurveyResponse
+	for rows.Next() {
+		response, err := scanSurveyResponse(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		signups = append(signups, response)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(signups) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := range signups {
+		if err := syncWaitlist(tx, signups[i].ID, &signups[i]); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Added %d earlier beta signups to the waitlist", len(signups))
+	return nil
+}
+
+// waitlistPriority scores how good a fit a respondent is for the beta
+func waitlistPriority(survey *SurveyResponse) float64 {
+	var weighted, weights float64
+	for name, score := range featureScores(survey) {
+		if score < 1 || score > maxFeatureScore {
+			continue
+		}
+		weighted += waitlistFeatureWeights[name] * float64(score) / maxFeatureScore
+		weights += waitlistFeatureWeights[name]
+	}
+	var priority float64
+	if weights > 0 {
+		priority = 70 * weighted / weights
+	}
+	priority += waitlistUsagePoints[survey.UsageFrequency]
+	priority += waitlistOfflinePoints[survey.OfflineWorkFrequency]
+	return roundTo(priority, 2)
+}
+
+// syncWaitlist keeps the waitlist in step with a response in the caller's
+// transaction. current is nil when the response was deleted. An entry the
+// response no longer asks for moves to another response still signing up with
+// that email; failing that, pending entries are dropped and entries that were
+// already invited keep their history but lose the link to the response.
+func syncWaitlist(tx *sql.Tx, responseID string, current *SurveyResponse) error {
+	email := ""
+	if current != nil && isBetaSignup(current) {
+		email = strings.ToLower(strings.TrimSpace(current.Email))
+	}
+	if err := relinkWaitlist(tx, responseID, email); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM beta_waitlist WHERE response_id = ? AND email != ? AND status = ?`,
+		responseID, email, waitlistPending); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE beta_waitlist SET response_id = NULL WHERE response_id = ? AND email != ?`,
+		responseID, email); err != nil {
+		return err
+	}
+	if email == "" {
+		return nil
+	}
+
+	// Signing up again links the entry to the newer response without losing
+	// its place; only pending entries are rescored
+	now := time.Now()
+	_, err := tx.Exec(`INSERT INTO beta_waitlist (id, email, response_id, status, priority, joined_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (email) DO UPDATE SET
+			response_id = excluded.response_id,
+			priority = CASE WHEN status = 'pending' THEN excluded.priority ELSE priority END,
+			updated_at = excluded.updated_at`,
+		uuid.New().String(), email, responseID, waitlistPending, waitlistPriority(current), current.CreatedAt, now)
+	return err
+}
+
+// relinkWaitlist hands the entry linked to responseID over to the newest other
+// response that still signs up with the entry's email, when the response
+// itself no longer does under that email
+func relinkWaitlist(tx *sql.Tx, responseID, email string) error {
+	var entryID, entryEmail string
+	err := tx.QueryRow(`SELECT id, email FROM beta_waitlist WHERE response_id = ? AND email != ?`,
+		responseID, email).Scan(&entryID, &entryEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	other, err := scanSurveyResponse(tx.QueryRow(`SELECT `+surveyResponseColumns+` FROM survey_responses
+		WHERE id != ? AND beta_interest = 1 AND lower(trim(email)) = ?
+		ORDER BY created_at DESC LIMIT 1`, responseID, entryEmail))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE beta_waitlist SET
+			response_id = ?,
+			priority = CASE WHEN status = 'pending' THEN ? ELSE priority END,
+			updated_at = ?
+		WHERE id = ?`,
+		other.ID, waitlistPriority(&other), time.Now(), entryID)
+	return err
+}
+
+// waitlistQuery selects entries with their queue position, which is computed
+// over all pending entries before any filter applies
+const waitlistQuery = `SELECT id, email, status, position, priority, COALESCE(response_id, ''),
+		joined_at, invited_at, activated_at, declined_at, updated_at
+	FROM (SELECT *, CASE WHEN status = 'pending' THEN
+			ROW_NUMBER() OVER (PARTITION BY status ORDER BY priority DESC, joined_at, id)
+		END AS position
+		FROM beta_waitlist)`
+
+func scanWaitlistEntry(row rowScanner) (WaitlistEntry, error) {
+	var entry WaitlistEntry
+	var position sql.NullInt64
+	err := row.Scan(&entry.ID, &entry.Email, &entry.Status, &position, &entry.Priority, &entry.ResponseID,
+		&entry.JoinedAt, &entry.InvitedAt, &entry.ActivatedAt, &entry.DeclinedAt, &entry.UpdatedAt)
+	if position.Valid {
+		p := int(position.Int64)
+		entry.Position = &p
+	}
+	return entry, err
+}
+
+func queryWaitlist(q queryer, where string, args ...interface{}) ([]WaitlistEntry, error) {
+	rows, err := q.Query(waitlistQuery+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []WaitlistEntry{}
+	for rows.Next() {
+		entry, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func validWaitlistStatus(status string) bool {
+	_, ok := waitlistTransitions[status]
+	return ok
+}
+
+// getWaitlist lists entries, pending ones first in queue order
+func getWaitlist(c *gin.Context) {
+	var filter responseFilter
+	if status := c.Query("status"); status != "" {
+		if !validWaitlistStatus(status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, invited, active or declined"})
+			return
+		}
+		filter.add("status = ?", status)
+	}
+	limit, offset, err := pagingParams(c, 100, 1000)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, err := queryWaitlist(db, filter.where()+`
+		ORDER BY position IS NULL, position, updated_at DESC, id LIMIT ? OFFSET ?`,
+		append(filter.args, limit, offset)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// getWaitlistSummary counts entries per status
+func getWaitlistSummary(c *gin.Context) {
+	counts := map[string]int{}
+	for status := range waitlistTransitions {
+		counts[status] = 0
+	}
+	rows, err := db.Query(`SELECT status, COUNT(*) FROM beta_waitlist GROUP BY status`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, counts)
+}
+
+// getWaitlistEntry returns one entry along with the survey response it came from
+func getWaitlistEntry(c *gin.Context) {
+	entry, err := scanWaitlistEntry(db.QueryRow(waitlistQuery+` WHERE id = ?`, c.Param("id")))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "waitlist entry not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if entry.ResponseID != "" {
+		response, err := getSurveyResponse(entry.ResponseID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err == nil {
+			entry.Response = &response
+		}
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+// betaInviteTTL is how long an emailed beta invite link stays valid
+func betaInviteTTL() time.Duration {
+	hours, err := strconv.Atoi(getEnvWithFallback("BETA_INVITE_TTL_HOURS", "336"))
+	if err != nil || hours <= 0 {
+		hours = 336
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// betaInviteKey derives a key from JWT_SECRET so invite tokens are accepted
+// neither as admin tokens nor as edit tokens
+func betaInviteKey() []byte {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte(betaInviteTokenPurpose))
+	return mac.Sum(nil)
+}
+
+// issueBetaInviteToken signs an invite for the entry. tokenID must match the
+// entry's invite_token_id for the token to be redeemed.
+func issueBetaInviteToken(entryID, tokenID string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":     entryID,
+		"jti":     tokenID,
+		"purpose": betaInviteTokenPurpose,
+		"exp":     time.Now().Add(betaInviteTTL()).Unix(),
+		"iat":     time.Now().Unix(),
+	})
+	return token.SignedString(betaInviteKey())
+}
+
+// verifyBetaInviteToken checks the signature and purpose of an invite token
+// and returns the entry and token ids it carries
+func verifyBetaInviteToken(tokenString string) (string, string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return betaInviteKey(), nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != betaInviteTokenPurpose {
+		return "", "", fmt.Errorf("invalid token claims")
+	}
+	entryID, _ := claims["sub"].(string)
+	tokenID, _ := claims["jti"].(string)
+	if entryID == "" || tokenID == "" {
+		return "", "", fmt.Errorf("invalid token claims")
+	}
+	return entryID, tokenID, nil
+}
+
+func sendBetaInvite(entry WaitlistEntry, token string) {
+	base := os.Getenv("BETA_INVITE_URL")
+	if base == "" {
+		return
+	}
+
+	link := fmt.Sprintf("%s?token=%s", base, url.QueryEscape(token))
+	body := fmt.Sprintf("Thanks for waiting! Your spot in the LocalHaven CMS beta is ready.\r\n\r\n"+
+		"Use this link to get started. It can only be used once and expires on %s:\r\n\r\n%s\r\n",
+		time.Now().Add(betaInviteTTL()).UTC().Format("2 January 2006"), link)
+	if err := sendMail(entry.Email, "You're invited to the LocalHaven CMS beta", body); err != nil {
+		log.Printf("Failed to send beta invite to waitlist entry %s: %v", entry.ID, err)
+	}
+}
+
+// inviteWaitlist moves a batch of pending entries to invited: either the
+// given ids or the next count entries in the queue
+func inviteWaitlist(c *gin.Context) {
+	var req WaitlistInviteRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.Count > 0) == (len(req.IDs) > 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provide either count or ids"})
+		return
+	}
+	if req.Count > maxWaitlistBatch || len(req.IDs) > maxWaitlistBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d entries can be invited at once", maxWaitlistBatch)})
+		return
+	}
+	// Refuse rather than mark entries invited that would never hear about it
+	if req.SendEmail && (os.Getenv("BETA_INVITE_URL") == "" || !mailConfigured()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sendEmail requires BETA_INVITE_URL and SMTP to be configured"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var entries []WaitlistEntry
+	if req.Count > 0 {
+		entries, err = queryWaitlist(tx, ` WHERE status = ? ORDER BY position LIMIT ?`, waitlistPending, req.Count)
+	} else {
+		args := []interface{}{waitlistPending}
+		for _, id := range req.IDs {
+			args = append(args, id)
+		}
+		entries, err = queryWaitlist(tx, ` WHERE status = ? AND id IN (?`+strings.Repeat(", ?", len(req.IDs)-1)+`)
+			ORDER BY position`, args...)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.IDs) > 0 && len(entries) < len(req.IDs) {
+		c.JSON(http.StatusConflict, gin.H{"error": "some entries do not exist or are not pending"})
+		return
+	}
+
+	// Each invite gets a fresh token id, so links from any earlier invite of
+	// the same entry stop working
+	now := time.Now()
+	tokens := make([]string, len(entries))
+	for i := range entries {
+		tokenID := uuid.New().String()
+		if _, err := tx.Exec(`UPDATE beta_waitlist SET status = ?, invited_at = ?, updated_at = ?, invite_token_id = ?
+			WHERE id = ?`, waitlistInvited, now, now, tokenID, entries[i].ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if req.SendEmail {
+			if tokens[i], err = issueBetaInviteToken(entries[i].ID, tokenID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		entries[i].Status = waitlistInvited
+		entries[i].Position = nil
+		entries[i].InvitedAt = &now
+		entries[i].UpdatedAt = now
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.SendEmail {
+		go func() {
+			for i, entry := range entries {
+				sendBetaInvite(entry, tokens[i])
+			}
+		}()
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// updateWaitlistStatus moves one entry along, e.g. to active once an invited
+// tester has signed in or to declined when they turn the invite down
+func updateWaitlistStatus(c *gin.Context) {
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validWaitlistStatus(req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, invited, active or declined"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRow(`SELECT status FROM beta_waitlist WHERE id = ?`, c.Param("id")).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "waitlist entry not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	allowed := false
+	for _, next := range waitlistTransitions[current] {
+		allowed = allowed || next == req.Status
+	}
+	if !allowed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("cannot move an entry from %s to %s", current, req.Status)})
+		return
+	}
+
+	// Each status records when it was last entered
+	now := time.Now()
+	timestamp := map[string]string{
+		waitlistInvited:  "invited_at",
+		waitlistActive:   "activated_at",
+		waitlistDeclined: "declined_at",
+	}
+	query := `UPDATE beta_waitlist SET status = ?, updated_at = ?`
+	args := []interface{}{req.Status, now}
+	if column, ok := timestamp[req.Status]; ok {
+		query += `, ` + column + ` = ?`
+		args = append(args, now)
+	}
+	if _, err := tx.Exec(query+` WHERE id = ?`, append(args, c.Param("id"))...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry, err := scanWaitlistEntry(tx.QueryRow(waitlistQuery+` WHERE id = ?`, c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+// redeemBetaInvite activates the entry an emailed invite token was issued
+// for. Each token works once, and only while the entry is still invited.
+func redeemBetaInvite(c *gin.Context) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entryID, tokenID, err := verifyBetaInviteToken(req.Token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Invalid invite token: %v", err)})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`UPDATE beta_waitlist SET status = ?, activated_at = ?, updated_at = ?, invite_token_id = NULL
+		WHERE id = ? AND status = ? AND invite_token_id = ?`,
+		waitlistActive, now, now, entryID, waitlistInvited, tokenID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if updated == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "this invite has already been used or was withdrawn"})
+		return
+	}
+
+	entry, err := scanWaitlistEntry(tx.QueryRow(waitlistQuery+` WHERE id = ?`, entryID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
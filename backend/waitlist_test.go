@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWaitlistPriority(t *testing.T) {
+	tests := []struct {
+		name   string
+		survey SurveyResponse
+		want   float64
+	}{
+		{"no answers", SurveyResponse{}, 0},
+		{"top scores", SurveyResponse{Features: Features{Offline: 5, Collaboration: 5}, UsageFrequency: "Daily", OfflineWorkFrequency: "Always"}, 100},
+		// Offline weighs three times as much as PDF handling
+		{"weighted features", SurveyResponse{Features: Features{Offline: 5, PdfHandling: 1}}, 56},
+		{"usage only", SurveyResponse{UsageFrequency: "Weekly", OfflineWorkFrequency: "Sometimes"}, 15},
+	}
+	for _, tt := range tests {
+		if got := waitlistPriority(&tt.survey); got != tt.want {
+			t.Errorf("%s: waitlistPriority() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func getTestWaitlist(t *testing.T, target string) []WaitlistEntry {
+	t.Helper()
+	recorder := callHandler(getWaitlist, "GET", target, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s returned %d: %s", target, recorder.Code, recorder.Body.String())
+	}
+	var entries []WaitlistEntry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func submitBetaSignup(t *testing.T, email string, offline int) surveySubmissionResult {
+	t.Helper()
+	return submitTestSurvey(t, fmt.Sprintf(`{"role":"developer","cmsUsage":"wordpress","betaInterest":true,"email":%q,"features":{"offline":%d}}`,
+		email, offline))
+}
+
+func TestWaitlistQueue(t *testing.T) {
+	openTestDB(t)
+	submitBetaSignup(t, "low@example.com", 2)
+	submitBetaSignup(t, "high@example.com", 5)
+	submitBetaSignup(t, "mid@example.com", 4)
+	// Without an email there is nobody to invite
+	submitTestSurvey(t, `{"role":"developer","betaInterest":true}`)
+
+	entries := getTestWaitlist(t, "/waitlist")
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	for i, email := range []string{"high@example.com", "mid@example.com", "low@example.com"} {
+		if entries[i].Email != email || entries[i].Position == nil || *entries[i].Position != i+1 {
+			t.Errorf("entry %d = %s at %v, want %s", i, entries[i].Email, entries[i].Position, email)
+		}
+	}
+
+	recorder := callHandler(inviteWaitlist, "POST", "/waitlist/invite", strings.NewReader(`{"count":2}`))
+	var invited []WaitlistEntry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &invited); err != nil {
+		t.Fatal(err)
+	}
+	if len(invited) != 2 || invited[0].Email != "high@example.com" || invited[0].Status != waitlistInvited || invited[0].InvitedAt == nil {
+		t.Errorf("invited = %+v, want the first two in the queue", invited)
+	}
+	pending := getTestWaitlist(t, "/waitlist?status=pending")
+	if len(pending) != 1 || pending[0].Email != "low@example.com" || *pending[0].Position != 1 {
+		t.Errorf("pending after invites = %+v", pending)
+	}
+
+	// Listing ids only invites entries that are still pending
+	body := fmt.Sprintf(`{"ids":[%q,%q]}`, pending[0].ID, invited[0].ID)
+	if conflict := callHandler(inviteWaitlist, "POST", "/waitlist/invite", strings.NewReader(body)); conflict.Code != http.StatusConflict {
+		t.Errorf("inviting an invited entry returned %d, want 409", conflict.Code)
+	}
+	for _, body := range []string{`{}`, `{"count":1,"ids":["x"]}`, `{"count":501}`} {
+		if recorder := callHandler(inviteWaitlist, "POST", "/waitlist/invite", strings.NewReader(body)); recorder.Code != http.StatusBadRequest {
+			t.Errorf("inviteWaitlist(%s) returned %d, want 400", body, recorder.Code)
+		}
+	}
+
+	var summary map[string]int
+	if err := json.Unmarshal(callHandler(getWaitlistSummary, "GET", "/waitlist/summary", nil).Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary[waitlistPending] != 1 || summary[waitlistInvited] != 2 || summary[waitlistActive] != 0 {
+		t.Errorf("summary = %v", summary)
+	}
+}
+
+func TestUpdateWaitlistStatus(t *testing.T) {
+	openTestDB(t)
+	response := submitBetaSignup(t, "ada@example.com", 5)
+	entries := getTestWaitlist(t, "/waitlist")
+	param := gin.Param{Key: "id", Value: entries[0].ID}
+
+	update := func(status string) int {
+		body := fmt.Sprintf(`{"status":%q}`, status)
+		return callHandler(updateWaitlistStatus, "PUT", "/waitlist/"+entries[0].ID+"/status", strings.NewReader(body), param).Code
+	}
+	if code := update(waitlistActive); code != http.StatusConflict {
+		t.Errorf("pending to active returned %d, want 409", code)
+	}
+	if code := update("unknown"); code != http.StatusBadRequest {
+		t.Errorf("unknown status returned %d, want 400", code)
+	}
+	for _, status := range []string{waitlistInvited, waitlistActive} {
+		if code := update(status); code != http.StatusOK {
+			t.Fatalf("moving to %s returned %d", status, code)
+		}
+	}
+
+	recorder := callHandler(getWaitlistEntry, "GET", "/waitlist/"+entries[0].ID, nil, param)
+	var entry WaitlistEntry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != waitlistActive || entry.ActivatedAt == nil || entry.Response == nil || entry.Response.ID != response.ID {
+		t.Errorf("entry = %+v", entry)
+	}
+	missing := callHandler(getWaitlistEntry, "GET", "/waitlist/missing", nil, gin.Param{Key: "id", Value: "missing"})
+	if missing.Code != http.StatusNotFound {
+		t.Errorf("unknown entry returned %d, want 404", missing.Code)
+	}
+}
+
+func TestWaitlistFollowsResponses(t *testing.T) {
+	openTestDB(t)
+	optOut := submitBetaSignup(t, "optout@example.com", 3)
+	invited := submitBetaSignup(t, "invited@example.com", 5)
+	callHandler(inviteWaitlist, "POST", "/waitlist/invite", strings.NewReader(`{"count":1}`))
+
+	// Dropping beta interest removes a pending entry
+	recorder := callHandler(updateResult, "PUT", "/results/"+optOut.ID,
+		strings.NewReader(`{"role":"developer","cmsUsage":"wordpress","email":"optout@example.com"}`),
+		gin.Param{Key: "id", Value: optOut.ID})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("updateResult returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	// An invited entry outlives its response but loses the link
+	callHandler(deleteResult, "DELETE", "/results/"+invited.ID, nil, gin.Param{Key: "id", Value: invited.ID})
+
+	entries := getTestWaitlist(t, "/waitlist")
+	if len(entries) != 1 || entries[0].Email != "invited@example.com" || entries[0].ResponseID != "" {
+		t.Errorf("entries = %+v, want only the invited one, unlinked", entries)
+	}
+
+	// Signing up again with the same address keeps the existing entry
+	again := submitBetaSignup(t, " Invited@Example.com ", 1)
+	entries = getTestWaitlist(t, "/waitlist")
+	if len(entries) != 1 || entries[0].ResponseID != again.ID || entries[0].Status != waitlistInvited {
+		t.Errorf("entries after signing up again = %+v", entries)
+	}
+}
+
+func saveBetaResponse(t *testing.T, id string, createdAt time.Time) {
+	t.Helper()
+	survey := SurveyResponse{
+		ID:           id,
+		Role:         "developer",
+		CmsUsage:     "wordpress",
+		BetaInterest: true,
+		Email:        "Grace@Example.com",
+		Features:     Features{Offline: 5},
+		CreatedAt:    createdAt,
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := insertSurveyResponse(tx, &survey); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncWaitlist(tx, id, &survey); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitlistResponseID(t *testing.T) (string, bool) {
+	t.Helper()
+	entries, err := queryWaitlist(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		return "", false
+	}
+	if len(entries) > 1 {
+		t.Fatalf("got %d waitlist entries, want one per email", len(entries))
+	}
+	return entries[0].ResponseID, true
+}
+
+func TestDeletingNewerSignupKeepsWaitlistEntry(t *testing.T) {
+	openTestDB(t)
+	saveBetaResponse(t, "older", time.Now().Add(-time.Hour))
+	saveBetaResponse(t, "newer", time.Now())
+	if id, _ := waitlistResponseID(t); id != "newer" {
+		t.Fatalf("entry linked to %q, want the newer response", id)
+	}
+
+	deleteTestResponse(t, "newer")
+	id, ok := waitlistResponseID(t)
+	if !ok {
+		t.Fatal("entry was dropped although the older response still signs up")
+	}
+	if id != "older" {
+		t.Errorf("entry linked to %q, want the older response", id)
+	}
+
+	deleteTestResponse(t, "older")
+	if _, ok := waitlistResponseID(t); ok {
+		t.Error("pending entry kept after every signup was deleted")
+	}
+}
+
+func TestInviteWaitlistEmailNeedsInviteURL(t *testing.T) {
+	openTestDB(t)
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("SMTP_FROM", "beta@example.com")
+	t.Setenv("BETA_INVITE_URL", "")
+	saveBetaResponse(t, "signup", time.Now())
+
+	recorder := callHandler(inviteWaitlist, "POST", "/waitlist/invite", strings.NewReader(`{"count":1,"sendEmail":true}`))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("invite returned %d, want 400: %s", recorder.Code, recorder.Body.String())
+	}
+	entries, err := queryWaitlist(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Status != waitlistPending {
+		t.Errorf("entries = %+v, want the entry still pending", entries)
+	}
+}
+
+func redeemTestInvite(token string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"token":%q}`, token)
+	return callHandler(redeemBetaInvite, "POST", "/waitlist/redeem", strings.NewReader(body))
+}
+
+func TestRedeemBetaInvite(t *testing.T) {
+	openTestDB(t)
+	t.Setenv("JWT_SECRET", "test-secret")
+	saveBetaResponse(t, "signup", time.Now())
+	callHandler(inviteWaitlist, "POST", "/waitlist/invite", strings.NewReader(`{"count":1}`))
+
+	var entryID, tokenID string
+	if err := db.QueryRow(`SELECT id, invite_token_id FROM beta_waitlist`).Scan(&entryID, &tokenID); err != nil {
+		t.Fatal(err)
+	}
+	token, err := issueBetaInviteToken(entryID, tokenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither edit tokens nor tokens for an earlier invite are accepted
+	editToken, _, err := issueEditToken(entryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := redeemTestInvite(editToken); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("redeeming an edit token returned %d, want 401", recorder.Code)
+	}
+	stale, err := issueBetaInviteToken(entryID, "earlier-invite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder := redeemTestInvite(stale); recorder.Code != http.StatusConflict {
+		t.Errorf("redeeming a replaced token returned %d, want 409", recorder.Code)
+	}
+
+	recorder := redeemTestInvite(token)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("redeem returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var entry WaitlistEntry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID != entryID || entry.Status != waitlistActive || entry.ActivatedAt == nil {
+		t.Errorf("redeemed entry = %+v, want it active", entry)
+	}
+	if recorder := redeemTestInvite(token); recorder.Code != http.StatusConflict {
+		t.Errorf("redeeming twice returned %d, want 409", recorder.Code)
+	}
+}
@@ -0,0 +1,743 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	webhookResponseCreated = "response.created"
+	webhookResponseDeleted = "response.deleted"
+	webhookBetaSignup      = "beta.signup"
+	webhookTest            = "webhook.test"
+
+	webhookBatchSize = 20
+	// Bytes of a receiver's reply kept for the delivery history
+	webhookBodyLimit = 1024
+)
+
+// webhookEvents are the event types endpoints can subscribe to
+var webhookEvents = []string{webhookResponseCreated, webhookResponseDeleted, webhookBetaSignup}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookMaxAttempts is how often a delivery is tried before it is dead-lettered
+func webhookMaxAttempts() int {
+	attempts, err := strconv.Atoi(getEnvWithFallback("WEBHOOK_MAX_ATTEMPTS", "8"))
+	if err != nil || attempts <= 0 {
+		return 8
+	}
+	return attempts
+}
+
+// webhookRetryBase is the wait before the first retry; it doubles each time
+func webhookRetryBase() time.Duration {
+	seconds, err := strconv.Atoi(getEnvWithFallback("WEBHOOK_RETRY_BASE_SECONDS", "30"))
+	if err != nil || seconds <= 0 {
+		seconds = 30
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func webhookPollInterval() time.Duration {
+	seconds, err := strconv.Atoi(getEnvWithFallback("WEBHOOK_POLL_SECONDS", "5"))
+	if err != nil || seconds <= 0 {
+		seconds = 5
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+const maxWebhookRetryDelay = 6 * time.Hour
+
+// webhookRetryDelay backs off exponentially after the given number of failed attempts
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := float64(webhookRetryBase()) * math.Pow(2, float64(attempts-1))
+	if delay > float64(maxWebhookRetryDelay) {
+		return maxWebhookRetryDelay
+	}
+	return time.Duration(delay)
+}
+
+func createWebhookTables() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS webhook_endpoints (
+		id TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events JSON NOT NULL,
+		active INTEGER NOT NULL DEFAULT 1,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT PRIMARY KEY,
+		endpoint_id TEXT NOT NULL,
+		event_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload JSON NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP,
+		last_status_code INTEGER,
+		last_error TEXT,
+		created_at TIMESTAMP NOT NULL,
+		delivered_at TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due
+		ON webhook_deliveries (status, next_attempt_at)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		delivery_id TEXT NOT NULL,
+		attempted_at TIMESTAMP NOT NULL,
+		status_code INTEGER,
+		error TEXT,
+		response_body TEXT,
+		duration_ms INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery
+		ON webhook_delivery_attempts (delivery_id)`)
+	return err
+}
+
+type WebhookEndpoint struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+type WebhookAttempt struct {
+	AttemptedAt  time.Time `json:"attemptedAt"`
+	StatusCode   *int      `json:"statusCode,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ResponseBody string    `json:"responseBody,omitempty"`
+	DurationMs   int64     `json:"durationMs"`
+}
+
+type WebhookDelivery struct {
+	ID             string           `json:"id"`
+	EndpointID     string           `json:"endpointId"`
+	EventID        string           `json:"eventId"`
+	Event          string           `json:"event"`
+	Payload        json.RawMessage  `json:"payload"`
+	Status         string           `json:"status"`
+	Attempts       int              `json:"attempts"`
+	NextAttemptAt  *time.Time       `json:"nextAttemptAt,omitempty"`
+	LastStatusCode *int             `json:"lastStatusCode,omitempty"`
+	LastError      string           `json:"lastError,omitempty"`
+	CreatedAt      time.Time        `json:"createdAt"`
+	DeliveredAt    *time.Time       `json:"deliveredAt,omitempty"`
+	History        []WebhookAttempt `json:"history,omitempty"`
+}
+
+// webhookEnvelope is the JSON body posted to receivers
+type webhookEnvelope struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// signWebhook computes the X-Webhook-Signature header: an HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the endpoint secret. Including the timestamp
+// lets receivers reject old payloads replayed by a third party.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// enqueueWebhook queues an event for every active endpoint subscribed to it.
+// It runs in the caller's transaction so events are only sent for changes
+// that were committed.
+func enqueueWebhook(tx *sql.Tx, event string, data interface{}) error {
+	rows, err := tx.Query(`SELECT id, events FROM webhook_endpoints WHERE active = 1`)
+	if err != nil {
+		return err
+	}
+	var endpointIDs []string
+	for rows.Next() {
+		var id, events string
+		if err := rows.Scan(&id, &events); err != nil {
+			rows.Close()
+			return err
+		}
+		var subscribed []string
+		if err := json.Unmarshal([]byte(events), &subscribed); err != nil {
+			rows.Close()
+			return err
+		}
+		if containsString(subscribed, event) {
+			endpointIDs = append(endpointIDs, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(endpointIDs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	envelope := webhookEnvelope{ID: uuid.New().String(), Type: event, CreatedAt: now.UTC(), Data: data}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	for _, endpointID := range endpointIDs {
+		_, err := tx.Exec(`INSERT INTO webhook_deliveries (id, endpoint_id, event_id, event, payload, next_attempt_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			uuid.New().String(), endpointID, envelope.ID, event, string(payload), now, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isBetaSignup reports whether a response asks to join the beta with an address to reach
+func isBetaSignup(survey *SurveyResponse) bool {
+	return survey.BetaInterest && strings.TrimSpace(survey.Email) != ""
+}
+
+// enqueueBetaSignup sends beta.signup when a response newly opts into the beta.
+// previous is nil for new responses.
+func enqueueBetaSignup(tx *sql.Tx, previous *SurveyResponse, current *SurveyResponse) error {
+	if !isBetaSignup(current) || (previous != nil && isBetaSignup(previous)) {
+		return nil
+	}
+	return enqueueWebhook(tx, webhookBetaSignup, gin.H{
+		"responseId": current.ID,
+		"email":      strings.TrimSpace(current.Email),
+		"role":       current.Role,
+		"teamSize":   current.TeamSize,
+		"language":   current.Language,
+		"createdAt":  current.CreatedAt,
+	})
+}
+
+// redactWebhookDeliveries strips the respondent's email address from the
+// stored payloads about a deleted response, whatever their status, so neither
+// retries nor replays send it again
+func redactWebhookDeliveries(tx *sql.Tx, responseID string) error {
+	_, err := tx.Exec(`UPDATE webhook_deliveries SET payload = json_remove(payload, '$.data.email')
+		WHERE event IN (?, ?) AND json_extract(payload, '$.data.email') IS NOT NULL
+			AND ? IN (json_extract(payload, '$.data.id'), json_extract(payload, '$.data.responseId'))`,
+		webhookResponseCreated, webhookBetaSignup, responseID)
+	return err
+}
+
+// runWebhookDispatcher delivers due webhooks until the process exits
+func runWebhookDispatcher() {
+	ticker := time.NewTicker(webhookPollInterval())
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := deliverDueWebhooks(time.Now()); err != nil {
+			log.Printf("Webhook delivery failed: %v", err)
+		}
+	}
+}
+
+type dueDelivery struct {
+	id       string
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+}
+
+// deliverDueWebhooks sends pending deliveries whose retry time has come.
+// Deliveries to a deactivated endpoint wait until it is active again.
+func deliverDueWebhooks(now time.Time) error {
+	rows, err := db.Query(`SELECT d.id, d.event, d.payload, d.attempts, e.url, e.secret
+		FROM webhook_deliveries d
+		JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		WHERE d.status = 'pending' AND e.active = 1 AND julianday(d.next_attempt_at) <= julianday(?)
+		ORDER BY d.next_attempt_at
+		LIMIT ?`, now, webhookBatchSize)
+	if err != nil {
+		return err
+	}
+	var due []dueDelivery
+	for rows.Next() {
+		var d dueDelivery
+		var payload string
+		if err := rows.Scan(&d.id, &d.event, &payload, &d.attempts, &d.url, &d.secret); err != nil {
+			rows.Close()
+			return err
+		}
+		d.payload = []byte(payload)
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		if err := attemptDelivery(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attemptDelivery posts one delivery and records the outcome. Any 2xx reply
+// counts as delivered; anything else is retried with backoff until the
+// attempts run out and the delivery moves to the dead-letter list.
+func attemptDelivery(d dueDelivery) error {
+	started := time.Now()
+	var statusCode *int
+	var responseBody, errMessage string
+
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(d.payload))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "LocalHavenCMS-Webhooks/1.0")
+		req.Header.Set("X-Webhook-Event", d.event)
+		req.Header.Set("X-Webhook-Delivery", d.id)
+		req.Header.Set("X-Webhook-Signature", signWebhook(d.secret, started.Unix(), d.payload))
+
+		var resp *http.Response
+		resp, err = webhookClient.Do(req)
+		if err == nil {
+			code := resp.StatusCode
+			statusCode = &code
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookBodyLimit))
+			resp.Body.Close()
+			responseBody = string(body)
+			if code < 200 || code > 299 {
+				err = fmt.Errorf("receiver responded with status %d", code)
+			}
+		}
+	}
+	if err != nil {
+		errMessage = err.Error()
+	}
+	finished := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO webhook_delivery_attempts (delivery_id, attempted_at, status_code, error, response_body, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		d.id, started, statusCode, errMessage, responseBody, finished.Sub(started).Milliseconds())
+	if err != nil {
+		return err
+	}
+
+	attempts := d.attempts + 1
+	switch {
+	case errMessage == "":
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET status = 'delivered', attempts = ?, next_attempt_at = NULL,
+			last_status_code = ?, last_error = NULL, delivered_at = ? WHERE id = ?`,
+			attempts, statusCode, finished, d.id)
+	case attempts >= webhookMaxAttempts():
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET status = 'dead', attempts = ?, next_attempt_at = NULL,
+			last_status_code = ?, last_error = ? WHERE id = ?`,
+			attempts, statusCode, errMessage, d.id)
+	default:
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET attempts = ?, next_attempt_at = ?,
+			last_status_code = ?, last_error = ? WHERE id = ?`,
+			attempts, finished.Add(webhookRetryDelay(attempts)), statusCode, errMessage, d.id)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func generateWebhookSecret() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(raw), nil
+}
+
+func validateWebhookRequest(req *WebhookRequest) error {
+	req.URL = strings.TrimSpace(req.URL)
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if len(req.Events) == 0 {
+		return fmt.Errorf("at least one event is required")
+	}
+	for _, event := range req.Events {
+		if !containsString(webhookEvents, event) {
+			return fmt.Errorf("unknown event %q, expected one of %s", event, strings.Join(webhookEvents, ", "))
+		}
+	}
+	return nil
+}
+
+func scanWebhookEndpoint(row rowScanner) (WebhookEndpoint, error) {
+	var endpoint WebhookEndpoint
+	var events string
+	err := row.Scan(&endpoint.ID, &endpoint.URL, &events, &endpoint.Active, &endpoint.CreatedAt, &endpoint.UpdatedAt)
+	if err != nil {
+		return endpoint, err
+	}
+	err = json.Unmarshal([]byte(events), &endpoint.Events)
+	return endpoint, err
+}
+
+const webhookEndpointColumns = `id, url, events, active, created_at, updated_at`
+
+func getWebhooks(c *gin.Context) {
+	rows, err := db.Query(`SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints ORDER BY created_at`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	endpoints := []WebhookEndpoint{}
+	for rows.Next() {
+		endpoint, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, endpoints)
+}
+
+// createWebhook registers an endpoint. The signing secret is generated when
+// none is given and is only ever returned here.
+func createWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateWebhookRequest(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		req.Secret = secret
+	}
+	events, _ := json.Marshal(req.Events)
+	now := time.Now()
+	endpoint := WebhookEndpoint{
+		ID:        uuid.New().String(),
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Events:    req.Events,
+		Active:    req.Active == nil || *req.Active,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	_, err := db.Exec(`INSERT INTO webhook_endpoints (id, url, secret, events, active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		endpoint.ID, endpoint.URL, endpoint.Secret, string(events), endpoint.Active, now, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, endpoint)
+}
+
+// updateWebhook replaces an endpoint's settings; the secret is kept unless a new one is given
+func updateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateWebhookRequest(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, _ := json.Marshal(req.Events)
+
+	result, err := db.Exec(`UPDATE webhook_endpoints SET url = ?, events = ?,
+			secret = COALESCE(NULLIF(?, ''), secret), active = COALESCE(?, active), updated_at = ?
+		WHERE id = ?`,
+		req.URL, string(events), req.Secret, req.Active, time.Now(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+
+	endpoint, err := scanWebhookEndpoint(db.QueryRow(`SELECT `+webhookEndpointColumns+` FROM webhook_endpoints WHERE id = ?`, c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, endpoint)
+}
+
+// deleteWebhook removes an endpoint along with its queue and history
+func deleteWebhook(c *gin.Context) {
+	id := c.Param("id")
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM webhook_endpoints WHERE id = ?`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM webhook_delivery_attempts
+		WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE endpoint_id = ?)`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE endpoint_id = ?`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// testWebhook queues a webhook.test event for one endpoint, regardless of
+// its subscriptions, to check the receiver and its signature verification
+func testWebhook(c *gin.Context) {
+	id := c.Param("id")
+	var exists int
+	err := db.QueryRow(`SELECT COUNT(*) FROM webhook_endpoints WHERE id = ?`, id).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+
+	now := time.Now()
+	envelope := webhookEnvelope{ID: uuid.New().String(), Type: webhookTest, CreatedAt: now.UTC(),
+		Data: gin.H{"message": "Test event from LocalHaven CMS"}}
+	payload, _ := json.Marshal(envelope)
+	deliveryID := uuid.New().String()
+	_, err = db.Exec(`INSERT INTO webhook_deliveries (id, endpoint_id, event_id, event, payload, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, deliveryID, id, envelope.ID, webhookTest, string(payload), now, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"deliveryId": deliveryID})
+}
+
+const webhookDeliveryColumns = `id, endpoint_id, event_id, event, payload, status, attempts,
+	next_attempt_at, last_status_code, COALESCE(last_error, ''), created_at, delivered_at`
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload string
+	var nextAttemptAt, deliveredAt sql.NullTime
+	var lastStatus sql.NullInt64
+	err := row.Scan(&d.ID, &d.EndpointID, &d.EventID, &d.Event, &payload, &d.Status, &d.Attempts,
+		&nextAttemptAt, &lastStatus, &d.LastError, &d.CreatedAt, &deliveredAt)
+	if err != nil {
+		return d, err
+	}
+	d.Payload = json.RawMessage(payload)
+	if nextAttemptAt.Valid {
+		d.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	if lastStatus.Valid {
+		code := int(lastStatus.Int64)
+		d.LastStatusCode = &code
+	}
+	return d, nil
+}
+
+// getWebhookDeliveries lists deliveries, newest first, optionally for one
+// endpoint (?endpoint=) or status (?status=pending|delivered|dead)
+func getWebhookDeliveries(c *gin.Context) {
+	var filter responseFilter
+	if endpoint := c.Query("endpoint"); endpoint != "" {
+		filter.add("endpoint_id = ?", endpoint)
+	}
+	if status := c.Query("status"); status != "" {
+		if status != "pending" && status != "delivered" && status != "dead" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, delivered or dead"})
+			return
+		}
+		filter.add("status = ?", status)
+	}
+	limit, offset, err := pagingParams(c, 50, 500)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := db.Query(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries`+filter.where()+`
+		ORDER BY created_at DESC, id LIMIT ? OFFSET ?`, append(filter.args, limit, offset)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// getWebhookDelivery returns one delivery with every attempt made
+func getWebhookDelivery(c *gin.Context) {
+	d, err := scanWebhookDelivery(db.QueryRow(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = ?`, c.Param("id")))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "delivery not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := db.Query(`SELECT attempted_at, status_code, COALESCE(error, ''), COALESCE(response_body, ''), duration_ms
+		FROM webhook_delivery_attempts WHERE delivery_id = ? ORDER BY id`, d.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	d.History = []WebhookAttempt{}
+	for rows.Next() {
+		var attempt WebhookAttempt
+		var statusCode sql.NullInt64
+		if err := rows.Scan(&attempt.AttemptedAt, &statusCode, &attempt.Error, &attempt.ResponseBody, &attempt.DurationMs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if statusCode.Valid {
+			code := int(statusCode.Int64)
+			attempt.StatusCode = &code
+		}
+		d.History = append(d.History, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+// replayWebhookDelivery queues a delivery to be sent again with a fresh set
+// of attempts, whether it was dead-lettered or already delivered. The payload
+// and event id stay the same so receivers can deduplicate.
+func replayWebhookDelivery(c *gin.Context) {
+	result, err := db.Exec(`UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = ?,
+		delivered_at = NULL WHERE id = ? AND status != 'pending'`, time.Now(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		var status string
+		err := db.QueryRow(`SELECT status FROM webhook_deliveries WHERE id = ?`, c.Param("id")).Scan(&status)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "delivery not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "delivery is already queued"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Delivery queued"})
+}
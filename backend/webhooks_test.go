@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWebhookRetryDelay(t *testing.T) {
+	t.Setenv("WEBHOOK_RETRY_BASE_SECONDS", "30")
+	tests := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		20: maxWebhookRetryDelay,
+	}
+	for attempts, want := range tests {
+		if got := webhookRetryDelay(attempts); got != want {
+			t.Errorf("webhookRetryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestValidateWebhookRequest(t *testing.T) {
+	tests := []struct {
+		req     WebhookRequest
+		wantErr bool
+	}{
+		{WebhookRequest{URL: " https://hooks.example.com/survey ", Events: []string{webhookResponseCreated}}, false},
+		{WebhookRequest{URL: "ftp://hooks.example.com", Events: []string{webhookResponseCreated}}, true},
+		{WebhookRequest{URL: "/relative", Events: []string{webhookResponseCreated}}, true},
+		{WebhookRequest{URL: "https://hooks.example.com"}, true},
+		{WebhookRequest{URL: "https://hooks.example.com", Events: []string{webhookTest}}, true},
+	}
+	for _, tt := range tests {
+		if err := validateWebhookRequest(&tt.req); (err != nil) != tt.wantErr {
+			t.Errorf("validateWebhookRequest(%+v) error = %v, wantErr %v", tt.req, err, tt.wantErr)
+		}
+	}
+}
+
+// webhookReceiver is a test endpoint answering with a configurable status
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	w.WriteHeader(r.status)
+	fmt.Fprintf(w, "status %d", r.status)
+}
+
+func (r *webhookReceiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *webhookReceiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+// verifyTestSignature checks an X-Webhook-Signature header the way a receiver would
+func verifyTestSignature(t *testing.T, secret, header string, body []byte) {
+	t.Helper()
+	var timestamp int64
+	var signature string
+	if _, err := fmt.Sscanf(strings.Replace(header, ",v1=", " ", 1), "t=%d %s", &timestamp, &signature); err != nil {
+		t.Fatalf("malformed signature header %q: %v", header, err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", timestamp, body)
+	if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("signature %s does not match the body, want %s", signature, want)
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age < 0 || age > time.Minute {
+		t.Errorf("signature timestamp is %v old", age)
+	}
+}
+
+// createTestWebhook registers an endpoint through the admin handler
+func createTestWebhook(t *testing.T, body string) WebhookEndpoint {
+	t.Helper()
+	recorder := callHandler(createWebhook, "POST", "/webhooks", strings.NewReader(body))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("createWebhook returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var endpoint WebhookEndpoint
+	if err := json.Unmarshal(recorder.Body.Bytes(), &endpoint); err != nil {
+		t.Fatal(err)
+	}
+	return endpoint
+}
+
+func getTestDelivery(t *testing.T, id string) WebhookDelivery {
+	t.Helper()
+	recorder := callHandler(getWebhookDelivery, "GET", "/webhooks/deliveries/"+id, nil, gin.Param{Key: "id", Value: id})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("getWebhookDelivery returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var delivery WebhookDelivery
+	if err := json.Unmarshal(recorder.Body.Bytes(), &delivery); err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	openTestDB(t)
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "3")
+	t.Setenv("WEBHOOK_RETRY_BASE_SECONDS", "30")
+	receiver := &webhookReceiver{status: http.StatusInternalServerError}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	endpoint := createTestWebhook(t, fmt.Sprintf(`{"url":%q,"secret":"whsec_test","events":["response.created"]}`, server.URL))
+	response := submitTestSurvey(t, `{"role":"developer","email":"ada@example.com"}`)
+
+	var queued []WebhookDelivery
+	recorder := callHandler(getWebhookDeliveries, "GET", "/webhooks/deliveries?endpoint="+endpoint.ID, nil)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &queued); err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].Event != webhookResponseCreated || queued[0].Status != "pending" {
+		t.Fatalf("queued deliveries = %+v", queued)
+	}
+	deliveryID := queued[0].ID
+
+	// Failed attempts are retried after a delay that doubles each time
+	now := time.Now()
+	for attempt, wait := range []time.Duration{30 * time.Second, time.Minute} {
+		if err := deliverDueWebhooks(now); err != nil {
+			t.Fatal(err)
+		}
+		delivery := getTestDelivery(t, deliveryID)
+		if delivery.Status != "pending" || delivery.Attempts != attempt+1 || delivery.NextAttemptAt == nil {
+			t.Fatalf("after attempt %d: %+v", attempt+1, delivery)
+		}
+		attempted := delivery.History[len(delivery.History)-1].AttemptedAt
+		if delay := delivery.NextAttemptAt.Sub(attempted); delay < wait || delay > wait+5*time.Second {
+			t.Errorf("attempt %d retries after %v, want %v", attempt+1, delay, wait)
+		}
+		// Nothing is sent again before the retry is due
+		if err := deliverDueWebhooks(now); err != nil {
+			t.Fatal(err)
+		}
+		if got := receiver.received(); got != attempt+1 {
+			t.Errorf("receiver got %d requests before the retry was due, want %d", got, attempt+1)
+		}
+		now = delivery.NextAttemptAt.Add(time.Second)
+	}
+
+	for i, req := range receiver.requests {
+		if req.Header.Get("X-Webhook-Event") != webhookResponseCreated || req.Header.Get("X-Webhook-Delivery") != deliveryID {
+			t.Errorf("request %d headers = %v", i, req.Header)
+		}
+		verifyTestSignature(t, "whsec_test", req.Header.Get("X-Webhook-Signature"), receiver.bodies[i])
+	}
+	var envelope struct {
+		ID   string         `json:"id"`
+		Type string         `json:"type"`
+		Data SurveyResponse `json:"data"`
+	}
+	if err := json.Unmarshal(receiver.bodies[0], &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Type != webhookResponseCreated || envelope.Data.ID != response.ID {
+		t.Errorf("payload = %+v", envelope)
+	}
+
+	// The last failed attempt moves the delivery to the dead-letter list
+	if err := deliverDueWebhooks(now); err != nil {
+		t.Fatal(err)
+	}
+	dead := getTestDelivery(t, deliveryID)
+	if dead.Status != "dead" || dead.Attempts != 3 || dead.NextAttemptAt != nil || len(dead.History) != 3 {
+		t.Fatalf("after the last attempt: %+v", dead)
+	}
+	if code := dead.History[2].StatusCode; code == nil || *code != http.StatusInternalServerError || dead.History[2].ResponseBody != "status 500" {
+		t.Errorf("last attempt = %+v", dead.History[2])
+	}
+	recorder = callHandler(getWebhookDeliveries, "GET", "/webhooks/deliveries?status=dead", nil)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &queued); err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].ID != deliveryID {
+		t.Errorf("dead-lettered deliveries = %+v", queued)
+	}
+
+	// Replaying sends the same event again with a fresh set of attempts
+	receiver.setStatus(http.StatusNoContent)
+	param := gin.Param{Key: "id", Value: deliveryID}
+	if replay := callHandler(replayWebhookDelivery, "POST", "/webhooks/deliveries/"+deliveryID+"/replay", nil, param); replay.Code != http.StatusAccepted {
+		t.Fatalf("replay returned %d: %s", replay.Code, replay.Body.String())
+	}
+	if again := callHandler(replayWebhookDelivery, "POST", "/webhooks/deliveries/"+deliveryID+"/replay", nil, param); again.Code != http.StatusConflict {
+		t.Errorf("replaying a queued delivery returned %d, want 409", again.Code)
+	}
+	if err := deliverDueWebhooks(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	delivered := getTestDelivery(t, deliveryID)
+	if delivered.Status != "delivered" || delivered.Attempts != 1 || delivered.DeliveredAt == nil || len(delivered.History) != 4 {
+		t.Errorf("after replay: %+v", delivered)
+	}
+	if got := receiver.received(); got != 4 || string(receiver.bodies[3]) != string(receiver.bodies[0]) {
+		t.Errorf("receiver got %d requests, want the replay to resend the original payload", got)
+	}
+
+	missing := callHandler(replayWebhookDelivery, "POST", "/webhooks/deliveries/missing/replay", nil, gin.Param{Key: "id", Value: "missing"})
+	if missing.Code != http.StatusNotFound {
+		t.Errorf("replaying an unknown delivery returned %d, want 404", missing.Code)
+	}
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	openTestDB(t)
+	created := createTestWebhook(t, `{"url":"https://hooks.example.com/created","events":["response.created"]}`)
+	beta := createTestWebhook(t, `{"url":"https://hooks.example.com/beta","events":["beta.signup","response.deleted"]}`)
+	inactive := createTestWebhook(t, `{"url":"https://hooks.example.com/off","events":["response.created"],"active":false}`)
+	if !strings.HasPrefix(created.Secret, "whsec_") {
+		t.Errorf("generated secret %q", created.Secret)
+	}
+
+	submitTestSurvey(t, `{"role":"developer"}`)
+	signup := submitTestSurvey(t, `{"role":"designer","betaInterest":true,"email":"ada@example.com"}`)
+	deleted := callHandler(deleteResult, "DELETE", "/results/"+signup.ID, nil, gin.Param{Key: "id", Value: signup.ID})
+	if deleted.Code != http.StatusOK {
+		t.Fatalf("deleteResult returned %d", deleted.Code)
+	}
+
+	counts := map[string][]string{}
+	rows, err := db.Query(`SELECT endpoint_id, event FROM webhook_deliveries ORDER BY created_at`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var endpointID, event string
+		if err := rows.Scan(&endpointID, &event); err != nil {
+			t.Fatal(err)
+		}
+		counts[endpointID] = append(counts[endpointID], event)
+	}
+	if got := counts[created.ID]; len(got) != 2 {
+		t.Errorf("response.created endpoint got %v, want both submissions", got)
+	}
+	if got := counts[beta.ID]; len(got) != 2 || got[0] != webhookBetaSignup || got[1] != webhookResponseDeleted {
+		t.Errorf("beta endpoint got %v, want a signup and a deletion", got)
+	}
+	if got := counts[inactive.ID]; len(got) != 0 {
+		t.Errorf("inactive endpoint got %v", got)
+	}
+}
+
+func TestDeleteRedactsWebhookPayloads(t *testing.T) {
+	openTestDB(t)
+	createTestWebhook(t, `{"url":"https://hooks.example.com/all","events":["response.created","beta.signup"]}`)
+	deleted := submitTestSurvey(t, `{"role":"designer","betaInterest":true,"email":"ada@example.com"}`)
+	kept := submitTestSurvey(t, `{"role":"developer","betaInterest":true,"email":"grace@example.com"}`)
+	// Dead-lettered deliveries can be replayed, so they are redacted too
+	if _, err := db.Exec(`UPDATE webhook_deliveries SET status = 'dead' WHERE event = ?`, webhookBetaSignup); err != nil {
+		t.Fatal(err)
+	}
+	deleteTestResponse(t, deleted.ID)
+
+	rows, err := db.Query(`SELECT event, payload FROM webhook_deliveries WHERE event != ?`, webhookResponseDeleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var event, payload string
+		if err := rows.Scan(&event, &payload); err != nil {
+			t.Fatal(err)
+		}
+		count++
+		if strings.Contains(payload, "ada@") {
+			t.Errorf("%s payload still holds the deleted respondent's email: %s", event, payload)
+		}
+		if strings.Contains(payload, kept.ID) && !strings.Contains(payload, "grace@") {
+			t.Errorf("%s payload of the kept response lost its email: %s", event, payload)
+		}
+		var envelope webhookEnvelope
+		if err := json.Unmarshal([]byte(payload), &envelope); err != nil {
+			t.Errorf("redacted payload is not valid JSON: %v", err)
+		}
+	}
+	if count != 4 {
+		t.Errorf("got %d deliveries, want a response.created and a beta.signup per response", count)
+	}
+}
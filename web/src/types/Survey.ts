@@ -42,6 +42,12 @@ export interface SurveyResponse {
   offlineWorkarounds?: string;
   currentChangeConflictHandling?: string;
   versionControlChallenges?: string;
+  language?: string;
+}
+
+export interface SurveyOption {
+  value: string;
+  label: string;
 }
 
 export interface FormField {
@@ -50,7 +56,7 @@ export interface FormField {
   label: string;
   type: 'text' | 'email' | 'select' | 'textarea' | 'radio' | 'checkbox';
   required?: boolean;
-  options?: string[];
+  options?: SurveyOption[];
   dependsOn?: keyof SurveyResponse;
   dependsOnValue?: string | boolean;
   placeholder?: string;
@@ -63,6 +69,14 @@ export interface FormField {
   };
 }
 
+// Served by GET /survey/definition in the language negotiated for the browser
+export interface SurveyDefinition {
+  language: string;
+  languages: string[];
+  steps: number;
+  questions: FormField[];
+}
+
 export interface ChartData {
   labels: string[];
   datasets: Array<{